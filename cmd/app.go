@@ -0,0 +1,206 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wfaler/jail/jail"
+)
+
+// appPath is a file or directory in the home directory that an application
+// keeps its state in, such as logins and settings
+type appPath struct {
+	path     string // relative to $HOME
+	env      string // variable that moves the path elsewhere when set on the host, e.g. GH_CONFIG_DIR
+	file     bool   // a single file rather than a directory
+	readOnly bool
+}
+
+// appDefinition describes the host state an application needs inside the jail
+type appDefinition struct {
+	commands []string // command names that enable the application without --app
+	paths    []appPath
+}
+
+// builtinApps returns the application definitions jail knows about, by name.
+// State is mounted read-write so that logins and settings made in the jail persist.
+func builtinApps() map[string]*appDefinition {
+	return map[string]*appDefinition{
+		"claude": {commands: []string{"claude"}, paths: []appPath{
+			{path: ".claude", env: "CLAUDE_CONFIG_DIR"},
+			{path: ".claude.json", file: true},
+		}},
+		"codex": {commands: []string{"codex"}, paths: []appPath{
+			{path: ".codex", env: "CODEX_HOME"},
+		}},
+		"aider": {commands: []string{"aider"}, paths: []appPath{
+			{path: ".aider"},
+			{path: ".aider.conf.yml", file: true, readOnly: true},
+			{path: ".aider.model.settings.yml", file: true, readOnly: true},
+		}},
+		"cursor": {commands: []string{"cursor-agent"}, paths: []appPath{
+			{path: ".cursor"},
+		}},
+		"gh": {commands: []string{"gh"}, paths: []appPath{
+			{path: ".config/gh", env: "GH_CONFIG_DIR"},
+		}},
+		"aws": {commands: []string{"aws"}, paths: []appPath{
+			{path: ".aws"},
+		}},
+		"gcloud": {commands: []string{"gcloud", "gsutil", "bq"}, paths: []appPath{
+			{path: ".config/gcloud", env: "CLOUDSDK_CONFIG"},
+		}},
+	}
+}
+
+// appDefinition returns the user-defined application with the given name,
+// adding an empty one if needed
+func (c *jailConfig) appDefinition(name string) *appDefinition {
+	if c.appDefs == nil {
+		c.appDefs = make(map[string]*appDefinition)
+	}
+	app, ok := c.appDefs[name]
+	if !ok {
+		app = &appDefinition{}
+		c.appDefs[name] = app
+	}
+	return app
+}
+
+// parseLine applies a line inside an app section: "command <name>...",
+// "dir <path> [options]" or "file <path> [options]"
+func (a *appDefinition) parseLine(line string) error {
+	fields := strings.Fields(line)
+
+	switch fields[0] {
+	case "command":
+		if len(fields) < 2 {
+			return fmt.Errorf("usage: command <name>...")
+		}
+		for _, f := range fields[1:] {
+			name, err := parseCommandName(f)
+			if err != nil {
+				return err
+			}
+			a.commands = append(a.commands, name)
+		}
+	case "dir", "file":
+		p, err := parseAppPath(fields)
+		if err != nil {
+			return err
+		}
+		a.paths = append(a.paths, p)
+	default:
+		return fmt.Errorf("unknown app directive %q (expected command, dir or file)", fields[0])
+	}
+
+	return nil
+}
+
+// parseAppPath parses the fields of a "dir" or "file" line such as
+// "dir .config/tool rw env=TOOL_HOME". Paths are relative to the home
+// directory; options are "ro", "rw" (default) and "env=<VAR>".
+func parseAppPath(fields []string) (appPath, error) {
+	if len(fields) < 2 {
+		return appPath{}, fmt.Errorf("usage: %s <path relative to $HOME> [ro|rw] [env=<VAR>]", fields[0])
+	}
+
+	path := filepath.Clean(strings.TrimPrefix(fields[1], "~/"))
+	if path == "~" || filepath.IsAbs(path) || path == "." || path == ".." || strings.HasPrefix(path, "../") {
+		return appPath{}, fmt.Errorf("app path %s must be inside the home directory", fields[1])
+	}
+	p := appPath{path: path, file: fields[0] == "file"}
+
+	for _, option := range fields[2:] {
+		switch {
+		case option == "ro":
+			p.readOnly = true
+		case option == "rw":
+			p.readOnly = false
+		case strings.HasPrefix(option, "env="):
+			p.env = strings.TrimPrefix(option, "env=")
+			if p.env == "" || strings.ContainsAny(p.env, "=") {
+				return appPath{}, fmt.Errorf("invalid app path option %q", option)
+			}
+		default:
+			return appPath{}, fmt.Errorf("unknown app path option %q (expected ro, rw or env=<VAR>)", option)
+		}
+	}
+	return p, nil
+}
+
+// selectApps returns the names of the applications whose state is mounted:
+// those enabled with --app or the "app" directive, and those listing the base
+// name of the command
+func (c *jailConfig) selectApps(args *jailArgs) ([]string, error) {
+	enabled := make(map[string]bool)
+	for _, name := range append(append([]string{}, c.apps...), args.apps...) {
+		if _, ok := c.appDefs[name]; !ok {
+			return nil, fmt.Errorf("unknown app %q (expected one of: %s)", name, strings.Join(c.appNames(), ", "))
+		}
+		enabled[name] = true
+	}
+
+	if args.cmdName != "" {
+		command := filepath.Base(args.cmdName)
+		for name, app := range c.appDefs {
+			for _, cmd := range app.commands {
+				if cmd == command {
+					enabled[name] = true
+				}
+			}
+		}
+	}
+
+	return sortedKeys(enabled), nil
+}
+
+// appNames returns the names of all application definitions in order
+func (c *jailConfig) appNames() []string {
+	return sortedKeys(c.appDefs)
+}
+
+// location returns the host path of p for the given home directory. A variable
+// naming another absolute path takes precedence.
+func (p appPath) location(home string) string {
+	if p.env != "" {
+		if value := os.Getenv(p.env); filepath.IsAbs(value) {
+			return filepath.Clean(value)
+		}
+	}
+	if home == "" {
+		return ""
+	}
+	return filepath.Join(home, p.path)
+}
+
+// planApps returns the bind mounts of the state of the named applications,
+// from the host home directory to the same path in the jail. Paths that do
+// not exist on the host are skipped.
+func planApps(home string, apps map[string]*appDefinition, names []string) ([]jail.Mount, error) {
+	var mounts []jail.Mount
+	for _, name := range names {
+		for _, p := range apps[name].paths {
+			path := p.location(home)
+			if path == "" {
+				continue
+			}
+			m := mountEntry{source: path, target: path, readOnly: p.readOnly}
+			pm := jail.Mount{Type: jail.MountBind, Source: path, Target: path, Options: m.options(), File: p.file, Origin: "app " + name}
+
+			info, err := os.Stat(path)
+			switch {
+			case err != nil:
+				pm.Skipped = "nothing to preserve yet"
+			case info.IsDir() && p.file:
+				return nil, fmt.Errorf("app %s: %s is a directory, expected a file", name, path)
+			case !info.IsDir() && !p.file:
+				return nil, fmt.Errorf("app %s: %s is not a directory", name, path)
+			}
+			mounts = append(mounts, pm)
+		}
+	}
+	return mounts, nil
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseAppPath tests parsing of app "dir" and "file" lines
+func TestParseAppPath(t *testing.T) {
+	tests := []struct {
+		fields []string
+		want   appPath
+	}{
+		{[]string{"dir", ".config/tool"}, appPath{path: ".config/tool"}},
+		{[]string{"dir", "~/.tool/"}, appPath{path: ".tool"}},
+		{[]string{"file", ".toolrc", "ro"}, appPath{path: ".toolrc", file: true, readOnly: true}},
+		{[]string{"dir", ".tool", "ro", "rw", "env=TOOL_HOME"}, appPath{path: ".tool", env: "TOOL_HOME"}},
+	}
+	for _, tt := range tests {
+		got, err := parseAppPath(tt.fields)
+
+		require.NoError(t, err, tt.fields)
+		assert.Equal(t, tt.want, got, tt.fields)
+	}
+
+	for _, fields := range [][]string{{"dir"}, {"dir", "/etc/tool"}, {"dir", "~"}, {"dir", "../x"}, {"file", ".x", "noexec"}, {"dir", ".x", "env="}} {
+		_, err := parseAppPath(fields)
+		assert.Error(t, err, fields)
+	}
+}
+
+// TestBuiltinApps tests that the built-in definitions follow the rules for user definitions
+func TestBuiltinApps(t *testing.T) {
+	for name, app := range builtinApps() {
+		_, err := parseSectionName(name)
+		assert.NoError(t, err, name)
+		assert.NotEmpty(t, app.commands, name)
+		for _, p := range app.paths {
+			kind := "dir"
+			if p.file {
+				kind = "file"
+			}
+			parsed, err := parseAppPath([]string{kind, p.path})
+			require.NoError(t, err, name)
+			assert.Equal(t, p.path, parsed.path, name)
+		}
+	}
+}
+
+// TestReadJailConfigApps tests app sections and the "app" directive in .jail files
+func TestReadJailConfigApps(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, ".jail")
+
+	t.Run("app sections", func(t *testing.T) {
+		content := `app gh
+
+[app agent]
+command agent agent-cli
+dir .agent
+file .agent.json ro
+
+[profile cloud]
+app aws gcloud
+`
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+		cfg, err := readJailConfig(configPath)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"gh"}, cfg.apps)
+		assert.Equal(t, &appDefinition{
+			commands: []string{"agent", "agent-cli"},
+			paths:    []appPath{{path: ".agent"}, {path: ".agent.json", file: true, readOnly: true}},
+		}, cfg.appDefs["agent"])
+		assert.Equal(t, []string{"aws", "gcloud"}, cfg.profiles["cloud"].settings.apps)
+	})
+
+	t.Run("profile directives in an app section", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(configPath, []byte("[app agent]\nnet none\n"), 0644))
+
+		_, err := readJailConfig(configPath)
+
+		assert.ErrorContains(t, err, configPath+`:2: unknown app directive "net"`)
+	})
+}
+
+// TestSelectApps tests enabling apps by flag, directive and command name
+func TestSelectApps(t *testing.T) {
+	cfg := &jailConfig{appDefs: builtinApps()}
+
+	tests := []struct {
+		name   string
+		cfgApp []string
+		args   jailArgs
+		want   []string
+	}{
+		{"command name", nil, jailArgs{cmdName: "claude"}, []string{"claude"}},
+		{"command path", nil, jailArgs{cmdName: "/usr/bin/gsutil"}, []string{"gcloud"}},
+		{"flag", nil, jailArgs{cmdName: "make", apps: []string{"gh", "aws"}}, []string{"aws", "gh"}},
+		{"directive and command", []string{"gh"}, jailArgs{cmdName: "claude", apps: []string{"gh"}}, []string{"claude", "gh"}},
+		{"none", nil, jailArgs{cmdName: "make"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.apps = tt.cfgApp
+
+			got, err := cfg.selectApps(&tt.args)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("unknown app", func(t *testing.T) {
+		cfg.apps = nil
+
+		_, err := cfg.selectApps(&jailArgs{apps: []string{"vim"}})
+
+		assert.ErrorContains(t, err, `unknown app "vim" (expected one of: aider, aws, claude,`)
+	})
+}
+
+// TestAppPathLocation tests where app state is found on the host
+func TestAppPathLocation(t *testing.T) {
+	p := appPath{path: ".config/gh", env: "JAIL_TEST_GH_CONFIG_DIR"}
+
+	assert.Equal(t, "/home/dev/.config/gh", p.location("/home/dev"))
+	assert.Empty(t, p.location(""))
+
+	t.Setenv("JAIL_TEST_GH_CONFIG_DIR", "/srv/gh/")
+	assert.Equal(t, "/srv/gh", p.location("/home/dev"))
+
+	t.Setenv("JAIL_TEST_GH_CONFIG_DIR", "relative")
+	assert.Equal(t, "/home/dev/.config/gh", p.location("/home/dev"))
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/wfaler/jail/jail"
+)
+
+// jailConfig holds the settings read from .jail files
+type jailConfig struct {
+	mounts  []mountEntry
+	netMode string
+	allow   []allowRule // egress allowlist for the proxy network mode
+	seccomp string      // "default", "unconfined" or the path of a JSON profile
+	tmpfs   []tmpfsEntry
+	devMode string
+	devices []string // host devices added to a private /dev
+
+	snapshotExclude []string // workspace paths left out of --snapshot
+	writable        []string // workspace paths that stay writable with --ro
+	masks           []string // workspace path patterns hidden from the jailed process
+	unmask          []string // workspace path patterns exempt from masks
+	lockedMasks     []string // masks from the machine policy, which unmask does not exempt
+
+	env    map[string]string // environment variables set in the jail
+	limits map[string]uint64 // resource limits by name, see resourceLimits
+
+	profiles      map[string]*jailProfile // named profiles, see selectProfile
+	activeProfile string                  // the profile applied to this run, if any
+
+	warnings []string        // problems with the config that do not stop the run
+	history  []configSetting // every setting in the order it was made, see originOf
+
+	apps       []string                  // applications enabled with the "app" directive
+	appDefs    map[string]*appDefinition // application definitions by name, see builtinApps
+	activeApps []string                  // applications whose state is mounted in this run
+}
+
+// defaultMounts returns the system directories bound into every jail (read-only access to tools)
+func defaultMounts() []mountEntry {
+	return []mountEntry{
+		{source: "/bin", target: "/bin", readOnly: true},
+		{source: "/usr", target: "/usr", readOnly: true},
+		{source: "/lib", target: "/lib", readOnly: true},
+		{source: "/lib64", target: "/lib64", readOnly: true},
+		{source: "/sbin", target: "/sbin", readOnly: true},
+		{source: "/etc", target: "/etc", readOnly: true}, // Needed for DNS resolution and network configs
+	}
+}
+
+// maxInheritDepth bounds the walk from the workspace up to the repository root
+const maxInheritDepth = 16
+
+// loadJailConfig builds the effective configuration for a run: the default
+// mounts, then the config files of $HOME, of the directories from the
+// repository root down to jailDir's parent and of jailDir, then the selected
+// profile, then command-line overrides, then the machine policy. Each
+// directory's .jail is read before its .jail.yaml.
+func loadJailConfig(args *jailArgs) (*jailConfig, error) {
+	cfg := &jailConfig{mounts: defaultMounts(), tmpfs: defaultTmpfs(), masks: defaultMasks(), unmask: defaultUnmasks(), appDefs: builtinApps()}
+	cfg.record(configOrigin{scope: originBuiltin})
+
+	jailDir, err := filepath.Abs(args.jailDir)
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", args.jailDir, err)
+	}
+
+	// Read the global config from $HOME, then the inherited configs outermost
+	// first, then the workspace config, each adding to or overriding the last
+	for _, dir := range configDirs(jailDir, args.noInherit) {
+		if err := cfg.mergeDir(dir.path, dir.scope); err != nil {
+			return nil, err
+		}
+	}
+
+	// Check every profile so that a broken one is reported before it is needed
+	for name := range cfg.profiles {
+		if _, err := cfg.profileSettings(name); err != nil {
+			return nil, err
+		}
+	}
+	profile, err := cfg.selectProfile(args)
+	if err != nil {
+		return nil, err
+	}
+	if profile != "" {
+		settings, err := cfg.profileSettings(profile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.merge(settings)
+		cfg.activeProfile = profile
+	}
+
+	flags := &jailConfig{netMode: args.netMode, devMode: args.devMode, seccomp: args.seccomp, apps: args.apps}
+	flags.record(configOrigin{scope: originFlag})
+	cfg.merge(flags)
+
+	// The machine policy comes last so that neither config files nor flags can undo it
+	policy, err := readPolicy(policyConfigPath)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.applyPolicy(policy, args); err != nil {
+		return nil, err
+	}
+	if cfg.activeApps, err = cfg.selectApps(args); err != nil {
+		return nil, err
+	}
+	for _, name := range cfg.activeApps {
+		if _, ok := cfg.originOf("app " + name); !ok {
+			cfg.history = append(cfg.history, configSetting{text: "app " + name, origin: configOrigin{scope: originCommand, file: filepath.Base(args.cmdName)}})
+		}
+	}
+
+	defaults := &jailConfig{}
+	if cfg.netMode == "" {
+		defaults.netMode = netModeHost
+	}
+	if cfg.devMode == "" {
+		defaults.devMode = devModePrivate
+	}
+	if cfg.seccomp == "" {
+		defaults.seccomp = jail.SeccompDefault
+	}
+	defaults.record(configOrigin{scope: originBuiltin})
+	cfg.merge(defaults)
+
+	// Check each mount on its own first so that the error points at the entry
+	for _, m := range cfg.mounts {
+		if _, err := validateMounts([]mountEntry{m}); err != nil {
+			origin, _ := cfg.originOf(m.String())
+			return nil, fmt.Errorf("invalid mounts: %s: %w", origin.location(), err)
+		}
+	}
+	cfg.mounts, err = validateMounts(cfg.mounts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mounts: %w", err)
+	}
+	cfg.tmpfs, err = validateTmpfs(cfg.tmpfs, cfg.mounts)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tmpfs mounts: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// configDir is a directory whose config files apply to a run
+type configDir struct {
+	path  string
+	scope string // originGlobal, originInherited or originWorkspace
+}
+
+// configDirs returns the directories whose config files apply to jailDir, in
+// the order they are read: $HOME, then the inherited directories outermost
+// first, then jailDir itself
+func configDirs(jailDir string, noInherit bool) []configDir {
+	var dirs []configDir
+	hostHome := os.Getenv("HOME")
+	if hostHome != "" {
+		dirs = append(dirs, configDir{hostHome, originGlobal})
+	}
+	if !noInherit {
+		for _, dir := range inheritedConfigDirs(jailDir, hostHome) {
+			dirs = append(dirs, configDir{dir, originInherited})
+		}
+	}
+	return append(dirs, configDir{jailDir, originWorkspace})
+}
+
+// configFiles returns the config files of dir in the order they are read
+func configFiles(dir string) []string {
+	files := []string{filepath.Join(dir, ".jail")}
+	for _, name := range structuredConfigFiles {
+		files = append(files, filepath.Join(dir, name))
+	}
+	return files
+}
+
+// mergeDir merges the config files of dir. Files in inherited directories
+// above the workspace are skipped unless they belong to the current user, as
+// anyone may create them in shared directories such as /tmp.
+func (c *jailConfig) mergeDir(dir, scope string) error {
+	for _, path := range configFiles(dir) {
+		if scope == originInherited && !isOwnedByUser(path) {
+			if _, err := os.Stat(path); err == nil {
+				c.warnings = append(c.warnings, fmt.Sprintf("ignoring %s: not owned by the current user", path))
+			}
+			continue
+		}
+		fileCfg, err := readConfigFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		if fileCfg != nil {
+			fileCfg.fillOrigin(scope, path)
+			if scope != originGlobal {
+				fileCfg.restrictSeccomp()
+				fileCfg.restrictHostAccess(dir)
+			}
+		}
+		c.merge(fileCfg)
+	}
+	return nil
+}
+
+// inheritedConfigDirs returns the directories above jailDir whose config files
+// apply to it, outermost first: up to the nearest directory containing .git,
+// stopping below $HOME, which holds the global config, and at the filesystem
+// root or after maxInheritDepth directories
+func inheritedConfigDirs(jailDir, home string) []string {
+	var dirs []string
+	for dir := jailDir; !isRepoRoot(dir) && dir != home && len(dirs) < maxInheritDepth; {
+		parent := filepath.Dir(dir)
+		if parent == dir || parent == home {
+			break
+		}
+		dirs = append([]string{parent}, dirs...)
+		dir = parent
+	}
+	return dirs
+}
+
+// isRepoRoot reports whether dir is the top of a git repository or worktree
+func isRepoRoot(dir string) bool {
+	_, err := os.Lstat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// isOwnedByUser reports whether path exists and belongs to the user running jail.
+// Inside the jail's user namespace the user's files belong to uid 0, which is
+// then also the current uid, so the answer is the same in both stages.
+func isOwnedByUser(path string) bool {
+	info, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	return ok && int(stat.Uid) == os.Getuid()
+}
+
+// merge adds the mounts of other to c and lets settings made in other override c's
+func (c *jailConfig) merge(other *jailConfig) {
+	if other == nil {
+		return
+	}
+	c.mounts = append(c.mounts, other.mounts...)
+	c.allow = append(c.allow, other.allow...)
+	c.tmpfs = append(c.tmpfs, other.tmpfs...)
+	c.devices = append(c.devices, other.devices...)
+	c.snapshotExclude = append(c.snapshotExclude, other.snapshotExclude...)
+	c.writable = append(c.writable, other.writable...)
+	c.masks = append(c.masks, other.masks...)
+	c.unmask = append(c.unmask, other.unmask...)
+	c.lockedMasks = append(c.lockedMasks, other.lockedMasks...)
+	c.warnings = append(c.warnings, other.warnings...)
+	c.history = append(c.history, other.history...)
+	for name, value := range other.env {
+		if c.env == nil {
+			c.env = make(map[string]string)
+		}
+		c.env[name] = value
+	}
+	for name, value := range other.limits {
+		if c.limits == nil {
+			c.limits = make(map[string]uint64)
+		}
+		c.limits[name] = value
+	}
+	if other.devMode != "" {
+		c.devMode = other.devMode
+	}
+	if other.netMode != "" {
+		c.netMode = other.netMode
+	}
+	if other.seccomp != "" {
+		c.seccomp = other.seccomp
+	}
+	for name, profile := range other.profiles {
+		c.profile(name).merge(profile)
+	}
+	c.apps = append(c.apps, other.apps...)
+	// A later definition replaces an application, including a built-in one
+	for name, app := range other.appDefs {
+		if c.appDefs == nil {
+			c.appDefs = make(map[string]*appDefinition)
+		}
+		c.appDefs[name] = app
+	}
+}
+
+// readConfigFile reads a line-based or structured config file, depending on its name
+func readConfigFile(configPath string) (*jailConfig, error) {
+	for _, name := range structuredConfigFiles {
+		if filepath.Base(configPath) == name {
+			return readStructuredConfig(configPath)
+		}
+	}
+
+	cfg, err := readJailConfig(configPath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("reading %s: %w", configPath, err)
+	}
+	return cfg, err
+}
+
+// readJailConfig reads a .jail file. Each line is either a directive such as
+// "net none", "allow example.com", "tmpfs /var/tmp size=256m", "device /dev/kvm",
+// "seccomp unconfined", "snapshot-exclude node_modules", "writable build" or
+// "mask *.pem" or a mount entry (see parseMountEntry). A "[profile name]" or
+// "[app name]" line starts a section: the lines after it belong to that
+// profile or application definition. "include" and "include-dir" lines read
+// other files in place. Outside app sections, "~" and environment variables
+// are expanded; lines referring to unset variables are skipped with a warning.
+func readJailConfig(configPath string) (*jailConfig, error) {
+	return readJailConfigIncluding(configPath, nil)
+}
+
+// readJailConfigIncluding reads a .jail file included by the files in including
+func readJailConfigIncluding(configPath string, including []string) (*jailConfig, error) {
+	file, err := os.Open(configPath) //nolint:gosec // Config file path comes from workspace directory
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+	}()
+	if resolved, err := filepath.EvalSymlinks(configPath); err == nil {
+		including = append(including[:len(including):len(including)], resolved)
+	}
+
+	cfg := &jailConfig{}
+	parse := cfg.parseLineAt
+	section := ""
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		// Skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "[") {
+			kind, name, err := parseSectionHeader(line)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", configPath, lineNum, err)
+			}
+			if kind == "app" {
+				app := cfg.appDefinition(name)
+				parse = func(line string, _ configOrigin) error { return app.parseLine(line) }
+			} else {
+				parse = cfg.profile(name).parseLineAt
+			}
+			section = kind
+			continue
+		}
+
+		// App paths are relative to the home directory and are not expanded
+		if section != "app" {
+			expanded, missing := expandLine(line)
+			if len(missing) > 0 {
+				cfg.warnings = append(cfg.warnings, fmt.Sprintf("%s:%d: skipping %q: %s not set", configPath, lineNum, line, strings.Join(missing, ", ")))
+				continue
+			}
+			line = expanded
+		}
+
+		fields := strings.Fields(line)
+		if fields[0] == "include" || fields[0] == "include-dir" {
+			if section != "" {
+				return nil, fmt.Errorf("%s:%d: %s must come before the first [section]", configPath, lineNum, fields[0])
+			}
+			if err := cfg.include(configOrigin{file: configPath, line: lineNum}, fields, including); err != nil {
+				return nil, fmt.Errorf("%s:%d: %w", configPath, lineNum, err)
+			}
+			continue
+		}
+		if err := parse(line, configOrigin{file: configPath, line: lineNum}); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", configPath, lineNum, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	resolveSeccompPath(cfg, configPath)
+	return cfg, nil
+}
+
+// include merges the files named by an "include" or "include-dir" line. An
+// include-dir line whose directory does not exist only adds a warning.
+func (c *jailConfig) include(at configOrigin, fields []string, including []string) error {
+	path, err := includePath(at.file, fields)
+	if err != nil {
+		return err
+	}
+	files := []string{path}
+	if fields[0] == "include-dir" {
+		var exists bool
+		if files, exists, err = includeDirFiles(path); err != nil {
+			return err
+		}
+		if !exists {
+			c.warnings = append(c.warnings, fmt.Sprintf("%s: include-dir %s: no such directory", at.location(), path))
+		}
+	}
+
+	for _, f := range files {
+		included, err := readIncludedConfig(f, including)
+		if err != nil {
+			return err
+		}
+		c.merge(included)
+	}
+	return nil
+}
+
+// parseSectionHeader parses a "[profile name]" or "[app name]" line
+func parseSectionHeader(line string) (kind, name string, err error) {
+	inner, ok := strings.CutSuffix(strings.TrimPrefix(line, "["), "]")
+	fields := strings.Fields(inner)
+	if !ok || len(fields) != 2 || (fields[0] != "profile" && fields[0] != "app") {
+		return "", "", fmt.Errorf("invalid section %s (expected [profile <name>] or [app <name>])", line)
+	}
+	name, err = parseSectionName(fields[1])
+	return fields[0], name, err
+}
+
+// resolveSeccompPath makes a profile path relative to the file that names it
+func resolveSeccompPath(cfg *jailConfig, configPath string) {
+	if cfg.seccomp != "" && cfg.seccomp != jail.SeccompDefault && cfg.seccomp != jail.SeccompUnconfined && !filepath.IsAbs(cfg.seccomp) {
+		resolved := filepath.Join(filepath.Dir(configPath), cfg.seccomp)
+		for i := range cfg.history {
+			if cfg.history[i].text == "seccomp "+cfg.seccomp {
+				cfg.history[i].text = "seccomp " + resolved
+			}
+		}
+		cfg.seccomp = resolved
+	}
+	for _, p := range cfg.profiles {
+		resolveSeccompPath(p.settings, configPath)
+	}
+}
+
+// restrictSeccomp drops the seccomp settings of c and its profiles that could
+// weaken the syscall filter, with a warning: "unconfined" and profiles outside
+// the user's jail config directory and the directory of the machine policy.
+// It applies to config files that come with a repository; only the global
+// config, the machine policy and --seccomp may weaken the filter.
+func (c *jailConfig) restrictSeccomp() {
+	trusted := []string{filepath.Dir(policyConfigPath)}
+	if configHome, ok := lookupConfigVar("XDG_CONFIG_HOME"); ok {
+		trusted = append(trusted, filepath.Join(configHome, "jail"))
+	}
+	restrict := func(settings *jailConfig) {
+		switch {
+		case settings.seccomp == "" || settings.seccomp == jail.SeccompDefault:
+			return
+		case settings.seccomp != jail.SeccompUnconfined:
+			for _, dir := range trusted {
+				if isSubPath(filepath.Clean(settings.seccomp), dir) {
+					return
+				}
+			}
+		}
+		origin, _ := settings.originOf("seccomp " + settings.seccomp)
+		c.warnings = append(c.warnings, fmt.Sprintf("%s: ignoring \"seccomp %s\": only the global config, %s and --seccomp can weaken the syscall filter",
+			origin.location(), settings.seccomp, policyConfigPath))
+		settings.seccomp = ""
+	}
+	restrict(c)
+	for _, p := range c.profiles {
+		restrict(p.settings)
+	}
+}
+
+// restrictHostAccess drops the settings of c and its profiles that would give
+// the jail the host's devices, and makes its mounts of host paths outside dir
+// read-only. Like restrictSeccomp it applies to config files that come with a
+// repository, which could otherwise reach ~/.ssh and the like.
+func (c *jailConfig) restrictHostAccess(dir string) {
+	warn := func(origin configOrigin, format string, args ...any) {
+		c.warnings = append(c.warnings, origin.location()+": "+fmt.Sprintf(format, args...))
+	}
+	restrict := func(settings *jailConfig) {
+		if settings.devMode == devModeHost {
+			origin, _ := settings.originOf("dev host")
+			warn(origin, "ignoring \"dev host\": only the global config, %s and --dev can give the jail the host's devices", policyConfigPath)
+			settings.devMode = ""
+		}
+		for _, dev := range settings.devices {
+			origin, _ := settings.originOf("device " + dev)
+			warn(origin, "ignoring \"device %s\": only the global config and %s can add host devices", dev, policyConfigPath)
+		}
+		settings.devices = nil
+		for i, m := range settings.mounts {
+			if m.readOnly || isSubPath(filepath.Clean(m.source), dir) {
+				continue
+			}
+			origin, _ := settings.originOf(m.String())
+			warn(origin, "mounting %s read-only: only the global config and %s can mount host paths outside %s writable", m.source, policyConfigPath, dir)
+			settings.mounts[i].readOnly = true
+			settings.history = append(settings.history, configSetting{text: settings.mounts[i].String(), origin: origin})
+		}
+	}
+	restrict(c)
+	for _, p := range c.profiles {
+		restrict(p.settings)
+	}
+}
+
+// parseLine applies a single non-empty, non-comment .jail line to c
+func (c *jailConfig) parseLine(line string) error {
+	fields := strings.Fields(line)
+
+	switch fields[0] {
+	case "net":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: net <%s>", strings.Join(netModes, "|"))
+		}
+		mode, err := parseNetMode(fields[1])
+		if err != nil {
+			return err
+		}
+		c.netMode = mode
+	case "allow":
+		if len(fields) < 2 {
+			return fmt.Errorf("usage: allow <domain|*.domain|ip|cidr>[:port]...")
+		}
+		for _, f := range fields[1:] {
+			rule, err := parseAllowRule(f)
+			if err != nil {
+				return err
+			}
+			c.allow = append(c.allow, rule)
+		}
+	case "tmpfs":
+		entry, err := parseTmpfsEntry(fields[1:])
+		if err != nil {
+			return err
+		}
+		c.tmpfs = append(c.tmpfs, entry)
+	case "dev":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: dev <%s>", strings.Join(devModes, "|"))
+		}
+		mode, err := parseDevMode(fields[1])
+		if err != nil {
+			return err
+		}
+		c.devMode = mode
+	case "device":
+		if len(fields) < 2 {
+			return fmt.Errorf("usage: device </dev/path>...")
+		}
+		for _, f := range fields[1:] {
+			dev, err := parseDevice(f)
+			if err != nil {
+				return err
+			}
+			c.devices = append(c.devices, dev)
+		}
+	case "seccomp":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: seccomp <default|unconfined|profile.json>")
+		}
+		c.seccomp = fields[1]
+	case "snapshot-exclude":
+		patterns, err := parseSnapshotExclude(fields[1:])
+		if err != nil {
+			return err
+		}
+		c.snapshotExclude = append(c.snapshotExclude, patterns...)
+	case "writable":
+		if len(fields) < 2 {
+			return fmt.Errorf("usage: writable <workspace path>...")
+		}
+		for _, f := range fields[1:] {
+			path, err := parseWritablePath(f)
+			if err != nil {
+				return err
+			}
+			c.writable = append(c.writable, path)
+		}
+	case "mask", "unmask":
+		if len(fields) < 2 {
+			return fmt.Errorf("usage: %s <pattern>...", fields[0])
+		}
+		for _, f := range fields[1:] {
+			pattern, err := parseMaskPattern(f)
+			if err != nil {
+				return err
+			}
+			if fields[0] == "mask" {
+				c.masks = append(c.masks, pattern)
+			} else {
+				c.unmask = append(c.unmask, pattern)
+			}
+		}
+	case "app":
+		if len(fields) < 2 {
+			return fmt.Errorf("usage: app <name>...")
+		}
+		for _, f := range fields[1:] {
+			name, err := parseSectionName(f)
+			if err != nil {
+				return err
+			}
+			c.apps = append(c.apps, name)
+		}
+	case "extends", "command", "dir", "file":
+		return fmt.Errorf("%s is only valid in a [profile <name>] or [app <name>] section", fields[0])
+	default:
+		entry, err := parseMountEntry(line)
+		if err != nil {
+			return err
+		}
+		c.mounts = append(c.mounts, entry)
+	}
+
+	return nil
+}
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// configProblem is an issue found by "jail config check"
+type configProblem struct {
+	severity string // "error" or "warning"
+	location string // file:line or scope; empty if the message names it
+	message  string
+}
+
+// String formats the problem for output, e.g. "warning: /src/.jail:3: ..."
+func (p configProblem) String() string {
+	if p.location == "" {
+		return p.severity + ": " + p.message
+	}
+	return p.severity + ": " + p.location + ": " + p.message
+}
+
+// runConfigCommand runs "jail config check" or "jail config show". They take
+// the flags and command of a run, so the config is read as that run would.
+func runConfigCommand(w io.Writer, args []string) error {
+	if len(args) == 0 || (args[0] != "check" && args[0] != "show") {
+		return errors.New("usage: jail config check|show [flags] [<command>]")
+	}
+	parsed, rest, err := parseFlags(args[1:])
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 {
+		parsed.cmdName = rest[0]
+		parsed.cmdArgs = rest[1:]
+	}
+
+	if args[0] == "check" {
+		return runConfigCheck(w, parsed)
+	}
+	return runConfigShow(w, parsed)
+}
+
+// runConfigShow prints the effective config in .jail syntax, each entry
+// followed by where it was set
+func runConfigShow(w io.Writer, args *jailArgs) error {
+	cfg, err := loadJailConfig(args)
+	if err != nil {
+		return err
+	}
+
+	jailDir, err := filepath.Abs(args.jailDir)
+	if err != nil {
+		return err
+	}
+	header := []string{"# workspace " + jailDir}
+	if cfg.activeProfile != "" {
+		selected := "--profile"
+		if args.profile == "" {
+			selected = "command " + filepath.Base(args.cmdName)
+		}
+		header = append(header, fmt.Sprintf("# profile %s (%s)", cfg.activeProfile, selected))
+	}
+	for _, warning := range cfg.warnings {
+		header = append(header, "# warning: "+warning)
+	}
+	for _, line := range header {
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	settings := cfg.settings()
+	width := 0
+	for _, text := range settings {
+		width = max(width, len(text))
+	}
+	for _, text := range settings {
+		origin, _ := cfg.originOf(text)
+		if _, err := fmt.Fprintf(w, "%-*s  # %s\n", width, text, origin); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runConfigCheck reports syntax errors in every config file that applies to
+// the run, then missing, relative, duplicate and overlapping entries of the
+// effective config. Only errors make it fail.
+func runConfigCheck(w io.Writer, args *jailArgs) error {
+	problems, files := checkConfigFiles(args)
+	if !hasErrors(problems) {
+		cfg, err := loadJailConfig(args)
+		if err != nil {
+			problems = append(problems, configProblem{severity: "error", message: err.Error()})
+		} else {
+			problems = append(problems, checkConfig(cfg)...)
+		}
+	}
+
+	errorCount := 0
+	for _, p := range problems {
+		if p.severity == "error" {
+			errorCount++
+		}
+		if _, err := fmt.Fprintln(w, p); err != nil {
+			return err
+		}
+	}
+	if len(problems) == 0 {
+		_, err := fmt.Fprintf(w, "no problems found in %d config files\n", files)
+		return err
+	}
+	if errorCount > 0 {
+		return fmt.Errorf("found %d errors and %d warnings", errorCount, len(problems)-errorCount)
+	}
+	_, err := fmt.Fprintf(w, "found %d warnings\n", len(problems))
+	return err
+}
+
+// checkConfigFiles reads each config file on its own, so that a syntax error
+// in one does not hide those in others. It returns the problems and the number
+// of files read.
+func checkConfigFiles(args *jailArgs) ([]configProblem, int) {
+	var problems []configProblem
+	jailDir, err := filepath.Abs(args.jailDir)
+	if err != nil {
+		return []configProblem{{severity: "error", message: err.Error()}}, 0
+	}
+
+	files := 0
+	for _, dir := range configDirs(jailDir, args.noInherit) {
+		for _, path := range configFiles(dir.path) {
+			if dir.scope == originInherited && !isOwnedByUser(path) {
+				continue // Reported as a warning of the effective config
+			}
+			_, err := readConfigFile(path)
+			if os.IsNotExist(err) {
+				continue
+			}
+			files++
+			if err != nil {
+				problems = append(problems, configProblem{severity: "error", message: err.Error()})
+			}
+		}
+	}
+
+	if _, err := readPolicy(policyConfigPath); err != nil {
+		problems = append(problems, configProblem{severity: "error", message: err.Error()})
+	}
+	return problems, files
+}
+
+// checkConfig reports the problems of a loaded config that do not stop a run:
+// its warnings, mounts that are skipped or resolved unexpectedly, entries
+// repeating an earlier one and mounts inside other mounts
+func checkConfig(cfg *jailConfig) []configProblem {
+	var problems []configProblem
+	for _, warning := range cfg.warnings {
+		problems = append(problems, configProblem{severity: "warning", message: warning})
+	}
+
+	origins := make([]configOrigin, len(cfg.mounts))
+	for i, m := range cfg.mounts {
+		origins[i], _ = cfg.originOf(m.String())
+		if origins[i].scope == originBuiltin {
+			continue // System directories missing on this distribution are expected
+		}
+		warn := func(format string, args ...any) {
+			problems = append(problems, configProblem{"warning", origins[i].location(), fmt.Sprintf(format, args...)})
+		}
+		if !filepath.IsAbs(m.source) {
+			warn("mount source %s is a relative path, which is looked up from / rather than from the config file", m.source)
+		} else if _, err := os.Stat(m.source); os.IsNotExist(err) {
+			warn("mount source %s does not exist and is skipped", m.source)
+		}
+	}
+
+	// Later settings repeating an earlier one, e.g. a workspace .jail listing a global mount again
+	seen := make(map[string]configOrigin)
+	for _, s := range cfg.history {
+		first, ok := seen[s.text]
+		if !ok {
+			seen[s.text] = s.origin
+			continue
+		}
+		if s.origin.scope != originFlag && s.origin.scope != originCommand {
+			problems = append(problems, configProblem{"warning", s.origin.location(), fmt.Sprintf("%q repeats the entry from %s", s.text, first)})
+		}
+	}
+
+	for i, inner := range cfg.mounts {
+		for j, outer := range cfg.mounts {
+			if i == j || !isSubPath(inner.target, outer.target) {
+				continue
+			}
+			if origins[i].scope == originBuiltin && origins[j].scope == originBuiltin {
+				continue
+			}
+			problems = append(problems, configProblem{"warning", origins[i].location(), fmt.Sprintf("mount %s is inside the mount %s from %s and hides that part of it", inner.target, outer.target, origins[j])})
+		}
+	}
+	return problems
+}
+
+// hasErrors reports whether any of problems is an error
+func hasErrors(problems []configProblem) bool {
+	for _, p := range problems {
+		if p.severity == "error" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestRunConfigCommand tests "jail config check" and "jail config show"
+func TestRunConfigCommand(t *testing.T) {
+	home := t.TempDir()
+	workspace := t.TempDir()
+	t.Setenv("HOME", home)
+	defer func(path string) { policyConfigPath = path }(policyConfigPath)
+	policyConfigPath = filepath.Join(t.TempDir(), "config")
+	local := filepath.Join(workspace, ".jail")
+
+	t.Run("unknown subcommand", func(t *testing.T) {
+		err := runConfigCommand(&bytes.Buffer{}, []string{"edit"})
+
+		assert.ErrorContains(t, err, "usage: jail config check|show")
+	})
+
+	t.Run("check without problems", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(local, []byte("net none\n"), 0644))
+		defer os.Remove(local)
+		var out bytes.Buffer
+
+		err := runConfigCommand(&out, []string{"check", "-d", workspace})
+
+		require.NoError(t, err)
+		assert.Equal(t, "no problems found in 1 config files\n", out.String())
+	})
+
+	t.Run("check reports syntax errors in every file", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".jail"), []byte("net sideways\n"), 0644))
+		require.NoError(t, os.WriteFile(local, []byte("/opt/tools ro,rx\n"), 0644))
+		defer os.Remove(filepath.Join(home, ".jail"))
+		defer os.Remove(local)
+		var out bytes.Buffer
+
+		err := runConfigCommand(&out, []string{"check", "-d", workspace})
+
+		assert.ErrorContains(t, err, "found 2 errors")
+		assert.Contains(t, out.String(), home+"/.jail:1:")
+		assert.Contains(t, out.String(), local+":1: unknown mount option")
+	})
+
+	t.Run("check reports warnings with their location", func(t *testing.T) {
+		existing := t.TempDir()
+		config := "/nonexistent/jail-test\nopt/tools:/opt/tools\n" + existing + "\n" + existing + "/sub:" + existing + "/sub\n" + existing + "\n"
+		require.NoError(t, os.WriteFile(local, []byte(config), 0644))
+		defer os.Remove(local)
+		var out bytes.Buffer
+
+		err := runConfigCommand(&out, []string{"check", "-d", workspace})
+
+		require.NoError(t, err)
+		assert.Contains(t, out.String(), "warning: "+local+":1: mount source /nonexistent/jail-test does not exist")
+		assert.Contains(t, out.String(), "warning: "+local+":2: mount source opt/tools is a relative path")
+		assert.Contains(t, out.String(), "warning: "+local+":4: mount "+existing+"/sub is inside the mount "+existing+" from workspace "+local+":5")
+		assert.Contains(t, out.String(), "warning: "+local+":5: \""+existing+" ro\" repeats the entry from workspace "+local+":3")
+		assert.Contains(t, out.String(), "found 5 warnings")
+	})
+
+	t.Run("check reports errors of the merged config", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(local, []byte("[profile a]\nextends b\n"), 0644))
+		defer os.Remove(local)
+		var out bytes.Buffer
+
+		err := runConfigCommand(&out, []string{"check", "-d", workspace})
+
+		assert.Error(t, err)
+		assert.Contains(t, out.String(), `error: profile a extends unknown profile "b"`)
+	})
+
+	t.Run("show prints entries with their origin", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(local, []byte("[profile ci]\ncommand make\nnet none\n"), 0644))
+		defer os.Remove(local)
+		var out bytes.Buffer
+
+		err := runConfigCommand(&out, []string{"show", "-d", workspace, "--dev=host", "make", "test"})
+
+		require.NoError(t, err)
+		assert.Contains(t, out.String(), "# profile ci (command make)\n")
+		assert.Regexp(t, `(?m)^net none +# workspace `+local+`:3$`, out.String())
+		assert.Regexp(t, `(?m)^dev host +# flag$`, out.String())
+		assert.Regexp(t, `(?m)^/usr ro +# built-in$`, out.String())
+	})
+}
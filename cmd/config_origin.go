@@ -0,0 +1,145 @@
+package main
+
+import "fmt"
+
+// Origin scopes: which layer of the configuration a setting comes from
+const (
+	originBuiltin   = "built-in"
+	originGlobal    = "global"    // $HOME/.jail
+	originInherited = "inherited" // a directory between the repository root and the workspace
+	originWorkspace = "workspace"
+	originPolicy    = "policy" // the machine policy, see policyConfigPath
+	originFlag      = "flag"
+	originCommand   = "command" // selected by the name of the command
+)
+
+// configOrigin records where a setting was made
+type configOrigin struct {
+	scope string
+	file  string // the config file, or the command for originCommand
+	line  int    // 0 if not known
+}
+
+// String describes the origin, e.g. "workspace /src/app/.jail:3"
+func (o configOrigin) String() string {
+	if o.file == "" {
+		return o.scope
+	}
+	return o.scope + " " + o.location()
+}
+
+// location returns the file and line of the origin, or the scope if it has no file
+func (o configOrigin) location() string {
+	switch {
+	case o.file == "":
+		return o.scope
+	case o.line == 0:
+		return o.file
+	default:
+		return fmt.Sprintf("%s:%d", o.file, o.line)
+	}
+}
+
+// configSetting is an entry of the config in .jail syntax and where it was made
+type configSetting struct {
+	text   string
+	origin configOrigin
+}
+
+// settings returns the entries of c in .jail syntax, grouped by directive.
+// Environment variables and resource limits, which only the structured format
+// sets, are written as "env NAME=value" and "resource name=value". Once
+// applications are selected, the selected ones are listed.
+func (c *jailConfig) settings() []string {
+	var lines []string
+	for _, s := range []struct{ directive, value string }{
+		{"net", c.netMode},
+		{"dev", c.devMode},
+		{"seccomp", c.seccomp},
+	} {
+		if s.value != "" {
+			lines = append(lines, s.directive+" "+s.value)
+		}
+	}
+	for _, m := range c.mounts {
+		lines = append(lines, m.String())
+	}
+	for _, e := range c.tmpfs {
+		lines = append(lines, e.String())
+	}
+	for _, rule := range c.allow {
+		lines = append(lines, "allow "+rule.String())
+	}
+	for _, name := range sortedKeys(c.env) {
+		lines = append(lines, fmt.Sprintf("env %s=%s", name, c.env[name]))
+	}
+	for _, name := range sortedKeys(c.limits) {
+		lines = append(lines, fmt.Sprintf("resource %s=%d", name, c.limits[name]))
+	}
+
+	apps := c.apps
+	if c.activeApps != nil {
+		apps = c.activeApps
+	}
+	for _, list := range []struct {
+		directive string
+		values    []string
+	}{
+		{"device", c.devices},
+		{"writable", c.writable},
+		{"snapshot-exclude", c.snapshotExclude},
+		{"mask", c.masks},
+		{"mask", c.lockedMasks},
+		{"unmask", c.unmask},
+		{"app", apps},
+	} {
+		for _, value := range list.values {
+			lines = append(lines, list.directive+" "+value)
+		}
+	}
+	return lines
+}
+
+// record notes origin as the origin of every setting of c
+func (c *jailConfig) record(origin configOrigin) {
+	for _, text := range c.settings() {
+		c.history = append(c.history, configSetting{text: text, origin: origin})
+	}
+}
+
+// fillOrigin sets the scope and file of the recorded origins that have none,
+// including those of profiles
+func (c *jailConfig) fillOrigin(scope, file string) {
+	for i := range c.history {
+		if c.history[i].origin.scope == "" {
+			c.history[i].origin.scope = scope
+		}
+		if c.history[i].origin.file == "" {
+			c.history[i].origin.file = file
+		}
+	}
+	for _, p := range c.profiles {
+		p.settings.fillOrigin(scope, file)
+	}
+}
+
+// parseLineAt applies a .jail line like parseLine and records origin for the settings it makes
+func (c *jailConfig) parseLineAt(line string, origin configOrigin) error {
+	lineCfg := &jailConfig{}
+	if err := lineCfg.parseLine(line); err != nil {
+		return err
+	}
+	lineCfg.record(origin)
+	c.merge(lineCfg)
+	return nil
+}
+
+// originOf returns where the entry text was last set
+func (c *jailConfig) originOf(text string) (configOrigin, bool) {
+	for i := len(c.history) - 1; i >= 0; i-- {
+		if c.history[i].text == text {
+			return c.history[i].origin, true
+		}
+	}
+	return configOrigin{}, false
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestConfigSettings tests writing config entries in .jail syntax
+func TestConfigSettings(t *testing.T) {
+	t.Run("entries of each kind", func(t *testing.T) {
+		allow, err := parseAllowRule("*.github.com:443")
+		require.NoError(t, err)
+		cfg := &jailConfig{
+			netMode: netModeNone,
+			mounts: []mountEntry{
+				{source: "/opt/tools", target: "/opt/tools", readOnly: true},
+				{source: "/data", target: "/mnt/data/", noExec: true, noSuid: true},
+			},
+			tmpfs:  []tmpfsEntry{{target: "/scratch", size: "2g"}},
+			allow:  []allowRule{allow},
+			env:    map[string]string{"B": "2", "A": "1"},
+			limits: map[string]uint64{"nofile": 1024},
+			masks:  []string{"*.pem"},
+			apps:   []string{"gh"},
+		}
+
+		got := cfg.settings()
+
+		assert.Equal(t, []string{
+			"net none",
+			"/opt/tools ro",
+			"/data:/mnt/data rw,noexec,nosuid",
+			"tmpfs /scratch size=2g",
+			"allow *.github.com:443",
+			"env A=1",
+			"env B=2",
+			"resource nofile=1024",
+			"mask *.pem",
+			"app gh",
+		}, got)
+	})
+
+	t.Run("selected applications replace the app directives", func(t *testing.T) {
+		cfg := &jailConfig{apps: []string{"gh"}, activeApps: []string{"aws", "gh"}}
+
+		assert.Equal(t, []string{"app aws", "app gh"}, cfg.settings())
+	})
+}
+
+// TestAllowRuleString tests that allow rules are written back as parsed
+func TestAllowRuleString(t *testing.T) {
+	for _, rule := range []string{"example.com", "*.github.com", "example.com:443", "10.0.0.0/8", "192.168.1.10", "192.168.1.10:8080", "::1", "[::1]:443"} {
+		t.Run(rule, func(t *testing.T) {
+			parsed, err := parseAllowRule(rule)
+			require.NoError(t, err)
+
+			assert.Equal(t, rule, parsed.String())
+		})
+	}
+}
+
+// TestConfigOrigins tests which origin loadJailConfig records for each setting
+func TestConfigOrigins(t *testing.T) {
+	home := t.TempDir()
+	workspace := t.TempDir()
+	t.Setenv("HOME", home)
+	defer func(path string) { policyConfigPath = path }(policyConfigPath)
+	policyConfigPath = filepath.Join(t.TempDir(), "config")
+
+	global := filepath.Join(home, ".jail")
+	local := filepath.Join(workspace, ".jail")
+	structured := filepath.Join(workspace, ".jail.yaml")
+	require.NoError(t, os.WriteFile(global, []byte("/opt/global\nnet none\n"), 0644))
+	require.NoError(t, os.WriteFile(local, []byte("# tools\n/opt/global rw\n[profile ci]\ncommand make\nallow example.com\n"), 0644))
+	require.NoError(t, os.WriteFile(structured, []byte("version: 1\nenv:\n  GOFLAGS: -mod=mod\n"), 0644))
+
+	cfg, err := loadJailConfig(&jailArgs{jailDir: workspace, devMode: devModeHost, cmdName: "make"})
+	require.NoError(t, err)
+
+	tests := []struct {
+		text string
+		want configOrigin
+	}{
+		{"/usr ro", configOrigin{scope: originBuiltin}},
+		{"seccomp default", configOrigin{scope: originBuiltin}},
+		{"net none", configOrigin{scope: originGlobal, file: global, line: 2}},
+		{"/opt/global rw", configOrigin{scope: originWorkspace, file: local, line: 2}},
+		{"allow example.com", configOrigin{scope: originWorkspace, file: local, line: 5}},
+		{"env GOFLAGS=-mod=mod", configOrigin{scope: originWorkspace, file: structured, line: 3}},
+		{"dev host", configOrigin{scope: originFlag}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			origin, ok := cfg.originOf(tt.text)
+
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, origin)
+		})
+	}
+
+	t.Run("every effective entry has an origin", func(t *testing.T) {
+		for _, text := range cfg.settings() {
+			_, ok := cfg.originOf(text)
+			assert.True(t, ok, text)
+		}
+	})
+
+	t.Run("origin descriptions", func(t *testing.T) {
+		assert.Equal(t, "built-in", configOrigin{scope: originBuiltin}.String())
+		assert.Equal(t, "global "+global+":2", configOrigin{scope: originGlobal, file: global, line: 2}.String())
+		assert.Equal(t, structured, configOrigin{scope: originWorkspace, file: structured}.location())
+	})
+}
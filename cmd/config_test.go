@@ -0,0 +1,615 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wfaler/jail/jail"
+)
+
+// TestReadJailConfig tests the .jail file parsing
+func TestReadJailConfig(t *testing.T) {
+	t.Run("parse valid config file", func(t *testing.T) {
+		// Create a temporary config file
+		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+
+		content := `# This is a comment
+/home/user/.local/share/mise
+/home/user/.config/mise
+
+# Another comment
+/opt/custom-tools
+
+/usr/local/custom-lib
+`
+		_, err = tmpFile.WriteString(content)
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		cfg, err := readJailConfig(tmpFile.Name())
+
+		require.NoError(t, err)
+		assert.Len(t, cfg.mounts, 4)
+		assert.Equal(t, "/home/user/.local/share/mise", cfg.mounts[0].source)
+		assert.Equal(t, "/home/user/.config/mise", cfg.mounts[1].source)
+		assert.Equal(t, "/opt/custom-tools", cfg.mounts[2].source)
+		assert.Equal(t, "/usr/local/custom-lib", cfg.mounts[3].source)
+	})
+
+	t.Run("empty config file", func(t *testing.T) {
+		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+		tmpFile.Close()
+
+		cfg, err := readJailConfig(tmpFile.Name())
+
+		require.NoError(t, err)
+		assert.Empty(t, cfg.mounts)
+	})
+
+	t.Run("config file with only comments and whitespace", func(t *testing.T) {
+		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+
+		content := `# Comment 1
+# Comment 2
+
+
+
+# Comment 3
+`
+		_, err = tmpFile.WriteString(content)
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		cfg, err := readJailConfig(tmpFile.Name())
+
+		require.NoError(t, err)
+		assert.Empty(t, cfg.mounts)
+	})
+
+	t.Run("config with mount options", func(t *testing.T) {
+		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+
+		content := `/home/user/.cache/go-build rw
+/opt/data ro,noexec,nosuid
+/opt/tools
+`
+		_, err = tmpFile.WriteString(content)
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		cfg, err := readJailConfig(tmpFile.Name())
+
+		require.NoError(t, err)
+		require.Len(t, cfg.mounts, 3)
+		assert.Equal(t, mountEntry{source: "/home/user/.cache/go-build", target: "/home/user/.cache/go-build"}, cfg.mounts[0])
+		assert.Equal(t, mountEntry{source: "/opt/data", target: "/opt/data", readOnly: true, noExec: true, noSuid: true}, cfg.mounts[1])
+		assert.Equal(t, mountEntry{source: "/opt/tools", target: "/opt/tools", readOnly: true}, cfg.mounts[2])
+	})
+
+	t.Run("invalid mount option reports line number", func(t *testing.T) {
+		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+
+		_, err = tmpFile.WriteString("# comment\n/opt/data rw,bogus\n")
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		cfg, err := readJailConfig(tmpFile.Name())
+
+		assert.Error(t, err)
+		assert.Nil(t, cfg)
+		assert.Contains(t, err.Error(), ":2:")
+		assert.Contains(t, err.Error(), "bogus")
+	})
+
+	t.Run("net directive", func(t *testing.T) {
+		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+
+		_, err = tmpFile.WriteString("/opt/tools\nnet none\n")
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		cfg, err := readJailConfig(tmpFile.Name())
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeNone, cfg.netMode)
+		assert.Len(t, cfg.mounts, 1)
+	})
+
+	t.Run("allow directive", func(t *testing.T) {
+		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+
+		_, err = tmpFile.WriteString("net proxy\nallow registry.npmjs.org *.github.com:443\nallow 10.0.0.0/8\n")
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		cfg, err := readJailConfig(tmpFile.Name())
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeProxy, cfg.netMode)
+		require.Len(t, cfg.allow, 3)
+		assert.Equal(t, "registry.npmjs.org", cfg.allow[0].domain)
+		assert.Equal(t, "github.com", cfg.allow[1].domain)
+		assert.Equal(t, "10.0.0.0/8", cfg.allow[2].network.String())
+		assert.Empty(t, cfg.mounts)
+	})
+
+	t.Run("seccomp directive resolves profile relative to the file", func(t *testing.T) {
+		dir := t.TempDir()
+		path := filepath.Join(dir, ".jail")
+		require.NoError(t, os.WriteFile(path, []byte("seccomp profiles/strict.json\n"), 0644))
+
+		cfg, err := readJailConfig(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "profiles", "strict.json"), cfg.seccomp)
+	})
+
+	t.Run("seccomp directive keeps keywords", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), ".jail")
+		require.NoError(t, os.WriteFile(path, []byte("seccomp unconfined\n"), 0644))
+
+		cfg, err := readJailConfig(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, jail.SeccompUnconfined, cfg.seccomp)
+	})
+
+	t.Run("tmpfs directive", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), ".jail")
+		require.NoError(t, os.WriteFile(path, []byte("tmpfs /var/tmp size=256m\ntmpfs /run\n"), 0644))
+
+		cfg, err := readJailConfig(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, []tmpfsEntry{{target: "/var/tmp", size: "256m"}, {target: "/run", size: defaultTmpfsSize}}, cfg.tmpfs)
+		assert.Empty(t, cfg.mounts)
+	})
+
+	t.Run("dev and device directives", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), ".jail")
+		require.NoError(t, os.WriteFile(path, []byte("dev private\ndevice /dev/kvm /dev/net/tun\ndevice /dev/fuse\n"), 0644))
+
+		cfg, err := readJailConfig(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, devModePrivate, cfg.devMode)
+		assert.Equal(t, []string{"/dev/kvm", "/dev/net/tun", "/dev/fuse"}, cfg.devices)
+		assert.Empty(t, cfg.mounts)
+	})
+
+	t.Run("device outside /dev", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), ".jail")
+		require.NoError(t, os.WriteFile(path, []byte("device /etc/shadow\n"), 0644))
+
+		_, err := readJailConfig(path)
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "not below /dev")
+	})
+
+	t.Run("snapshot-exclude directive", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), ".jail")
+		require.NoError(t, os.WriteFile(path, []byte("snapshot-exclude node_modules target\nsnapshot-exclude *.log\n"), 0644))
+
+		cfg, err := readJailConfig(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"node_modules", "target", "*.log"}, cfg.snapshotExclude)
+		assert.Empty(t, cfg.mounts)
+
+		require.NoError(t, os.WriteFile(path, []byte("snapshot-exclude [\n"), 0644))
+		_, err = readJailConfig(path)
+		assert.ErrorContains(t, err, "invalid snapshot-exclude pattern")
+	})
+
+	t.Run("writable directive", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), ".jail")
+		require.NoError(t, os.WriteFile(path, []byte("writable build/ .cache\n"), 0644))
+
+		cfg, err := readJailConfig(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"build", ".cache"}, cfg.writable)
+		assert.Empty(t, cfg.mounts)
+
+		require.NoError(t, os.WriteFile(path, []byte("writable /home\n"), 0644))
+		_, err = readJailConfig(path)
+		assert.ErrorContains(t, err, "relative to the workspace")
+	})
+
+	t.Run("mask and unmask directives", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), ".jail")
+		require.NoError(t, os.WriteFile(path, []byte("mask secrets/** *.sqlite\nunmask .env.example\n"), 0644))
+
+		cfg, err := readJailConfig(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"secrets/**", "*.sqlite"}, cfg.masks)
+		assert.Equal(t, []string{".env.example"}, cfg.unmask)
+		assert.Empty(t, cfg.mounts)
+	})
+
+	t.Run("invalid net directive", func(t *testing.T) {
+		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+
+		_, err = tmpFile.WriteString("net offline\n")
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		cfg, err := readJailConfig(tmpFile.Name())
+
+		assert.Error(t, err)
+		assert.Nil(t, cfg)
+		assert.Contains(t, err.Error(), "unknown network mode")
+	})
+
+	t.Run("config file does not exist", func(t *testing.T) {
+		cfg, err := readJailConfig("/nonexistent/path/.jail")
+
+		assert.Error(t, err)
+		assert.Nil(t, cfg)
+	})
+
+	t.Run("config with mixed content", func(t *testing.T) {
+		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+		require.NoError(t, err)
+		defer os.Remove(tmpFile.Name())
+
+		content := `/first/path
+# Comment
+/second/path
+   /third/path/with/leading/whitespace
+/fourth/path
+`
+		_, err = tmpFile.WriteString(content)
+		require.NoError(t, err)
+		tmpFile.Close()
+
+		cfg, err := readJailConfig(tmpFile.Name())
+
+		require.NoError(t, err)
+		assert.Len(t, cfg.mounts, 4)
+		assert.Equal(t, "/first/path", cfg.mounts[0].source)
+		assert.Equal(t, "/second/path", cfg.mounts[1].source)
+		assert.Equal(t, "/third/path/with/leading/whitespace", cfg.mounts[2].source)
+		assert.Equal(t, "/fourth/path", cfg.mounts[3].source)
+	})
+}
+
+// TestLoadJailConfig tests merging of default, global and workspace configuration
+// TestParseSectionHeader tests parsing of "[profile name]" and "[app name]" lines
+func TestParseSectionHeader(t *testing.T) {
+	kind, name, err := parseSectionHeader("[profile go-test]")
+	require.NoError(t, err)
+	assert.Equal(t, "profile", kind)
+	assert.Equal(t, "go-test", name)
+
+	kind, name, err = parseSectionHeader("[ app  my.agent ]")
+	require.NoError(t, err)
+	assert.Equal(t, "app", kind)
+	assert.Equal(t, "my.agent", name)
+
+	for _, line := range []string{"[claude]", "[profile]", "[profile a b]", "[profile claude", "[section x]", "[profile ../x]"} {
+		_, _, err := parseSectionHeader(line)
+		assert.Error(t, err, line)
+	}
+}
+
+// TestInheritedConfigDirs tests the walk from the workspace up to the repository root
+func TestInheritedConfigDirs(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "src", "repo")
+	pkg := filepath.Join(repo, "services", "api")
+	require.NoError(t, os.MkdirAll(pkg, 0755))
+	require.NoError(t, os.Mkdir(filepath.Join(repo, ".git"), 0755))
+
+	t.Run("up to the repository root", func(t *testing.T) {
+		dirs := inheritedConfigDirs(pkg, root)
+
+		assert.Equal(t, []string{repo, filepath.Join(repo, "services")}, dirs)
+	})
+
+	t.Run("workspace is the repository root", func(t *testing.T) {
+		assert.Empty(t, inheritedConfigDirs(repo, root))
+	})
+
+	t.Run("a .git file marks a worktree", func(t *testing.T) {
+		worktree := filepath.Join(root, "worktree")
+		require.NoError(t, os.MkdirAll(filepath.Join(worktree, "cmd"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(worktree, ".git"), []byte("gitdir: /elsewhere\n"), 0644))
+
+		assert.Equal(t, []string{worktree}, inheritedConfigDirs(filepath.Join(worktree, "cmd"), root))
+	})
+
+	t.Run("stops below home without a repository", func(t *testing.T) {
+		dir := filepath.Join(root, "notes", "2024")
+		require.NoError(t, os.MkdirAll(dir, 0755))
+
+		assert.Equal(t, []string{filepath.Join(root, "notes")}, inheritedConfigDirs(dir, root))
+	})
+
+	t.Run("bounded without a repository or home", func(t *testing.T) {
+		dirs := inheritedConfigDirs(filepath.Join(root, "notes", "2024"), "")
+
+		assert.NotEmpty(t, dirs)
+		assert.LessOrEqual(t, len(dirs), maxInheritDepth)
+		assert.Equal(t, filepath.Join(root, "notes"), dirs[len(dirs)-1])
+	})
+}
+
+func TestLoadJailConfig(t *testing.T) {
+	home := t.TempDir()
+	workspace := t.TempDir()
+	t.Setenv("HOME", home)
+	defer func(path string) { policyConfigPath = path }(policyConfigPath)
+	policyConfigPath = filepath.Join(t.TempDir(), "config")
+
+	t.Run("defaults without config files", func(t *testing.T) {
+		cfg, err := loadJailConfig(&jailArgs{jailDir: workspace})
+
+		require.NoError(t, err)
+		assert.Equal(t, defaultMounts(), cfg.mounts)
+		assert.Equal(t, netModeHost, cfg.netMode)
+		assert.Equal(t, jail.SeccompDefault, cfg.seccomp)
+		assert.Equal(t, defaultTmpfs(), cfg.tmpfs)
+		assert.Equal(t, devModePrivate, cfg.devMode)
+		assert.Equal(t, defaultMasks(), cfg.masks)
+		assert.Equal(t, defaultUnmasks(), cfg.unmask)
+	})
+
+	t.Run("workspace config overrides global config", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".jail"), []byte("/opt/global\nnet none\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(workspace, ".jail"), []byte("/opt/local rw\nnet host\n"), 0644))
+		defer os.Remove(filepath.Join(home, ".jail"))
+		defer os.Remove(filepath.Join(workspace, ".jail"))
+
+		cfg, err := loadJailConfig(&jailArgs{jailDir: workspace})
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeHost, cfg.netMode)
+		targets := mountTargets(cfg.mounts)
+		assert.Contains(t, targets, "/opt/global")
+		assert.Contains(t, targets, "/opt/local")
+	})
+
+	t.Run("command-line flag overrides config files", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(workspace, ".jail"), []byte("net host\n"), 0644))
+		defer os.Remove(filepath.Join(workspace, ".jail"))
+
+		cfg, err := loadJailConfig(&jailArgs{jailDir: workspace, netMode: netModeNone, seccomp: jail.SeccompUnconfined})
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeNone, cfg.netMode)
+		assert.Equal(t, jail.SeccompUnconfined, cfg.seccomp)
+	})
+
+	t.Run("only the global config can weaken seccomp", func(t *testing.T) {
+		t.Setenv("XDG_CONFIG_HOME", "")
+		local := filepath.Join(workspace, ".jail")
+		trusted := filepath.Join(home, ".config", "jail", "gdb.json")
+		require.NoError(t, os.WriteFile(local, []byte("seccomp unconfined\n[profile repo]\nseccomp repo.json\n[profile gdb]\nseccomp "+trusted+"\n"), 0644))
+		defer os.Remove(local)
+
+		cfg, err := loadJailConfig(&jailArgs{jailDir: workspace})
+
+		require.NoError(t, err)
+		assert.Equal(t, jail.SeccompDefault, cfg.seccomp)
+		assert.Equal(t, []string{
+			local + `:1: ignoring "seccomp unconfined": only the global config, ` + policyConfigPath + " and --seccomp can weaken the syscall filter",
+			local + `:3: ignoring "seccomp ` + filepath.Join(workspace, "repo.json") + `": only the global config, ` + policyConfigPath + " and --seccomp can weaken the syscall filter",
+		}, cfg.warnings)
+
+		cfg, err = loadJailConfig(&jailArgs{jailDir: workspace, profile: "gdb"})
+		require.NoError(t, err)
+		assert.Equal(t, trusted, cfg.seccomp, "profiles in the user's config directory are trusted")
+
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".jail"), []byte("seccomp unconfined\n"), 0644))
+		defer os.Remove(filepath.Join(home, ".jail"))
+		require.NoError(t, os.WriteFile(local, []byte("net none\n"), 0644))
+		cfg, err = loadJailConfig(&jailArgs{jailDir: workspace})
+		require.NoError(t, err)
+		assert.Equal(t, jail.SeccompUnconfined, cfg.seccomp)
+		assert.Empty(t, cfg.warnings)
+	})
+
+	t.Run("only the global config can reach host devices and write host paths", func(t *testing.T) {
+		local := filepath.Join(workspace, ".jail")
+		build := filepath.Join(workspace, "build")
+		require.NoError(t, os.WriteFile(local, []byte("dev host\ndevice /dev/kvm\n/opt/keys rw\n"+build+" rw\n[profile ssh]\n~/.ssh rw\n"), 0644))
+		defer os.Remove(local)
+
+		cfg, err := loadJailConfig(&jailArgs{jailDir: workspace, profile: "ssh"})
+
+		require.NoError(t, err)
+		assert.Equal(t, devModePrivate, cfg.devMode)
+		assert.Empty(t, cfg.devices)
+		mounts := map[string]bool{}
+		for _, m := range cfg.mounts {
+			mounts[m.source] = m.readOnly
+		}
+		assert.Equal(t, true, mounts["/opt/keys"])
+		assert.Equal(t, true, mounts[filepath.Join(home, ".ssh")])
+		assert.Equal(t, false, mounts[build], "mounts inside the config's directory stay writable")
+		origin, ok := cfg.originOf("/opt/keys ro")
+		assert.True(t, ok)
+		assert.Equal(t, local+":3", origin.location())
+		assert.Equal(t, []string{
+			local + `:1: ignoring "dev host": only the global config, ` + policyConfigPath + " and --dev can give the jail the host's devices",
+			local + `:2: ignoring "device /dev/kvm": only the global config and ` + policyConfigPath + " can add host devices",
+			local + ":3: mounting /opt/keys read-only: only the global config and " + policyConfigPath + " can mount host paths outside " + workspace + " writable",
+			local + ":6: mounting " + filepath.Join(home, ".ssh") + " read-only: only the global config and " + policyConfigPath + " can mount host paths outside " + workspace + " writable",
+		}, cfg.warnings)
+
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".jail"), []byte("dev host\n/opt/keys rw\n"), 0644))
+		defer os.Remove(filepath.Join(home, ".jail"))
+		require.NoError(t, os.WriteFile(local, []byte("net none\n"), 0644))
+		cfg, err = loadJailConfig(&jailArgs{jailDir: workspace})
+		require.NoError(t, err)
+		assert.Equal(t, devModeHost, cfg.devMode)
+		assert.Contains(t, cfg.mounts, mountEntry{source: "/opt/keys", target: "/opt/keys"})
+		assert.Empty(t, cfg.warnings)
+	})
+
+	t.Run("invalid config file is reported", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(workspace, ".jail"), []byte("/opt/data rw,bogus\n"), 0644))
+		defer os.Remove(filepath.Join(workspace, ".jail"))
+
+		_, err := loadJailConfig(&jailArgs{jailDir: workspace})
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown mount option")
+	})
+
+	t.Run("structured config follows the line format in each directory", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".jail"), []byte("net none\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".jail.yaml"), []byte("version: 1\nnetwork:\n  mode: proxy\nenv:\n  A: home\n  B: home\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(workspace, ".jail.yaml"), []byte("version: 1\nenv:\n  B: workspace\nresources:\n  nofile: 64\n"), 0644))
+		defer os.Remove(filepath.Join(home, ".jail"))
+		defer os.Remove(filepath.Join(home, ".jail.yaml"))
+		defer os.Remove(filepath.Join(workspace, ".jail.yaml"))
+
+		cfg, err := loadJailConfig(&jailArgs{jailDir: workspace})
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeProxy, cfg.netMode)
+		assert.Equal(t, map[string]string{"A": "home", "B": "workspace"}, cfg.env)
+		assert.Equal(t, map[string]uint64{"nofile": 64}, cfg.limits)
+	})
+
+	t.Run("profiles are applied after all config files", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".jail"), []byte("[profile claude]\ncommand claude\nnet none\n/opt/global-claude\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(workspace, ".jail"), []byte("net host\n[profile claude]\n/opt/local-claude\n"), 0644))
+		defer os.Remove(filepath.Join(home, ".jail"))
+		defer os.Remove(filepath.Join(workspace, ".jail"))
+
+		cfg, err := loadJailConfig(&jailArgs{jailDir: workspace, cmdName: "claude"})
+
+		require.NoError(t, err)
+		assert.Equal(t, "claude", cfg.activeProfile)
+		assert.Equal(t, netModeNone, cfg.netMode)
+		targets := mountTargets(cfg.mounts)
+		assert.Contains(t, targets, "/opt/global-claude")
+		assert.Contains(t, targets, "/opt/local-claude")
+
+		cfg, err = loadJailConfig(&jailArgs{jailDir: workspace, cmdName: "make"})
+
+		require.NoError(t, err)
+		assert.Empty(t, cfg.activeProfile)
+		assert.Equal(t, netModeHost, cfg.netMode)
+		assert.NotContains(t, mountTargets(cfg.mounts), "/opt/global-claude")
+	})
+
+	t.Run("command-line flags override the profile", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(workspace, ".jail"), []byte("[profile offline]\nnet none\n"), 0644))
+		defer os.Remove(filepath.Join(workspace, ".jail"))
+
+		cfg, err := loadJailConfig(&jailArgs{jailDir: workspace, profile: "offline", netMode: netModeProxy})
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeProxy, cfg.netMode)
+	})
+
+	t.Run("broken profiles fail even when not selected", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(workspace, ".jail"), []byte("[profile a]\nextends b\n"), 0644))
+		defer os.Remove(filepath.Join(workspace, ".jail"))
+
+		_, err := loadJailConfig(&jailArgs{jailDir: workspace})
+
+		assert.ErrorContains(t, err, `profile a extends unknown profile "b"`)
+	})
+
+	t.Run("app definitions replace built-in ones", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".jail"), []byte("[app claude]\ncommand claude\ndir .claude ro\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(workspace, ".jail"), []byte("[profile cloud]\napp aws\n"), 0644))
+		defer os.Remove(filepath.Join(home, ".jail"))
+		defer os.Remove(filepath.Join(workspace, ".jail"))
+
+		cfg, err := loadJailConfig(&jailArgs{jailDir: workspace, cmdName: "claude", profile: "cloud"})
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"aws", "claude"}, cfg.activeApps)
+		assert.Equal(t, []appPath{{path: ".claude", readOnly: true}}, cfg.appDefs["claude"].paths)
+		assert.Equal(t, builtinApps()["gh"], cfg.appDefs["gh"])
+	})
+
+	t.Run("inherited configs are merged outermost first", func(t *testing.T) {
+		repo := filepath.Join(home, "repo")
+		pkg := filepath.Join(repo, "services", "api")
+		require.NoError(t, os.MkdirAll(pkg, 0755))
+		require.NoError(t, os.Mkdir(filepath.Join(repo, ".git"), 0755))
+		defer os.RemoveAll(repo)
+		require.NoError(t, os.WriteFile(filepath.Join(home, ".jail"), []byte("net host\n/opt/home\n"), 0644))
+		defer os.Remove(filepath.Join(home, ".jail"))
+		require.NoError(t, os.WriteFile(filepath.Join(repo, ".jail"), []byte("net none\n/opt/repo\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(repo, "services", ".jail.yaml"), []byte("version: 1\nnetwork:\n  mode: proxy\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(pkg, ".jail"), []byte("/opt/pkg\n"), 0644))
+
+		cfg, err := loadJailConfig(&jailArgs{jailDir: pkg})
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeProxy, cfg.netMode)
+		targets := mountTargets(cfg.mounts)
+		assert.Equal(t, []string{"/opt/home", "/opt/repo", "/opt/pkg"}, targets[len(targets)-3:])
+
+		cfg, err = loadJailConfig(&jailArgs{jailDir: pkg, noInherit: true})
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeHost, cfg.netMode)
+		assert.NotContains(t, mountTargets(cfg.mounts), "/opt/repo")
+	})
+
+	t.Run("inherited configs of other users are ignored", func(t *testing.T) {
+		if os.Getuid() != 0 {
+			t.Skip("changing file owners needs root")
+		}
+		parent := filepath.Join(home, "shared")
+		require.NoError(t, os.MkdirAll(filepath.Join(parent, "project"), 0755))
+		defer os.RemoveAll(parent)
+		require.NoError(t, os.WriteFile(filepath.Join(parent, ".jail"), []byte("/opt/planted rw\n"), 0644))
+		require.NoError(t, os.Chown(filepath.Join(parent, ".jail"), 12345, 12345))
+
+		cfg, err := loadJailConfig(&jailArgs{jailDir: filepath.Join(parent, "project")})
+
+		require.NoError(t, err)
+		assert.NotContains(t, mountTargets(cfg.mounts), "/opt/planted")
+		assert.Equal(t, []string{"ignoring " + filepath.Join(parent, ".jail") + ": not owned by the current user"}, cfg.warnings)
+	})
+
+	t.Run("machine policy wins", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(policyConfigPath, []byte("net proxy\nallow internal.example.com\nmask *.sqlite\n"), 0644))
+		defer os.Remove(policyConfigPath)
+		require.NoError(t, os.WriteFile(filepath.Join(workspace, ".jail"), []byte("net host\nallow example.org\nunmask *.sqlite\n"), 0644))
+		defer os.Remove(filepath.Join(workspace, ".jail"))
+
+		cfg, err := loadJailConfig(&jailArgs{jailDir: workspace})
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeProxy, cfg.netMode)
+		require.Len(t, cfg.allow, 1)
+		assert.Equal(t, []string{"*.sqlite"}, cfg.lockedMasks)
+		assert.NotEmpty(t, cfg.warnings)
+
+		_, err = loadJailConfig(&jailArgs{jailDir: workspace, netMode: netModeHost})
+
+		assert.ErrorContains(t, err, "--net=host is not allowed: "+policyConfigPath+" sets net proxy")
+	})
+}
@@ -0,0 +1,623 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// structuredConfigVersion is the .jail.yaml format version this jail understands
+const structuredConfigVersion = "1"
+
+// structuredConfigFiles are the names of structured config files, read after the
+// line-based .jail in the same directory
+var structuredConfigFiles = []string{".jail.yaml", ".jail.yml"}
+
+// structuredConfig is the layout of a .jail.yaml file. Values are kept as
+// nodes so that errors can point at the line they come from.
+type structuredConfig struct {
+	Version            yaml.Node `yaml:"version"`
+	structuredSettings `yaml:",inline"`
+	Profiles           map[string]profileSpec `yaml:"profiles"`
+	AppDefinitions     map[string]appSpec     `yaml:"app_definitions"`
+}
+
+// structuredSettings are the sections shared by the file and its profiles
+type structuredSettings struct {
+	Mounts  []yaml.Node          `yaml:"mounts"`
+	Tmpfs   []yaml.Node          `yaml:"tmpfs"`
+	Env     map[string]yaml.Node `yaml:"env"`
+	Network struct {
+		Mode  yaml.Node   `yaml:"mode"`
+		Allow []yaml.Node `yaml:"allow"`
+	} `yaml:"network"`
+	Resources map[string]yaml.Node `yaml:"resources"`
+	Security  struct {
+		Seccomp yaml.Node   `yaml:"seccomp"`
+		Dev     yaml.Node   `yaml:"dev"`
+		Devices []yaml.Node `yaml:"devices"`
+		Mask    []yaml.Node `yaml:"mask"`
+		Unmask  []yaml.Node `yaml:"unmask"`
+	} `yaml:"security"`
+	Workspace struct {
+		Writable        []yaml.Node `yaml:"writable"`
+		SnapshotExclude []yaml.Node `yaml:"snapshot_exclude"`
+	} `yaml:"workspace"`
+	Apps []yaml.Node `yaml:"apps"`
+}
+
+// profileSpec is an entry of the profiles section
+type profileSpec struct {
+	Extends            yaml.Node   `yaml:"extends"`
+	Commands           []yaml.Node `yaml:"commands"`
+	structuredSettings `yaml:",inline"`
+}
+
+// appSpec is an entry of the app_definitions section
+type appSpec struct {
+	Commands []yaml.Node `yaml:"commands"`
+	Paths    []yaml.Node `yaml:"paths"`
+}
+
+// appPathSpec is the mapping form of an app path: one of dir or file, with options
+type appPathSpec struct {
+	Dir     string `yaml:"dir"`
+	File    string `yaml:"file"`
+	Options string `yaml:"options"`
+	Env     string `yaml:"env"`
+}
+
+// mountSpec is the mapping form of a mounts entry
+type mountSpec struct {
+	Source  string    `yaml:"source"`
+	Target  string    `yaml:"target"`
+	Options yaml.Node `yaml:"options"`
+}
+
+// tmpfsSpec is the mapping form of a tmpfs entry
+type tmpfsSpec struct {
+	Path string `yaml:"path"`
+	Size string `yaml:"size"`
+}
+
+// configLineError is an error at a line of a config file
+type configLineError struct {
+	line int
+	err  error
+}
+
+func (e *configLineError) Error() string { return fmt.Sprintf("line %d: %v", e.line, e.err) }
+func (e *configLineError) Unwrap() error { return e.err }
+
+// lineErrorf returns a configLineError for the line of node
+func lineErrorf(node *yaml.Node, format string, args ...any) error {
+	return &configLineError{line: node.Line, err: fmt.Errorf(format, args...)}
+}
+
+// yamlNodeType is skipped by checkKeys: nodes are checked where they are converted
+var yamlNodeType = reflect.TypeOf(yaml.Node{})
+
+// checkKeys reports the first mapping key in node that has no field in type t.
+// section names the enclosing key for the error message.
+func checkKeys(node *yaml.Node, t reflect.Type, section string) error {
+	if t == yamlNodeType || node.Tag == "!!null" {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		if node.Kind != yaml.MappingNode {
+			return lineErrorf(node, "%s must be a mapping", section)
+		}
+		fields := make(map[string]reflect.Type)
+		var names []string
+		structFields(t, fields, &names)
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			fieldType, ok := fields[key.Value]
+			if !ok {
+				where := ""
+				if section != "" {
+					where = " in " + section
+				}
+				return lineErrorf(key, "unknown key %q%s (expected one of: %s)", key.Value, where, strings.Join(names, ", "))
+			}
+			name := key.Value
+			if section != "" {
+				name = section + "." + key.Value
+			}
+			if err := checkKeys(value, fieldType, name); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice:
+		if node.Kind != yaml.SequenceNode {
+			return lineErrorf(node, "%s must be a list", section)
+		}
+		for i := range node.Content {
+			if err := checkKeys(node.Content[i], t.Elem(), section); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		if node.Kind != yaml.MappingNode {
+			return lineErrorf(node, "%s must be a mapping", section)
+		}
+		for i := 1; i < len(node.Content); i += 2 {
+			if err := checkKeys(node.Content[i], t.Elem(), section); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// structFields collects the yaml keys of struct type t, including those of inline structs
+func structFields(t reflect.Type, fields map[string]reflect.Type, names *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		name, flags, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if flags == "inline" {
+			structFields(t.Field(i).Type, fields, names)
+			continue
+		}
+		fields[name] = t.Field(i).Type
+		*names = append(*names, name)
+	}
+}
+
+// decodeStrict checks node for unknown keys and decodes it into v
+func decodeStrict(node *yaml.Node, v any, section string) error {
+	if err := checkKeys(node, reflect.TypeOf(v).Elem(), section); err != nil {
+		return err
+	}
+	return node.Decode(v)
+}
+
+// scalarValue returns the value of a scalar node, or whether the key was absent
+func scalarValue(node *yaml.Node, key string) (string, bool, error) {
+	switch {
+	case node.Kind == 0 || node.Tag == "!!null":
+		return "", false, nil
+	case node.Kind == yaml.ScalarNode:
+		return node.Value, true, nil
+	default:
+		return "", false, lineErrorf(node, "%s must be a single value", key)
+	}
+}
+
+// readStructuredConfig reads a .jail.yaml file. It holds the same settings as
+// the line format, grouped into sections, plus environment variables and
+// resource limits. Unknown keys are errors.
+func readStructuredConfig(configPath string) (*jailConfig, error) {
+	data, err := os.ReadFile(configPath) //nolint:gosec // Config file path comes from workspace directory
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := parseStructuredConfig(configPath, data)
+	if err != nil {
+		var lineErr *configLineError
+		if errors.As(err, &lineErr) {
+			return nil, fmt.Errorf("%s:%d: %w", configPath, lineErr.line, lineErr.err)
+		}
+		return nil, fmt.Errorf("%s: %w", configPath, err)
+	}
+
+	resolveSeccompPath(cfg, configPath)
+	cfg.fillOrigin("", configPath)
+	return cfg, nil
+}
+
+// parseStructuredConfig converts the contents of the .jail.yaml file at configPath
+func parseStructuredConfig(configPath string, data []byte) (*jailConfig, error) {
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if len(root.Content) == 0 {
+		return &jailConfig{}, nil
+	}
+	doc := root.Content[0]
+	if doc.Kind != yaml.MappingNode {
+		return nil, lineErrorf(doc, "expected a mapping of config sections")
+	}
+
+	var sc structuredConfig
+	if err := decodeStrict(doc, &sc, ""); err != nil {
+		return nil, err
+	}
+
+	version, ok, err := scalarValue(&sc.Version, "version")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("missing version (add \"version: %s\")", structuredConfigVersion)
+	}
+	if version != structuredConfigVersion {
+		return nil, lineErrorf(&sc.Version, "unsupported config version %q (expected %s)", version, structuredConfigVersion)
+	}
+
+	cfg, err := sc.structuredSettings.toConfig(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Sorted so that errors are reported in a stable order
+	for _, name := range sortedKeys(sc.Profiles) {
+		if err := cfg.addProfileSpec(configPath, name, sc.Profiles[name]); err != nil {
+			return nil, err
+		}
+	}
+	for _, name := range sortedKeys(sc.AppDefinitions) {
+		if err := cfg.addAppSpec(name, sc.AppDefinitions[name]); err != nil {
+			return nil, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// toConfig converts the settings sections of a .jail.yaml file or profile.
+// Paths are expanded like .jail lines, and entries that use a variable that
+// is not set are skipped with a warning naming their line in configPath.
+//
+//nolint:gocognit,gocyclo // One branch per config key
+func (sc *structuredSettings) toConfig(configPath string) (*jailConfig, error) {
+	cfg := &jailConfig{}
+	expander := func(node *yaml.Node) func(string) (string, bool) {
+		return func(value string) (string, bool) {
+			expanded, missing := expandLine(value)
+			if len(missing) > 0 {
+				cfg.warnings = append(cfg.warnings, fmt.Sprintf("%s:%d: skipping %q: %s not set", configPath, node.Line, value, strings.Join(missing, ", ")))
+				return "", false
+			}
+			return expanded, true
+		}
+	}
+
+	for i := range sc.Mounts {
+		entry, ok, err := parseMountNode(&sc.Mounts[i], expander(&sc.Mounts[i]))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			cfg.addAt(&jailConfig{mounts: []mountEntry{entry}}, &sc.Mounts[i])
+		}
+	}
+
+	for i := range sc.Tmpfs {
+		entry, ok, err := parseTmpfsNode(&sc.Tmpfs[i], expander(&sc.Tmpfs[i]))
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			cfg.addAt(&jailConfig{tmpfs: []tmpfsEntry{entry}}, &sc.Tmpfs[i])
+		}
+	}
+
+	for _, name := range sortedKeys(sc.Env) {
+		node := sc.Env[name]
+		if name == "" || strings.ContainsAny(name, "= \t") {
+			return nil, lineErrorf(&node, "invalid environment variable name %q", name)
+		}
+		value, _, err := scalarValue(&node, "env."+name)
+		if err != nil {
+			return nil, err
+		}
+		cfg.addAt(&jailConfig{env: map[string]string{name: value}}, &node)
+	}
+
+	if mode, ok, err := scalarValue(&sc.Network.Mode, "network.mode"); err != nil {
+		return nil, err
+	} else if ok {
+		netMode, err := parseNetMode(mode)
+		if err != nil {
+			return nil, lineErrorf(&sc.Network.Mode, "%w", err)
+		}
+		cfg.addAt(&jailConfig{netMode: netMode}, &sc.Network.Mode)
+	}
+	for i := range sc.Network.Allow {
+		node := &sc.Network.Allow[i]
+		value, _, err := scalarValue(node, "network.allow entries")
+		if err != nil {
+			return nil, err
+		}
+		rule, err := parseAllowRule(value)
+		if err != nil {
+			return nil, lineErrorf(node, "%w", err)
+		}
+		cfg.addAt(&jailConfig{allow: []allowRule{rule}}, node)
+	}
+
+	for _, name := range sortedKeys(sc.Resources) {
+		node := sc.Resources[name]
+		value, _, err := scalarValue(&node, "resources."+name)
+		if err != nil {
+			return nil, err
+		}
+		limit, err := parseLimit(name, value)
+		if err != nil {
+			return nil, lineErrorf(&node, "%w", err)
+		}
+		cfg.addAt(&jailConfig{limits: map[string]uint64{name: limit}}, &node)
+	}
+
+	if seccomp, ok, err := scalarValue(&sc.Security.Seccomp, "security.seccomp"); err != nil {
+		return nil, err
+	} else if ok {
+		if seccomp, ok := expander(&sc.Security.Seccomp)(seccomp); ok {
+			cfg.addAt(&jailConfig{seccomp: seccomp}, &sc.Security.Seccomp)
+		}
+	}
+	if dev, ok, err := scalarValue(&sc.Security.Dev, "security.dev"); err != nil {
+		return nil, err
+	} else if ok {
+		devMode, err := parseDevMode(dev)
+		if err != nil {
+			return nil, lineErrorf(&sc.Security.Dev, "%w", err)
+		}
+		cfg.addAt(&jailConfig{devMode: devMode}, &sc.Security.Dev)
+	}
+
+	// List settings that share the validation of their line-format directive.
+	// All but application names are paths.
+	lists := []struct {
+		nodes  []yaml.Node
+		key    string
+		parse  func(string) (string, error)
+		dest   func(*jailConfig) *[]string
+		expand bool
+	}{
+		{sc.Security.Devices, "security.devices", parseDevice, func(c *jailConfig) *[]string { return &c.devices }, true},
+		{sc.Security.Mask, "security.mask", parseMaskPattern, func(c *jailConfig) *[]string { return &c.masks }, true},
+		{sc.Security.Unmask, "security.unmask", parseMaskPattern, func(c *jailConfig) *[]string { return &c.unmask }, true},
+		{sc.Workspace.Writable, "workspace.writable", parseWritablePath, func(c *jailConfig) *[]string { return &c.writable }, true},
+		{sc.Apps, "apps", parseSectionName, func(c *jailConfig) *[]string { return &c.apps }, false},
+		{sc.Workspace.SnapshotExclude, "workspace.snapshot_exclude", func(p string) (string, error) {
+			patterns, err := parseSnapshotExclude([]string{p})
+			if err != nil {
+				return "", err
+			}
+			return patterns[0], nil
+		}, func(c *jailConfig) *[]string { return &c.snapshotExclude }, true},
+	}
+	for _, list := range lists {
+		for i := range list.nodes {
+			node := &list.nodes[i]
+			value, _, err := scalarValue(node, list.key+" entries")
+			if err != nil {
+				return nil, err
+			}
+			if list.expand {
+				var ok bool
+				if value, ok = expander(node)(value); !ok {
+					continue
+				}
+			}
+			parsed, err := list.parse(value)
+			if err != nil {
+				return nil, lineErrorf(node, "%w", err)
+			}
+			item := &jailConfig{}
+			*list.dest(item) = []string{parsed}
+			cfg.addAt(item, node)
+		}
+	}
+
+	return cfg, nil
+}
+
+// addAt merges item, the setting made by node, and records the node's line as its origin
+func (c *jailConfig) addAt(item *jailConfig, node *yaml.Node) {
+	item.record(configOrigin{line: node.Line})
+	c.merge(item)
+}
+
+// addProfileSpec converts an entry of the profiles section of configPath and adds it to c
+func (c *jailConfig) addProfileSpec(configPath, name string, spec profileSpec) error {
+	if _, err := parseSectionName(name); err != nil {
+		return err
+	}
+	settings, err := spec.structuredSettings.toConfig(configPath)
+	if err != nil {
+		return err
+	}
+	// Reported once for the file, not again when the profile is applied
+	c.warnings = append(c.warnings, settings.warnings...)
+	settings.warnings = nil
+	profile := c.profile(name)
+	profile.settings = settings
+
+	extends, ok, err := scalarValue(&spec.Extends, "profiles."+name+".extends")
+	if err != nil {
+		return err
+	}
+	if ok {
+		if profile.extends, err = parseSectionName(extends); err != nil {
+			return lineErrorf(&spec.Extends, "%w", err)
+		}
+	}
+	for i := range spec.Commands {
+		node := &spec.Commands[i]
+		value, _, err := scalarValue(node, "profiles."+name+".commands entries")
+		if err != nil {
+			return err
+		}
+		command, err := parseCommandName(value)
+		if err != nil {
+			return lineErrorf(node, "%w", err)
+		}
+		profile.commands = append(profile.commands, command)
+	}
+	return nil
+}
+
+// addAppSpec converts an entry of the app_definitions section and adds it to c
+func (c *jailConfig) addAppSpec(name string, spec appSpec) error {
+	if _, err := parseSectionName(name); err != nil {
+		return err
+	}
+	app := c.appDefinition(name)
+
+	for i := range spec.Commands {
+		node := &spec.Commands[i]
+		value, _, err := scalarValue(node, "app_definitions."+name+".commands entries")
+		if err != nil {
+			return err
+		}
+		command, err := parseCommandName(value)
+		if err != nil {
+			return lineErrorf(node, "%w", err)
+		}
+		app.commands = append(app.commands, command)
+	}
+	for i := range spec.Paths {
+		p, err := parseAppPathNode(&spec.Paths[i])
+		if err != nil {
+			return err
+		}
+		app.paths = append(app.paths, p)
+	}
+	return nil
+}
+
+// parseAppPathNode converts an app path: either a string in the line format
+// ("dir .config/tool ro") or a mapping with dir or file, options and env
+func parseAppPathNode(node *yaml.Node) (appPath, error) {
+	var fields []string
+	if node.Kind == yaml.ScalarNode {
+		fields = strings.Fields(node.Value)
+		if len(fields) == 0 || (fields[0] != "dir" && fields[0] != "file") {
+			return appPath{}, lineErrorf(node, "app path %q must start with dir or file", node.Value)
+		}
+	} else {
+		var spec appPathSpec
+		if err := decodeStrict(node, &spec, "paths"); err != nil {
+			return appPath{}, err
+		}
+		switch {
+		case (spec.Dir == "") == (spec.File == ""):
+			return appPath{}, lineErrorf(node, "app path needs either dir or file")
+		case spec.Dir != "":
+			fields = []string{"dir", spec.Dir}
+		default:
+			fields = []string{"file", spec.File}
+		}
+		fields = append(fields, strings.FieldsFunc(spec.Options, func(r rune) bool { return r == ',' })...)
+		if spec.Env != "" {
+			fields = append(fields, "env="+spec.Env)
+		}
+	}
+
+	p, err := parseAppPath(fields)
+	if err != nil {
+		return appPath{}, lineErrorf(node, "%w", err)
+	}
+	return p, nil
+}
+
+// parseMountNode converts a mounts entry: either a string in the line format
+// or a mapping with source, target and options. Paths are passed through
+// expand; ok is false if it rejects one.
+func parseMountNode(node *yaml.Node, expand func(string) (string, bool)) (entry mountEntry, ok bool, err error) {
+	if node.Kind == yaml.ScalarNode {
+		line, ok := expand(node.Value)
+		if !ok {
+			return mountEntry{}, false, nil
+		}
+		entry, err := parseMountEntry(line)
+		if err != nil {
+			return mountEntry{}, false, lineErrorf(node, "%w", err)
+		}
+		return entry, true, nil
+	}
+
+	var spec mountSpec
+	if err := decodeStrict(node, &spec, "mounts"); err != nil {
+		return mountEntry{}, false, err
+	}
+	if spec.Source == "" {
+		return mountEntry{}, false, lineErrorf(node, "mount is missing a source")
+	}
+	if spec.Target == "" {
+		spec.Target = spec.Source
+	}
+	for _, path := range []*string{&spec.Source, &spec.Target} {
+		if *path, ok = expand(*path); !ok {
+			return mountEntry{}, false, nil
+		}
+	}
+	entry = mountEntry{source: spec.Source, target: spec.Target, readOnly: true}
+
+	// Options may be a comma-separated string or a list
+	var options string
+	switch spec.Options.Kind {
+	case 0:
+		return entry, true, nil
+	case yaml.ScalarNode:
+		options = spec.Options.Value
+	case yaml.SequenceNode:
+		var list []string
+		if err := spec.Options.Decode(&list); err != nil {
+			return mountEntry{}, false, err
+		}
+		options = strings.Join(list, ",")
+	default:
+		return mountEntry{}, false, lineErrorf(&spec.Options, "options must be a string or a list")
+	}
+	if err := entry.applyOptions(options); err != nil {
+		return mountEntry{}, false, lineErrorf(&spec.Options, "%w", err)
+	}
+
+	return entry, true, nil
+}
+
+// parseTmpfsNode converts a tmpfs entry: either a string in the line format
+// ("/var/tmp size=512m") or a mapping with path and size. The path is passed
+// through expand; ok is false if it rejects it.
+func parseTmpfsNode(node *yaml.Node, expand func(string) (string, bool)) (entry tmpfsEntry, ok bool, err error) {
+	var line string
+	if node.Kind == yaml.ScalarNode {
+		line = node.Value
+	} else {
+		var spec tmpfsSpec
+		if err := decodeStrict(node, &spec, "tmpfs"); err != nil {
+			return tmpfsEntry{}, false, err
+		}
+		line = spec.Path
+		if spec.Size != "" {
+			line += " size=" + spec.Size
+		}
+	}
+	if line, ok = expand(line); !ok {
+		return tmpfsEntry{}, false, nil
+	}
+
+	entry, err = parseTmpfsEntry(strings.Fields(line))
+	if err != nil {
+		return tmpfsEntry{}, false, lineErrorf(node, "%w", err)
+	}
+	return entry, true, nil
+}
+
+// sortedEnv returns the names of env in a stable order
+func sortedEnv(env map[string]string) []string {
+	return sortedKeys(env)
+}
+
+// sortedKeys returns the keys of m in order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
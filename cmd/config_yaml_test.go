@@ -0,0 +1,268 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wfaler/jail/jail"
+)
+
+// TestParseStructuredConfig tests converting .jail.yaml sections
+func TestParseStructuredConfig(t *testing.T) {
+	t.Run("all sections", func(t *testing.T) {
+		data := `
+version: 1
+mounts:
+  - /opt/tools
+  - /home/dev/.cache/go-build rw
+  - source: /srv/data
+    target: /data
+    options: [rw, noexec]
+  - source: /srv/lib
+    options: ro,nosuid
+tmpfs:
+  - /var/tmp size=256m
+  - path: /run
+    size: 8m
+env:
+  GOFLAGS: -mod=mod
+  EMPTY: ~
+network:
+  mode: proxy
+  allow: [registry.npmjs.org, "*.github.com:443"]
+resources:
+  nofile: 1024
+  memory: 2g
+security:
+  seccomp: unconfined
+  dev: host
+  devices: [/dev/kvm]
+  mask: ["*.sqlite"]
+  unmask: [test/*.pem]
+workspace:
+  writable: [build/]
+  snapshot_exclude: [node_modules]
+`
+		cfg, err := parseStructuredConfig("", []byte(data))
+
+		require.NoError(t, err)
+		assert.Equal(t, []mountEntry{
+			{source: "/opt/tools", target: "/opt/tools", readOnly: true},
+			{source: "/home/dev/.cache/go-build", target: "/home/dev/.cache/go-build"},
+			{source: "/srv/data", target: "/data", noExec: true},
+			{source: "/srv/lib", target: "/srv/lib", readOnly: true, noSuid: true},
+		}, cfg.mounts)
+		assert.Equal(t, []tmpfsEntry{{target: "/var/tmp", size: "256m"}, {target: "/run", size: "8m"}}, cfg.tmpfs)
+		assert.Equal(t, map[string]string{"GOFLAGS": "-mod=mod", "EMPTY": ""}, cfg.env)
+		assert.Equal(t, netModeProxy, cfg.netMode)
+		assert.Len(t, cfg.allow, 2)
+		assert.Equal(t, map[string]uint64{"nofile": 1024, "memory": 2 << 30}, cfg.limits)
+		assert.Equal(t, jail.SeccompUnconfined, cfg.seccomp)
+		assert.Equal(t, devModeHost, cfg.devMode)
+		assert.Equal(t, []string{"/dev/kvm"}, cfg.devices)
+		assert.Equal(t, []string{"*.sqlite"}, cfg.masks)
+		assert.Equal(t, []string{"test/*.pem"}, cfg.unmask)
+		assert.Equal(t, []string{"build"}, cfg.writable)
+		assert.Equal(t, []string{"node_modules"}, cfg.snapshotExclude)
+	})
+
+	t.Run("empty file and empty sections", func(t *testing.T) {
+		cfg, err := parseStructuredConfig("", []byte("version: 1\nenv:\nsecurity:\n"))
+		require.NoError(t, err)
+		assert.Empty(t, cfg.env)
+
+		cfg, err = parseStructuredConfig("", nil)
+		require.NoError(t, err)
+		assert.Empty(t, cfg.mounts)
+	})
+
+	t.Run("errors point at the line", func(t *testing.T) {
+		tests := []struct {
+			data string
+			line int
+			want string
+		}{
+			{"version: 1\nnetwrk: {}\n", 2, `unknown key "netwrk"`},
+			{"version: 1\nnetwork:\n  mode: none\n  alow: [x]\n", 4, `unknown key "alow" in network`},
+			{"version: 1\nmounts:\n  - source: /x\n    ro: true\n", 4, `unknown key "ro" in mounts`},
+			{"version: 1\nmounts: /opt\n", 2, "mounts must be a list"},
+			{"version: 1\nnetwork:\n  allow:\n    - ok.com\n    - bad host\n", 5, "invalid allow rule"},
+			{"version: 1\nresources:\n  nofiles: 3\n", 3, `unknown resource limit "nofiles"`},
+			{"version: 1\nsecurity:\n  dev: all\n", 3, "unknown device mode"},
+			{"version: 1\nenv:\n  A: [1]\n", 3, "env.A must be a single value"},
+			{"version: 2\n", 1, "unsupported config version"},
+		}
+
+		for _, tt := range tests {
+			_, err := parseStructuredConfig("", []byte(tt.data))
+
+			var lineErr *configLineError
+			require.ErrorAs(t, err, &lineErr, tt.data)
+			assert.Equal(t, tt.line, lineErr.line, tt.data)
+			assert.Contains(t, lineErr.err.Error(), tt.want)
+		}
+	})
+
+	t.Run("profiles", func(t *testing.T) {
+		data := `
+version: 1
+network:
+  mode: none
+profiles:
+  node:
+    commands: [npm, npx]
+    network:
+      mode: proxy
+      allow: [registry.npmjs.org]
+  node-ci:
+    extends: node
+    env:
+      CI: "1"
+`
+		cfg, err := parseStructuredConfig("", []byte(data))
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeNone, cfg.netMode)
+		require.Len(t, cfg.profiles, 2)
+		node := cfg.profiles["node"]
+		assert.Equal(t, []string{"npm", "npx"}, node.commands)
+		assert.Equal(t, netModeProxy, node.settings.netMode)
+		assert.Len(t, node.settings.allow, 1)
+		assert.Equal(t, "node", cfg.profiles["node-ci"].extends)
+		assert.Equal(t, map[string]string{"CI": "1"}, cfg.profiles["node-ci"].settings.env)
+	})
+
+	t.Run("profile errors point at the line", func(t *testing.T) {
+		tests := []struct {
+			data string
+			line int
+			want string
+		}{
+			{"version: 1\nprofiles:\n  x:\n    version: 1\n", 4, `unknown key "version" in profiles`},
+			{"version: 1\nprofiles:\n  x:\n    network:\n      mode: all\n", 5, "unknown network mode"},
+			{"version: 1\nprofiles:\n  x:\n    commands: [bin/x]\n", 4, "must be a name without a directory"},
+		}
+
+		for _, tt := range tests {
+			_, err := parseStructuredConfig("", []byte(tt.data))
+
+			var lineErr *configLineError
+			require.ErrorAs(t, err, &lineErr, tt.data)
+			assert.Equal(t, tt.line, lineErr.line, tt.data)
+			assert.Contains(t, lineErr.err.Error(), tt.want)
+		}
+	})
+
+	t.Run("apps", func(t *testing.T) {
+		data := `
+version: 1
+apps: [gh]
+profiles:
+  cloud:
+    apps: [aws]
+app_definitions:
+  agent:
+    commands: [agent]
+    paths:
+      - dir .agent
+      - file: .agent.json
+        options: ro
+        env: AGENT_CONFIG
+`
+		cfg, err := parseStructuredConfig("", []byte(data))
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"gh"}, cfg.apps)
+		assert.Equal(t, []string{"aws"}, cfg.profiles["cloud"].settings.apps)
+		assert.Equal(t, &appDefinition{
+			commands: []string{"agent"},
+			paths:    []appPath{{path: ".agent"}, {path: ".agent.json", env: "AGENT_CONFIG", file: true, readOnly: true}},
+		}, cfg.appDefs["agent"])
+	})
+
+	t.Run("app errors point at the line", func(t *testing.T) {
+		tests := []struct {
+			data string
+			line int
+			want string
+		}{
+			{"version: 1\napp_definitions:\n  a:\n    paths:\n      - .a\n", 5, "must start with dir or file"},
+			{"version: 1\napp_definitions:\n  a:\n    paths:\n      - {dir: .a, file: .b}\n", 5, "either dir or file"},
+			{"version: 1\napp_definitions:\n  a:\n    paths:\n      - {dir: /a}\n", 5, "inside the home directory"},
+			{"version: 1\napp_definitions:\n  a:\n    path: []\n", 4, `unknown key "path"`},
+		}
+
+		for _, tt := range tests {
+			_, err := parseStructuredConfig("", []byte(tt.data))
+
+			var lineErr *configLineError
+			require.ErrorAs(t, err, &lineErr, tt.data)
+			assert.Equal(t, tt.line, lineErr.line, tt.data)
+			assert.Contains(t, lineErr.err.Error(), tt.want)
+		}
+	})
+
+	t.Run("version is required", func(t *testing.T) {
+		_, err := parseStructuredConfig("", []byte("network:\n  mode: none\n"))
+
+		assert.ErrorContains(t, err, "missing version")
+	})
+}
+
+// TestReadStructuredConfig tests file names in errors and relative profile paths
+func TestReadStructuredConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, ".jail.yaml")
+
+	t.Run("relative seccomp profile", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("version: 1\nsecurity:\n  seccomp: profile.json\n"), 0644))
+
+		cfg, err := readStructuredConfig(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(dir, "profile.json"), cfg.seccomp)
+	})
+
+	t.Run("paths are expanded like .jail lines", func(t *testing.T) {
+		t.Setenv("HOME", "/home/dev")
+		t.Setenv("JAIL_TEST_DATA", "/srv/data")
+		data := `version: 1
+mounts:
+  - ~/.cache/go-build rw
+  - source: $JAIL_TEST_DATA
+    target: ${JAIL_TEST_DATA}/in-jail
+  - $JAIL_TEST_UNSET/tools
+workspace:
+  writable: [$JAIL_TEST_UNSET]
+profiles:
+  cache:
+    tmpfs: [~/.cache/tmp]
+`
+		require.NoError(t, os.WriteFile(path, []byte(data), 0644))
+
+		cfg, err := readStructuredConfig(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, []mountEntry{
+			{source: "/home/dev/.cache/go-build", target: "/home/dev/.cache/go-build"},
+			{source: "/srv/data", target: "/srv/data/in-jail", readOnly: true},
+		}, cfg.mounts)
+		assert.Empty(t, cfg.writable)
+		assert.Equal(t, []tmpfsEntry{{target: "/home/dev/.cache/tmp", size: "1g"}}, cfg.profiles["cache"].settings.tmpfs)
+		assert.Equal(t, []string{
+			path + `:6: skipping "$JAIL_TEST_UNSET/tools": $JAIL_TEST_UNSET not set`,
+			path + `:8: skipping "$JAIL_TEST_UNSET": $JAIL_TEST_UNSET not set`,
+		}, cfg.warnings)
+	})
+
+	t.Run("errors name file and line", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("version: 1\n\nbogus: true\n"), 0644))
+
+		_, err := readStructuredConfig(path)
+
+		assert.ErrorContains(t, err, path+`:3: unknown key "bogus"`)
+	})
+}
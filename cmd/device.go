@@ -0,0 +1,44 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/wfaler/jail/jail"
+)
+
+// Device modes selectable with --dev or the "dev" .jail directive
+const (
+	devModePrivate = "private" // minimal /dev on tmpfs with only the standard devices
+	devModeHost    = "host"    // bind mount the host /dev
+)
+
+// devModes lists the valid device modes
+var devModes = []string{devModePrivate, devModeHost}
+
+// defaultDevices are the host device nodes bound into a private /dev
+var defaultDevices = []string{"/dev/null", "/dev/zero", "/dev/full", "/dev/random", "/dev/urandom", "/dev/tty"}
+
+// parseDevMode validates a device mode name
+func parseDevMode(mode string) (string, error) {
+	for _, m := range devModes {
+		if mode == m {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown device mode %q (expected one of: %s)", mode, strings.Join(devModes, ", "))
+}
+
+// parseDevice validates a device path from a .jail "device" line. Devices must
+// lie below /dev and may not replace what a private /dev sets up itself.
+func parseDevice(path string) (string, error) {
+	if !filepath.IsAbs(path) {
+		return "", fmt.Errorf("device %s is not an absolute path", path)
+	}
+	path = filepath.Clean(path)
+	if err := jail.CheckDevice(path); err != nil {
+		return "", err
+	}
+	return path, nil
+}
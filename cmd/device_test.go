@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseDevMode tests device mode validation
+func TestParseDevMode(t *testing.T) {
+	t.Run("valid modes", func(t *testing.T) {
+		for _, mode := range devModes {
+			result, err := parseDevMode(mode)
+
+			require.NoError(t, err)
+			assert.Equal(t, mode, result)
+		}
+	})
+
+	t.Run("unknown mode", func(t *testing.T) {
+		_, err := parseDevMode("minimal")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown device mode")
+	})
+}
+
+// TestParseDevice tests validation of whitelisted device paths
+func TestParseDevice(t *testing.T) {
+	t.Run("valid devices", func(t *testing.T) {
+		for input, want := range map[string]string{
+			"/dev/kvm":     "/dev/kvm",
+			"/dev/net/tun": "/dev/net/tun",
+			"/dev/dri/":    "/dev/dri",
+			"/dev/./fuse":  "/dev/fuse",
+		} {
+			dev, err := parseDevice(input)
+
+			require.NoError(t, err, input)
+			assert.Equal(t, want, dev)
+		}
+	})
+
+	t.Run("invalid devices", func(t *testing.T) {
+		for _, input := range []string{"kvm", "/dev", "/etc/passwd", "/dev/../etc", "/dev/pts/0", "/dev/shm", "/dev/fd"} {
+			_, err := parseDevice(input)
+
+			assert.Error(t, err, input)
+		}
+	})
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/wfaler/jail/jail"
+)
+
+// runDoctor reports which kernel isolation features jail can use on this host
+func runDoctor(w io.Writer) error {
+	checks := []struct {
+		name  string
+		check func() string
+	}{
+		{"User namespaces", doctorUserNamespaces},
+		{"Landlock", doctorLandlock},
+		{"Seccomp", doctorSeccomp},
+	}
+
+	for _, c := range checks {
+		if _, err := fmt.Fprintf(w, "%-16s %s\n", c.name+":", c.check()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// doctorUserNamespaces reports whether unprivileged user namespaces are available
+func doctorUserNamespaces() string {
+	data, err := os.ReadFile("/proc/sys/user/max_user_namespaces")
+	if err != nil {
+		return fmt.Sprintf("unknown (%v)", err)
+	}
+	if strings.TrimSpace(string(data)) == "0" {
+		return "disabled (user.max_user_namespaces is 0), jail cannot run"
+	}
+	return "available"
+}
+
+// doctorLandlock reports the Landlock ABI version jail enforces its filesystem rules with
+func doctorLandlock() string {
+	abi, err := jail.LandlockABI()
+	switch {
+	case err != nil:
+		return fmt.Sprintf("not available (%v), relying on mount isolation only", err)
+	case abi == 1:
+		return "ABI 1, filesystem rules enforced; renaming or linking files between directories fails with EXDEV"
+	default:
+		return fmt.Sprintf("ABI %d, filesystem rules enforced", abi)
+	}
+}
+
+// seccompRequired is what doctorSeccomp adds when filters are not available:
+// runs fail to install the filter, so only unconfined ones start
+const seccompRequired = "jail refuses to start unless run with --seccomp=unconfined"
+
+// doctorSeccomp reports whether the kernel supports seccomp filters for this architecture
+func doctorSeccomp() string {
+	arch := jail.SeccompArch()
+	if arch == "" {
+		return "not supported on this architecture, " + seccompRequired
+	}
+
+	file, err := os.Open("/proc/self/status")
+	if err != nil {
+		return fmt.Sprintf("unknown (%v)", err)
+	}
+	defer func() { _ = file.Close() }()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), "Seccomp:") {
+			return "filters supported (" + arch + ")"
+		}
+	}
+	return "not available (kernel built without CONFIG_SECCOMP), " + seccompRequired
+}
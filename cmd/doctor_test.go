@@ -0,0 +1,30 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wfaler/jail/jail"
+)
+
+// TestRunDoctor tests the feature report
+func TestRunDoctor(t *testing.T) {
+	var out bytes.Buffer
+	err := runDoctor(&out)
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "User namespaces:")
+	assert.Contains(t, out.String(), "Landlock:")
+	assert.Contains(t, out.String(), "Seccomp:")
+
+	if abi, err := jail.LandlockABI(); err == nil {
+		assert.Contains(t, out.String(), fmt.Sprintf("ABI %d,", abi))
+	}
+	if !strings.Contains(out.String(), "filters supported") {
+		assert.Contains(t, out.String(), seccompRequired, "runs without seccomp fail rather than go unfiltered")
+	}
+}
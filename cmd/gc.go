@@ -0,0 +1,150 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// staleRootPattern matches the root directories created in the temp dir by jail versions
+// that did not build the jail root on tmpfs
+const staleRootPattern = "jail-root-*"
+
+// errStillMounted stops removal of a directory that is a mount point
+var errStillMounted = errors.New("still a mount point")
+
+// runGC removes stale jail-root-* directories from tempDir. Directories containing
+// a mount point, or owned by another user, are left alone.
+func runGC(w io.Writer, tempDir string) error {
+	dirs, err := filepath.Glob(filepath.Join(tempDir, staleRootPattern))
+	if err != nil {
+		return err
+	}
+
+	mounts, err := currentMountPoints()
+	if err != nil {
+		return fmt.Errorf("reading mount table: %w", err)
+	}
+
+	removed := 0
+	for _, dir := range dirs {
+		if err := removeStaleRoot(dir, mounts); err != nil {
+			if _, werr := fmt.Fprintf(w, "skipping %s: %v\n", dir, err); werr != nil {
+				return werr
+			}
+			continue
+		}
+		removed++
+	}
+
+	_, err = fmt.Fprintf(w, "removed %d stale jail root directories\n", removed)
+	return err
+}
+
+// removeStaleRoot deletes a stale jail root after checking that nothing
+// below it is mounted
+func removeStaleRoot(dir string, mounts []string) error {
+	info, err := os.Lstat(dir)
+	if err != nil {
+		return err
+	}
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !info.IsDir() || !ok {
+		return errors.New("not a directory")
+	}
+	if int(st.Uid) != os.Getuid() {
+		return errors.New("owned by another user")
+	}
+
+	for _, m := range mounts {
+		if isSubPath(m, dir) {
+			return fmt.Errorf("%s is %w", m, errStillMounted)
+		}
+	}
+
+	return removeTree(dir, st.Dev)
+}
+
+// removeTree removes dir and its contents without following symlinks. It refuses
+// to descend into a directory on another device, which means it is mounted in
+// a way the mount table did not show; the kernel itself refuses to remove
+// mount points of other namespaces with EBUSY.
+func removeTree(dir string, dev uint64) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		info, err := os.Lstat(path)
+		if err != nil {
+			return err
+		}
+		if st, ok := info.Sys().(*syscall.Stat_t); ok && st.Dev != dev {
+			return fmt.Errorf("%s is %w", path, errStillMounted)
+		}
+
+		if info.IsDir() {
+			err = removeTree(path, dev)
+		} else {
+			err = os.Remove(path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return os.Remove(dir)
+}
+
+// currentMountPoints returns the mount points of this process's mount namespace
+func currentMountPoints() ([]string, error) {
+	file, err := os.Open("/proc/self/mountinfo")
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = file.Close() }()
+
+	return parseMountInfo(file)
+}
+
+// parseMountInfo extracts the mount point field from /proc/<pid>/mountinfo lines
+func parseMountInfo(r io.Reader) ([]string, error) {
+	var mounts []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 { //nolint:mnd // the mount point is the fifth field
+			continue
+		}
+		mounts = append(mounts, unescapeMountInfo(fields[4]))
+	}
+	return mounts, scanner.Err()
+}
+
+// unescapeMountInfo decodes the octal escapes (\040 for space etc.) used in mountinfo paths
+func unescapeMountInfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
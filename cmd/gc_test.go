@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseMountInfo tests extracting mount points from /proc/self/mountinfo
+func TestParseMountInfo(t *testing.T) {
+	input := `22 1 0:21 / / rw,relatime shared:1 - ext4 /dev/vda rw
+23 22 0:22 / /proc rw,nosuid shared:2 - proc proc rw
+24 22 0:23 / /tmp/jail-root-x/my\040dir rw - tmpfs tmpfs rw
+`
+	mounts, err := parseMountInfo(strings.NewReader(input))
+
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/", "/proc", "/tmp/jail-root-x/my dir"}, mounts)
+}
+
+// TestRemoveStaleRoot tests removal of jail-root-* directories left by older versions
+func TestRemoveStaleRoot(t *testing.T) {
+	t.Run("removes skeleton and stub files", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "jail-root-123")
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "usr"), 0755))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "home", "dev"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "home", "dev", ".claude.json"), nil, 0600))
+
+		err := removeStaleRoot(dir, nil)
+
+		require.NoError(t, err)
+		assert.NoDirExists(t, dir)
+	})
+
+	t.Run("does not follow symlinks", func(t *testing.T) {
+		tmp := t.TempDir()
+		outside := filepath.Join(tmp, "outside")
+		require.NoError(t, os.MkdirAll(outside, 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(outside, "keep"), []byte("data"), 0644))
+		dir := filepath.Join(tmp, "jail-root-456")
+		require.NoError(t, os.MkdirAll(dir, 0755))
+		require.NoError(t, os.Symlink(outside, filepath.Join(dir, "link")))
+
+		err := removeStaleRoot(dir, nil)
+
+		require.NoError(t, err)
+		assert.NoDirExists(t, dir)
+		assert.FileExists(t, filepath.Join(outside, "keep"))
+	})
+
+	t.Run("refuses directories with mount points", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "jail-root-789")
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "usr"), 0755))
+
+		err := removeStaleRoot(dir, []string{"/", filepath.Join(dir, "usr")})
+
+		require.ErrorIs(t, err, errStillMounted)
+		assert.DirExists(t, filepath.Join(dir, "usr"))
+	})
+}
+
+// TestRunGC tests the jail gc report
+func TestRunGC(t *testing.T) {
+	tmp := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "jail-root-1", "bin"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "jail-root-2"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(tmp, "unrelated"), 0755))
+
+	var out bytes.Buffer
+	err := runGC(&out, tmp)
+
+	require.NoError(t, err)
+	assert.Equal(t, "removed 2 stale jail root directories\n", out.String())
+	assert.NoDirExists(t, filepath.Join(tmp, "jail-root-1"))
+	assert.DirExists(t, filepath.Join(tmp, "unrelated"))
+}
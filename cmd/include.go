@@ -0,0 +1,132 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// includeDirPattern selects the files read by an "include-dir" line
+const includeDirPattern = "*.conf"
+
+// xdgDefaults are the XDG base directories relative to $HOME, used when the variable is not set
+var xdgDefaults = map[string]string{
+	"XDG_CONFIG_HOME": ".config",
+	"XDG_DATA_HOME":   ".local/share",
+	"XDG_STATE_HOME":  ".local/state",
+	"XDG_CACHE_HOME":  ".cache",
+}
+
+// lookupConfigVar returns the value of an environment variable referenced in a
+// .jail line, falling back to the XDG defaults. An empty value counts as unset.
+func lookupConfigVar(name string) (string, bool) {
+	if value := os.Getenv(name); value != "" {
+		return value, true
+	}
+	if def, ok := xdgDefaults[name]; ok {
+		if home := os.Getenv("HOME"); home != "" {
+			return filepath.Join(home, def), true
+		}
+	}
+	return "", false
+}
+
+// expandLine expands "~" at the start of a path and after the ':' of a
+// host:jail mount, and $VAR or ${VAR} references, in each field of a .jail
+// line. "$$" stands for a literal '$'. It returns the names of the variables
+// that are not set; the line must not be used if there are any.
+func expandLine(line string) (string, []string) {
+	var missing []string
+	lookup := func(name string) string {
+		if name == "$" {
+			return "$"
+		}
+		value, ok := lookupConfigVar(name)
+		if !ok {
+			missing = append(missing, "$"+name)
+		}
+		return value
+	}
+
+	fields := strings.Fields(line)
+	for i, field := range fields {
+		parts := strings.Split(field, ":")
+		for j, part := range parts {
+			if part == "~" || strings.HasPrefix(part, "~/") {
+				parts[j] = "${HOME}" + strings.TrimPrefix(part, "~")
+			}
+		}
+		fields[i] = os.Expand(strings.Join(parts, ":"), lookup)
+	}
+	return strings.Join(fields, " "), missing
+}
+
+// includePath resolves the path of an "include" or "include-dir" line relative
+// to the directory of the file that contains it
+func includePath(configPath string, fields []string) (string, error) {
+	if len(fields) != 2 {
+		return "", fmt.Errorf("usage: %s <path>", fields[0])
+	}
+	path := fields[1]
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(configPath), path)
+	}
+	return filepath.Clean(path), nil
+}
+
+// includeDirFiles returns the files an "include-dir" line reads, in lexical
+// order: those matching includeDirPattern in a directory, or those matching the
+// last element of a path such as conf.d/*.conf. exists is false if the
+// directory does not exist.
+func includeDirFiles(path string) (files []string, exists bool, err error) {
+	dir, pattern := path, includeDirPattern
+	if base := filepath.Base(path); strings.ContainsAny(base, `*?[\`) {
+		dir, pattern = filepath.Dir(path), base
+	}
+	info, err := os.Stat(dir)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if !info.IsDir() {
+		return nil, false, fmt.Errorf("include-dir %s is not a directory", dir)
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, pattern))
+	if err != nil {
+		return nil, false, fmt.Errorf("include-dir %s: %w", path, err)
+	}
+	for _, m := range matches {
+		if info, err := os.Stat(m); err == nil && !info.IsDir() {
+			files = append(files, m)
+		}
+	}
+	sort.Strings(files)
+	return files, true, nil
+}
+
+// readIncludedConfig reads an included file: the structured format for .yaml
+// and .yml files and the line format otherwise. including holds the files
+// currently being read, outermost first, to detect include cycles.
+func readIncludedConfig(path string, including []string) (*jailConfig, error) {
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil, fmt.Errorf("including %s: %w", path, err)
+	}
+	for _, f := range including {
+		if f == resolved {
+			return nil, fmt.Errorf("include cycle: %s -> %s", strings.Join(including, " -> "), resolved)
+		}
+	}
+
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		return readStructuredConfig(path)
+	default:
+		return readJailConfigIncluding(path, including)
+	}
+}
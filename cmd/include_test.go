@@ -0,0 +1,170 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExpandLine tests expansion of ~ and environment variables in .jail lines
+func TestExpandLine(t *testing.T) {
+	t.Setenv("HOME", "/home/dev")
+	t.Setenv("TOOLS", "/opt/tools")
+	t.Setenv("XDG_CONFIG_HOME", "")
+	t.Setenv("XDG_CACHE_HOME", "/var/cache/dev")
+	t.Setenv("JAIL_TEST_UNSET", "")
+
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"~/.cache/go-build rw", "/home/dev/.cache/go-build rw"},
+		{"$HOME/.npm rw", "/home/dev/.npm rw"},
+		{"${TOOLS}/bin:/opt/bin", "/opt/tools/bin:/opt/bin"},
+		{"~/toolchains/go:~/go", "/home/dev/toolchains/go:/home/dev/go"},
+		{"$XDG_CONFIG_HOME/tool", "/home/dev/.config/tool"},
+		{"$XDG_CACHE_HOME/tool", "/var/cache/dev/tool"},
+		{"mask *.pem  secrets/~backup", "mask *.pem secrets/~backup"},
+		{"/opt/$$literal", "/opt/$literal"},
+	}
+	for _, tt := range tests {
+		got, missing := expandLine(tt.line)
+
+		assert.Empty(t, missing, tt.line)
+		assert.Equal(t, tt.want, got, tt.line)
+	}
+
+	_, missing := expandLine("$JAIL_TEST_UNSET/data:${JAIL_TEST_UNSET_TOO}/data")
+	assert.Equal(t, []string{"$JAIL_TEST_UNSET", "$JAIL_TEST_UNSET_TOO"}, missing)
+}
+
+// TestIncludeDirFiles tests which files an include-dir line reads
+func TestIncludeDirFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"20-node.conf": "", "10-go.conf": "", "README": ""})
+
+	t.Run("directory", func(t *testing.T) {
+		files, exists, err := includeDirFiles(dir)
+
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []string{filepath.Join(dir, "10-go.conf"), filepath.Join(dir, "20-node.conf")}, files)
+	})
+
+	t.Run("pattern", func(t *testing.T) {
+		files, exists, err := includeDirFiles(filepath.Join(dir, "*-node.conf"))
+
+		require.NoError(t, err)
+		assert.True(t, exists)
+		assert.Equal(t, []string{filepath.Join(dir, "20-node.conf")}, files)
+	})
+
+	t.Run("missing directory", func(t *testing.T) {
+		files, exists, err := includeDirFiles(filepath.Join(dir, "missing", "*.conf"))
+
+		require.NoError(t, err)
+		assert.False(t, exists)
+		assert.Empty(t, files)
+	})
+
+	t.Run("not a directory", func(t *testing.T) {
+		_, _, err := includeDirFiles(filepath.Join(dir, "README"))
+
+		assert.ErrorContains(t, err, "not a directory")
+	})
+}
+
+// TestReadJailConfigIncludes tests include lines, expansion and warnings in .jail files
+func TestReadJailConfigIncludes(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("HOME", "/home/dev")
+	configPath := filepath.Join(dir, ".jail")
+
+	t.Run("included files are read in place", func(t *testing.T) {
+		writeFiles(t, dir, map[string]string{
+			"shared/base.conf":      "net none\n/opt/base\n",
+			"jail.d/10-go.conf":     "~/go/pkg/mod rw\n",
+			"jail.d/20-node.conf":   "~/.npm rw\n",
+			"jail.d/profiles.yaml":  "version: 1\n",
+			"shared/registry.yml":   "version: 1\nnetwork:\n  allow: [registry.npmjs.org]\n",
+			"shared/seccomp/a.conf": "seccomp profile.json\n",
+		})
+		content := "include shared/base.conf\ninclude-dir jail.d\ninclude " + filepath.Join(dir, "shared", "registry.yml") + "\ninclude shared/seccomp/a.conf\nnet proxy\n"
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+		cfg, err := readJailConfig(configPath)
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeProxy, cfg.netMode)
+		assert.Equal(t, []string{"/opt/base", "/home/dev/go/pkg/mod", "/home/dev/.npm"}, mountTargets(cfg.mounts))
+		assert.Len(t, cfg.allow, 1)
+		assert.Equal(t, filepath.Join(dir, "shared", "seccomp", "profile.json"), cfg.seccomp)
+	})
+
+	t.Run("unset variables skip the line with a warning", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(configPath, []byte("/opt/a\n$JAIL_TEST_UNSET/cache rw\n"), 0644))
+
+		cfg, err := readJailConfig(configPath)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/opt/a"}, mountTargets(cfg.mounts))
+		assert.Equal(t, []string{configPath + `:2: skipping "$JAIL_TEST_UNSET/cache rw": $JAIL_TEST_UNSET not set`}, cfg.warnings)
+	})
+
+	t.Run("app paths are not expanded", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(configPath, []byte("[app agent]\ndir ~/.agent\n"), 0644))
+
+		cfg, err := readJailConfig(configPath)
+
+		require.NoError(t, err)
+		assert.Equal(t, []appPath{{path: ".agent"}}, cfg.appDefs["agent"].paths)
+	})
+
+	t.Run("cycles", func(t *testing.T) {
+		writeFiles(t, dir, map[string]string{"a.conf": "include b.conf\n", "b.conf": "include a.conf\n"})
+		require.NoError(t, os.WriteFile(configPath, []byte("include a.conf\n"), 0644))
+
+		_, err := readJailConfig(configPath)
+
+		assert.ErrorContains(t, err, "include cycle: "+configPath+" -> "+filepath.Join(dir, "a.conf")+" -> "+filepath.Join(dir, "b.conf")+" -> "+filepath.Join(dir, "a.conf"))
+	})
+
+	t.Run("include-dir with a pattern", func(t *testing.T) {
+		writeFiles(t, dir, map[string]string{"conf.d/a.conf": "/opt/a\n", "conf.d/b.txt": "/opt/b\n"})
+		require.NoError(t, os.WriteFile(configPath, []byte("include-dir conf.d/*.conf\n"), 0644))
+
+		cfg, err := readJailConfig(configPath)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/opt/a"}, mountTargets(cfg.mounts))
+		assert.Empty(t, cfg.warnings)
+	})
+
+	t.Run("missing include-dir directory", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(configPath, []byte("include-dir missing.d\n"), 0644))
+
+		cfg, err := readJailConfig(configPath)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{configPath + ":1: include-dir " + filepath.Join(dir, "missing.d") + ": no such directory"}, cfg.warnings)
+	})
+
+	t.Run("missing include", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(configPath, []byte("/opt/a\ninclude missing.conf\n"), 0644))
+
+		_, err := readJailConfig(configPath)
+
+		assert.ErrorContains(t, err, configPath+":2: including "+filepath.Join(dir, "missing.conf"))
+	})
+
+	t.Run("include inside a section", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(configPath, []byte("[profile x]\ninclude a.conf\n"), 0644))
+
+		_, err := readJailConfig(configPath)
+
+		assert.ErrorContains(t, err, configPath+":2: include must come before the first [section]")
+	})
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/wfaler/jail/jail"
+)
+
+// sizeLimits are the resources given in bytes, which accept k, m, g and t suffixes
+var sizeLimits = map[string]bool{"memory": true, "fsize": true}
+
+// sizePattern matches byte counts with an optional binary unit suffix
+var sizePattern = regexp.MustCompile(`^([0-9]+)([kKmMgGtT]?)$`)
+
+// parseLimit parses the value of a resource limit such as "nofile: 1024" or "memory: 4g"
+func parseLimit(name, value string) (uint64, error) {
+	if !slices.Contains(jail.LimitNames(), name) {
+		return 0, fmt.Errorf("unknown resource limit %q (expected one of: %s)", name, strings.Join(jail.LimitNames(), ", "))
+	}
+
+	if !sizeLimits[name] {
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s limit %q: expected a number", name, value)
+		}
+		return n, nil
+	}
+
+	m := sizePattern.FindStringSubmatch(value)
+	if m == nil {
+		return 0, fmt.Errorf("invalid %s limit %q (use e.g. 512m or 4g)", name, value)
+	}
+	n, err := strconv.ParseUint(m[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid %s limit %q: %w", name, value, err)
+	}
+	shift := map[string]uint{"": 0, "k": 10, "m": 20, "g": 30, "t": 40}[strings.ToLower(m[2])]
+	if n > (^uint64(0))>>shift {
+		return 0, fmt.Errorf("%s limit %q is too large", name, value)
+	}
+	return n << shift, nil
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseLimit tests parsing of resource limit values
+func TestParseLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		want  uint64
+	}{
+		{"nofile", "1024", 1024},
+		{"nproc", "512", 512},
+		{"cpu", "600", 600},
+		{"memory", "4g", 4 << 30},
+		{"memory", "512M", 512 << 20},
+		{"fsize", "100k", 100 << 10},
+		{"fsize", "4096", 4096},
+	}
+	for _, tt := range tests {
+		got, err := parseLimit(tt.name, tt.value)
+
+		require.NoError(t, err, "%s=%s", tt.name, tt.value)
+		assert.Equal(t, tt.want, got, "%s=%s", tt.name, tt.value)
+	}
+
+	for _, bad := range [][2]string{{"nofile", "1k"}, {"memory", "lots"}, {"memory", "99999999999t"}, {"stack", "1"}} {
+		_, err := parseLimit(bad[0], bad[1])
+		assert.Error(t, err, "%s=%s", bad[0], bad[1])
+	}
+}
@@ -1,47 +1,49 @@
 package main
 
 import (
-	"bufio"
-	"errors"
+	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
-	"syscall"
-)
 
-const setupFlag = "__JAIL_SETUP__"
+	"github.com/wfaler/jail/jail"
+)
 
 // jailArgs represents parsed command-line arguments
 type jailArgs struct {
-	jailDir string
-	cmdName string
-	cmdArgs []string
+	jailDir   string
+	netMode   string
+	devMode   string
+	seccomp   string
+	profile   string
+	apps      []string
+	noInherit bool
+	readOnly  bool
+	overlay   bool
+	snapshot  bool
+	dryRun    bool
+	noInit    bool   // --init=false: the command runs as PID 1 itself
+	output    string // format of the --dry-run plan, "text" or "json"
+	cmdName   string
+	cmdArgs   []string
 }
 
-// parseArgs parses command-line arguments and returns the jail configuration
+// boolFlags are the flags that take no value; "--flag=false" turns them off again
+var boolFlags = map[string]bool{"--ro": true, "--overlay": true, "--snapshot": true, "--no-inherit": true, "--dry-run": true, "--init": true}
+
+// parseArgs parses command-line arguments and returns the jail configuration.
+// Flags must come before the command; "--" ends flag parsing.
 func parseArgs(args []string) (*jailArgs, error) {
 	if len(args) < 1 {
 		return nil, fmt.Errorf("no command specified")
 	}
 
-	result := &jailArgs{}
-	remainingArgs := args
-
-	// Check for -d or --dir flag
-	if len(remainingArgs) >= 2 && (remainingArgs[0] == "-d" || remainingArgs[0] == "--dir") {
-		result.jailDir = remainingArgs[1]
-		remainingArgs = remainingArgs[2:]
-	} else {
-		// Default to current directory
-		var err error
-		result.jailDir, err = os.Getwd()
-		if err != nil {
-			return nil, fmt.Errorf("getting current directory: %w", err)
-		}
+	result, remainingArgs, err := parseFlags(args)
+	if err != nil {
+		return nil, err
 	}
-
 	if len(remainingArgs) == 0 {
 		return nil, fmt.Errorf("no command specified")
 	}
@@ -52,52 +54,188 @@ func parseArgs(args []string) (*jailArgs, error) {
 	return result, nil
 }
 
-// readJailConfig reads a .jail file and returns additional directories to bind mount
-func readJailConfig(configPath string) ([]string, error) {
-	file, err := os.Open(configPath) //nolint:gosec // Config file path comes from workspace directory
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		if closeErr := file.Close(); closeErr != nil && err == nil {
-			err = closeErr
+// parseFlags parses the flags at the start of args and returns the arguments after them
+func parseFlags(args []string) (*jailArgs, []string, error) {
+	result := &jailArgs{}
+	remainingArgs := args
+
+	for len(remainingArgs) > 0 && strings.HasPrefix(remainingArgs[0], "-") {
+		arg := remainingArgs[0]
+		remainingArgs = remainingArgs[1:]
+		if arg == "--" {
+			break
 		}
-	}()
-
-	var dirs []string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		// Skip empty lines and comments
-		if line == "" || strings.HasPrefix(line, "#") {
+
+		name, value, hasValue := strings.Cut(arg, "=")
+		if boolFlags[name] {
+			enabled := true
+			if hasValue {
+				var err error
+				if enabled, err = strconv.ParseBool(value); err != nil {
+					return nil, nil, fmt.Errorf("invalid value %q for flag %s", value, name)
+				}
+			}
+			switch name {
+			case "--ro":
+				result.readOnly = enabled
+			case "--overlay":
+				result.overlay = enabled
+			case "--snapshot":
+				result.snapshot = enabled
+			case "--no-inherit":
+				result.noInherit = enabled
+			case "--dry-run":
+				result.dryRun = enabled
+			case "--init":
+				result.noInit = !enabled
+			}
 			continue
 		}
-		dirs = append(dirs, line)
+
+		// Other flags take a value either as "--flag=value" or as the next argument
+		if !hasValue {
+			if len(remainingArgs) == 0 {
+				return nil, nil, fmt.Errorf("flag %s requires a value", name)
+			}
+			value = remainingArgs[0]
+			remainingArgs = remainingArgs[1:]
+		}
+
+		switch name {
+		case "-d", "--dir":
+			result.jailDir = value
+		case "--net":
+			mode, err := parseNetMode(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			result.netMode = mode
+		case "--dev":
+			mode, err := parseDevMode(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			result.devMode = mode
+		case "--seccomp":
+			if value == "" {
+				return nil, nil, fmt.Errorf("flag --seccomp requires a value")
+			}
+			result.seccomp = value
+		case "--profile":
+			profile, err := parseSectionName(value)
+			if err != nil {
+				return nil, nil, err
+			}
+			result.profile = profile
+		case "--app":
+			for _, name := range strings.Split(value, ",") {
+				app, err := parseSectionName(name)
+				if err != nil {
+					return nil, nil, err
+				}
+				result.apps = append(result.apps, app)
+			}
+		case "--output":
+			if value != planOutputText && value != planOutputJSON {
+				return nil, nil, fmt.Errorf("invalid output format %q (valid: text, json)", value)
+			}
+			result.output = value
+		default:
+			return nil, nil, fmt.Errorf("unknown flag %s", name)
+		}
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, err
+	if result.jailDir == "" {
+		// Default to current directory
+		var err error
+		result.jailDir, err = os.Getwd()
+		if err != nil {
+			return nil, nil, fmt.Errorf("getting current directory: %w", err)
+		}
+	}
+
+	if result.overlay && result.snapshot {
+		return nil, nil, fmt.Errorf("--overlay and --snapshot cannot be combined: an overlay run leaves the directory unchanged")
+	}
+	if result.overlay && result.readOnly {
+		return nil, nil, fmt.Errorf("--overlay and --ro cannot be combined")
+	}
+	if result.output != "" && !result.dryRun {
+		return nil, nil, fmt.Errorf("--output only applies to --dry-run")
 	}
 
-	return dirs, nil
+	return result, remainingArgs, nil
 }
 
 func main() {
-	// Stage 2: We're inside the namespace, set up bind mounts and exec
-	if os.Getenv(setupFlag) == "1" {
-		if err := setupJailAndExec(); err != nil {
-			fmt.Fprintf(os.Stderr, "Setup error: %v\n", err)
-			os.Exit(1)
+	// Stage 2: inside the new namespaces jail.Init builds the jail and execs the command
+	jail.Init()
+
+	// Subcommands take the place of the command to run; use "--" to run a program of the same name
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "doctor":
+			if err := runDoctor(os.Stdout); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "gc":
+			if err := runGC(os.Stdout, os.TempDir()); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "apply":
+			if err := runOverlayCommand(os.Stdout, "apply", os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "discard":
+			if err := runDiscard(os.Stdout, os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "rollback":
+			if err := runRollback(os.Stdout, os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
+		case "config":
+			if err := runConfigCommand(os.Stdout, os.Args[2:]); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+			return
 		}
-		return
 	}
 
 	// Stage 1: Parse arguments and validate
 	if len(os.Args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s [-d <directory>] <command> [args...]\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "Usage: %s [-d <directory>] [--net=host|none|proxy] [--dev=private|host] [--seccomp=<profile.json>|unconfined] [--profile=<name>] [--app=<name>,...] [--no-inherit] [--ro] [--overlay|--snapshot] [--init=false] [--dry-run [--output=text|json]] <command> [args...]\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "\nExamples:\n")
 		fmt.Fprintf(os.Stderr, "  %s /bin/sh                  # jail in current directory\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "  %s -d /tmp/mydir /bin/sh    # jail in /tmp/mydir\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --net=none make test     # jail without network access\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --net=proxy npm install  # only reach hosts allowed in .jail\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --seccomp=unconfined gdb # disable the default syscall filter\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --dev=host lsblk         # see all host devices\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --profile=offline make   # apply the [profile offline] settings from .jail\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --app=gh,aws make deploy # keep the gh and aws logins from the home directory\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --ro golangci-lint run   # mount the directory read-only\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --overlay make           # keep changes to the directory aside for review\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --snapshot make          # record the directory so changes can be rolled back\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s --dry-run make           # print the mounts and namespaces without running make\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "\n  %s doctor                   # report the isolation features this kernel supports\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s gc                       # remove jail-root-* directories left by older versions\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s apply <session>          # write the changes of an --overlay run to the directory\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s discard <session>        # drop the changes of an --overlay run, or a snapshot\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s rollback [<snapshot>]    # restore what a --snapshot run changed or deleted\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s config check             # report problems in the .jail files that apply here\n", os.Args[0])
+		fmt.Fprintf(os.Stderr, "  %s config show [<command>]  # print the effective config and where each entry comes from\n", os.Args[0])
 		os.Exit(1)
 	}
 
@@ -114,326 +252,123 @@ func main() {
 		os.Exit(1)
 	}
 
-	// Re-exec ourselves with namespaces enabled
-	// Pass all original arguments
-	cmd := exec.Command("/proc/self/exe", os.Args[1:]...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	cmd.Env = append(os.Environ(), setupFlag+"=1")
-
-	cmd.SysProcAttr = &syscall.SysProcAttr{
-		// Create new namespaces
-		Cloneflags: syscall.CLONE_NEWNS | // Mount namespace - isolate filesystem
-			syscall.CLONE_NEWUSER | // User namespace - run unprivileged
-			syscall.CLONE_NEWPID | // PID namespace - process isolation
-			syscall.CLONE_NEWUTS | // UTS namespace - hostname isolation
-			syscall.CLONE_NEWIPC, // IPC namespace
-
-		// Map current user to "root" inside namespace (but not real root!)
-		UidMappings: []syscall.SysProcIDMap{{
-			ContainerID: 0,
-			HostID:      os.Getuid(),
-			Size:        1,
-		}},
-		GidMappings: []syscall.SysProcIDMap{{
-			ContainerID: 0,
-			HostID:      os.Getgid(),
-			Size:        1,
-		}},
-
-		// Prevent gaining privileges
-		AmbientCaps: []uintptr{},
-	}
-
-	if err := cmd.Run(); err != nil {
-		var exitErr *exec.ExitError
-		if errors.As(err, &exitErr) {
-			os.Exit(exitErr.ExitCode())
-		}
+	cfg, err := loadJailConfig(parsedArgs)
+	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		os.Exit(1)
 	}
-}
-
-// getDockerSocketPath detects the Docker socket location from environment or common paths
-func getDockerSocketPath() string {
-	// Check DOCKER_HOST environment variable
-	if dockerHost := os.Getenv("DOCKER_HOST"); dockerHost != "" {
-		// DOCKER_HOST can be unix:///path/to/socket or just /path/to/socket
-		if strings.HasPrefix(dockerHost, "unix://") {
-			return strings.TrimPrefix(dockerHost, "unix://")
-		}
-		// If it's a file path (starts with /), use it directly
-		if strings.HasPrefix(dockerHost, "/") {
-			return dockerHost
-		}
+	for _, warning := range cfg.warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
 	}
 
-	// Check common Docker socket locations
-	commonPaths := []string{
-		"/var/run/docker.sock", // Standard Linux location
-		"/run/docker.sock",     // Alternative Linux location
-		filepath.Join(os.Getenv("HOME"), ".docker", "run", "docker.sock"), // Rootless Docker
+	// Report a broken plan, such as an unreadable seccomp profile, before creating any namespaces
+	plan, err := planJail(parsedArgs, cfg)
+	if err == nil {
+		err = plan.Validate()
 	}
-
-	for _, path := range commonPaths {
-		if _, err := os.Stat(path); err == nil {
-			return path
-		}
-	}
-
-	return ""
-}
-
-// mountDockerSocket mounts the Docker socket into the jail for Docker support
-func mountDockerSocket(tmpRoot string) error {
-	dockerSocketPath := getDockerSocketPath()
-	if dockerSocketPath == "" {
-		return fmt.Errorf("docker socket not found")
-	}
-
-	// Verify the socket exists and is accessible
-	info, err := os.Stat(dockerSocketPath)
 	if err != nil {
-		return fmt.Errorf("docker socket at %s not accessible: %w", dockerSocketPath, err)
-	}
-
-	// Verify it's a socket
-	if info.Mode()&os.ModeSocket == 0 {
-		return fmt.Errorf("docker socket at %s is not a socket", dockerSocketPath)
-	}
-
-	// Create the parent directory structure in the jail
-	jailSocketPath := filepath.Join(tmpRoot, strings.TrimPrefix(dockerSocketPath, "/"))
-	jailSocketDir := filepath.Dir(jailSocketPath)
-
-	if err := os.MkdirAll(jailSocketDir, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
-		return fmt.Errorf("creating docker socket directory %s: %w", jailSocketDir, err)
-	}
-
-	// Create an empty file to bind mount over (sockets can't be created directly)
-	// We use a regular file as the mount point
-	if err := os.WriteFile(jailSocketPath, []byte{}, 0666); err != nil { //nolint:gosec,mnd // Will inherit actual socket permissions
-		return fmt.Errorf("creating docker socket mount point: %w", err)
-	}
-
-	// Bind mount the socket
-	if err := syscall.Mount(dockerSocketPath, jailSocketPath, "", syscall.MS_BIND, ""); err != nil {
-		return fmt.Errorf("bind mounting docker socket: %w", err)
-	}
-
-	return nil
-}
-
-//nolint:gocognit,gocyclo // Complex namespace setup is inherently complex
-func setupJailAndExec() error {
-	// Parse arguments same as main()
-	parsedArgs, err := parseArgs(os.Args[1:])
-	if err != nil {
-		return fmt.Errorf("parsing arguments: %w", err)
-	}
-
-	jailDir := parsedArgs.jailDir
-	cmdName := parsedArgs.cmdName
-	cmdArgs := parsedArgs.cmdArgs
-
-	// Make jail directory absolute
-	jailDir, err = filepath.Abs(jailDir)
-	if err != nil {
-		return fmt.Errorf("getting absolute path: %w", err)
-	}
-
-	// Critical: Make all mounts private to prevent propagation issues
-	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
-		return fmt.Errorf("making root mount private: %w", err)
-	}
-
-	// Create a temporary root directory structure
-	tmpRoot, err := os.MkdirTemp("", "jail-root-")
-	if err != nil {
-		return fmt.Errorf("creating temp root: %w", err)
-	}
-	// NOTE: We deliberately do NOT clean up tmpRoot with os.RemoveAll()
-	// because:
-	// 1. We use syscall.Exec() to replace this process, so defer won't run anyway
-	// 2. The mount namespace is automatically cleaned up by the kernel when the
-	//    process exits, which unmounts all bind mounts safely
-	// 3. Explicitly calling os.RemoveAll() is dangerous - if bind mounts aren't
-	//    properly unmounted first, it could traverse into bind-mounted directories
-	//    and delete real host files (e.g., if ~/bin is in .jail, it could delete
-	//    the actual ~/bin directory on the host)
-
-	// Directories to bind mount from host (read-only access to tools)
-	bindDirs := []string{
-		"/bin",
-		"/usr",
-		"/lib",
-		"/lib64",
-		"/sbin",
-		"/etc", // Needed for DNS resolution and network configs
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
 	}
 
-	// Read global .jail config from $HOME/.jail if it exists
-	if hostHome := os.Getenv("HOME"); hostHome != "" {
-		globalConfigFile := filepath.Join(hostHome, ".jail")
-		if extraDirs, err := readJailConfig(globalConfigFile); err == nil {
-			bindDirs = append(bindDirs, extraDirs...)
+	// With --dry-run the plan is printed instead of carried out
+	if parsedArgs.dryRun {
+		if err := printPlan(os.Stdout, plan, parsedArgs.output); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
+		return
 	}
 
-	// Read additional directories from workspace .jail file if it exists
-	// This allows local config to add to or override global config
-	jailConfigFile := filepath.Join(jailDir, ".jail")
-	if extraDirs, err := readJailConfig(jailConfigFile); err == nil {
-		bindDirs = append(bindDirs, extraDirs...)
-	}
-
-	// Create mount points in temp root and bind mount system directories
-	for _, dir := range bindDirs {
-		// Check if source exists on host
-		if _, err := os.Stat(dir); os.IsNotExist(err) {
-			continue // Skip if doesn't exist on this system
+	// With --snapshot the workspace is recorded outside the jail before the command can change it
+	var snapshot *workspaceSnapshot
+	if parsedArgs.snapshot {
+		workspace, err := filepath.Abs(jailDir)
+		if err == nil {
+			snapshot, err = takeSnapshot(workspace, cfg.snapshotExclude)
 		}
-
-		targetDir := filepath.Join(tmpRoot, dir)
-		if err := os.MkdirAll(targetDir, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
-			return fmt.Errorf("creating mount point %s: %w", targetDir, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
+	}
 
-		// Bind mount (read-only)
-		if err := syscall.Mount(dir, targetDir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
-			return fmt.Errorf("bind mounting %s: %w", dir, err)
+	// With --overlay the jailed process writes to an upper layer kept in a session
+	// outside the workspace; the workspace itself stays untouched until "jail apply"
+	var session *overlaySession
+	if parsedArgs.overlay {
+		workspace, err := filepath.Abs(jailDir)
+		if err == nil {
+			session, err = newOverlaySession(workspace)
 		}
-
-		// Make it read-only
-		if err := syscall.Mount("", targetDir, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_RDONLY|syscall.MS_REC, ""); err != nil {
-			return fmt.Errorf("remounting %s as read-only: %w", dir, err)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
 		}
+		plan.setOverlaySession(session)
 	}
 
-	// Create workspace mount point at /workspace/{basename}
-	// This preserves project identity while providing a clean path structure
-	workspaceDir := filepath.Join(tmpRoot, "workspace", filepath.Base(jailDir))
-	if err := os.MkdirAll(workspaceDir, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
-		return fmt.Errorf("creating workspace: %w", err)
-	}
+	err = jail.Run(context.Background(), plan.Spec, jail.Stdio{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr})
 
-	if err := syscall.Mount(jailDir, workspaceDir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
-		return fmt.Errorf("bind mounting workspace: %w", err)
+	// A command killed by a signal is reported as 128 plus the signal number, like shells do
+	exitCode, ok := jail.ExitStatus(err)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		exitCode = 1
 	}
 
-	// Mount ~/.claude directory and ~/.claude.json file from host to preserve login state
-	// Note: HOME is still /home/$USER inside jail, not /root
-	hostHome := os.Getenv("HOME")
-	if hostHome != "" {
-		// Mount ~/.claude directory
-		hostClaudeDir := filepath.Join(hostHome, ".claude")
-		if _, err := os.Stat(hostClaudeDir); err == nil {
-			jailClaudeDir := filepath.Join(tmpRoot, strings.TrimPrefix(hostHome, "/"), ".claude")
-			if err := os.MkdirAll(jailClaudeDir, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
-				return fmt.Errorf("creating %s/.claude: %w", hostHome, err)
-			}
-
-			// Bind mount (read-write for login persistence)
-			if err := syscall.Mount(hostClaudeDir, jailClaudeDir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
-				return fmt.Errorf("bind mounting .claude: %w", err)
-			}
-		}
-
-		// Mount ~/.claude.json file
-		hostClaudeJSON := filepath.Join(hostHome, ".claude.json")
-		if _, err := os.Stat(hostClaudeJSON); err == nil {
-			jailClaudeJSON := filepath.Join(tmpRoot, strings.TrimPrefix(hostHome, "/"), ".claude.json")
-			// Create parent directory if it doesn't exist
-			if err := os.MkdirAll(filepath.Dir(jailClaudeJSON), 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
-				return fmt.Errorf("creating parent dir for .claude.json: %w", err)
-			}
-			// Create empty file to mount over
-			if err := os.WriteFile(jailClaudeJSON, []byte{}, 0600); err != nil {
-				return fmt.Errorf("creating .claude.json mount point: %w", err)
-			}
-
-			// Bind mount the file
-			if err := syscall.Mount(hostClaudeJSON, jailClaudeJSON, "", syscall.MS_BIND, ""); err != nil {
-				return fmt.Errorf("bind mounting .claude.json: %w", err)
-			}
+	if session != nil {
+		if err := reportOverlaySession(os.Stderr, session); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		}
 	}
-
-	// Mount XDG_RUNTIME_DIR for runtime data (needed by some tools like Claude)
-	xdgRuntimeDir := os.Getenv("XDG_RUNTIME_DIR")
-	if xdgRuntimeDir != "" {
-		if _, err := os.Stat(xdgRuntimeDir); err == nil {
-			jailRuntimeDir := filepath.Join(tmpRoot, strings.TrimPrefix(xdgRuntimeDir, "/"))
-			if err := os.MkdirAll(jailRuntimeDir, 0700); err != nil { //nolint:gosec,mnd // 0700 is appropriate for runtime directory permissions
-				return fmt.Errorf("creating %s: %w", xdgRuntimeDir, err)
-			}
-
-			// Bind mount (read-write for runtime data)
-			if err := syscall.Mount(xdgRuntimeDir, jailRuntimeDir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
-				return fmt.Errorf("bind mounting XDG_RUNTIME_DIR: %w", err)
-			}
+	if snapshot != nil {
+		if err := reportSnapshot(os.Stderr, snapshot); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
 		}
 	}
-
-	// Mount Docker socket for Docker support
-	if err := mountDockerSocket(tmpRoot); err != nil {
-		// Non-fatal: Docker might not be installed or running
-		// Don't return error, just log warning to stderr
-		fmt.Fprintf(os.Stderr, "Warning: Docker socket not mounted: %v\n", err)
+	if exitCode != 0 {
+		os.Exit(exitCode)
 	}
+}
 
-	// Create essential directories
-	essentialDirs := []string{"/proc", "/dev", "/tmp"}
-	for _, dir := range essentialDirs {
-		targetDir := filepath.Join(tmpRoot, dir)
-		if err := os.MkdirAll(targetDir, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
-			return fmt.Errorf("creating %s: %w", dir, err)
+// getDockerSocketPath detects the Docker socket location from environment or common paths
+func getDockerSocketPath() string {
+	// Check DOCKER_HOST environment variable
+	if dockerHost := os.Getenv("DOCKER_HOST"); dockerHost != "" {
+		// DOCKER_HOST can be unix:///path/to/socket or just /path/to/socket
+		if strings.HasPrefix(dockerHost, "unix://") {
+			return strings.TrimPrefix(dockerHost, "unix://")
+		}
+		// If it's a file path (starts with /), use it directly
+		if strings.HasPrefix(dockerHost, "/") {
+			return dockerHost
 		}
 	}
 
-	// Mount /proc (needed for many commands)
-	procDir := filepath.Join(tmpRoot, "proc")
-	if err := syscall.Mount("proc", procDir, "proc", 0, ""); err != nil {
-		return fmt.Errorf("mounting proc: %w", err)
-	}
-
-	// Bind mount /dev from host
-	devDir := filepath.Join(tmpRoot, "dev")
-	if err := syscall.Mount("/dev", devDir, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
-		return fmt.Errorf("bind mounting /dev: %w", err)
-	}
-
-	// Chroot into temp root
-	if err := syscall.Chroot(tmpRoot); err != nil {
-		return fmt.Errorf("chroot: %w", err)
-	}
-
-	// Change to /workspace/{basename} directory
-	workspacePath := filepath.Join("/workspace", filepath.Base(jailDir))
-	if err := os.Chdir(workspacePath); err != nil {
-		return fmt.Errorf("chdir to %s: %w", workspacePath, err)
+	// Check common Docker socket locations
+	commonPaths := []string{
+		"/var/run/docker.sock", // Standard Linux location
+		"/run/docker.sock",     // Alternative Linux location
+		filepath.Join(os.Getenv("HOME"), ".docker", "run", "docker.sock"), // Rootless Docker
 	}
 
-	// Resolve command path if it's not absolute
-	resolvedCmd, err := resolveCommand(cmdName, bindDirs)
-	if err != nil {
-		return fmt.Errorf("finding command %s: %w", cmdName, err)
+	for _, path := range commonPaths {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
 	}
 
-	// Ensure HOME is set correctly so Claude can find its config at $HOME/.claude
-	env := os.Environ()
-	if hostHome != "" {
-		env = setOrUpdateEnv(env, "HOME", hostHome)
-	}
+	return ""
+}
 
-	// Execute the actual command
-	if err := syscall.Exec(resolvedCmd, append([]string{cmdName}, cmdArgs...), env); err != nil {
-		return fmt.Errorf("exec %s: %w", cmdName, err)
+// mountTargets returns the paths of the given mounts inside the jail
+func mountTargets(mounts []mountEntry) []string {
+	targets := make([]string, 0, len(mounts))
+	for _, m := range mounts {
+		targets = append(targets, m.target)
 	}
-
-	return nil
+	return targets
 }
 
 // setOrUpdateEnv updates an environment variable in the env slice, or adds it if not present
@@ -451,8 +386,9 @@ func setOrUpdateEnv(env []string, key, value string) []string {
 	return append(env, newEntry)
 }
 
-// resolveCommand finds the full path to a command by searching standard directories
-func resolveCommand(cmdName string, searchDirs []string) (string, error) {
+// resolveCommand finds the full path to a command by searching standard
+// directories, looking up candidates with stat
+func resolveCommand(cmdName string, searchDirs []string, stat func(string) (os.FileInfo, error)) (string, error) {
 	// If it's already an absolute path or contains a slash, use it as-is
 	if filepath.IsAbs(cmdName) || strings.Contains(cmdName, "/") {
 		return cmdName, nil
@@ -479,7 +415,7 @@ func resolveCommand(cmdName string, searchDirs []string) (string, error) {
 	// Search for the executable
 	for _, dir := range pathDirs {
 		candidatePath := filepath.Join(dir, cmdName)
-		if info, err := os.Stat(candidatePath); err == nil && !info.IsDir() {
+		if info, err := stat(candidatePath); err == nil && !info.IsDir() {
 			// Check if executable
 			if info.Mode()&0111 != 0 {
 				return candidatePath, nil
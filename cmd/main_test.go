@@ -65,120 +65,208 @@ func TestParseArgs(t *testing.T) {
 		assert.Equal(t, []string{"file.txt", "--number"}, result.cmdArgs)
 	})
 
-	t.Run("error when no command specified", func(t *testing.T) {
-		args := []string{}
+	t.Run("with --dir= flag", func(t *testing.T) {
+		args := []string{"--dir=/srv/app", "make"}
 		result, err := parseArgs(args)
 
-		assert.Error(t, err)
-		assert.Nil(t, result)
-		assert.Contains(t, err.Error(), "no command specified")
+		require.NoError(t, err)
+		assert.Equal(t, "/srv/app", result.jailDir)
+		assert.Equal(t, "make", result.cmdName)
 	})
 
-	t.Run("error when only directory flag provided", func(t *testing.T) {
-		args := []string{"-d", "/tmp/test"}
+	t.Run("with --net flag", func(t *testing.T) {
+		args := []string{"--net=none", "-d", "/tmp/test", "make", "test"}
+		result, err := parseArgs(args)
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeNone, result.netMode)
+		assert.Equal(t, "/tmp/test", result.jailDir)
+		assert.Equal(t, "make", result.cmdName)
+		assert.Equal(t, []string{"test"}, result.cmdArgs)
+	})
+
+	t.Run("with --net flag as separate argument", func(t *testing.T) {
+		args := []string{"--net", "host", "ls"}
+		result, err := parseArgs(args)
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeHost, result.netMode)
+	})
+
+	t.Run("invalid --net value", func(t *testing.T) {
+		args := []string{"--net=offline", "ls"}
 		result, err := parseArgs(args)
 
 		assert.Error(t, err)
 		assert.Nil(t, result)
-		assert.Contains(t, err.Error(), "no command specified")
+		assert.Contains(t, err.Error(), "unknown network mode")
 	})
-}
 
-// TestReadJailConfig tests the .jail file parsing
-func TestReadJailConfig(t *testing.T) {
-	t.Run("parse valid config file", func(t *testing.T) {
-		// Create a temporary config file
-		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+	t.Run("dev flag", func(t *testing.T) {
+		result, err := parseArgs([]string{"--dev=host", "ls"})
+
 		require.NoError(t, err)
-		defer os.Remove(tmpFile.Name())
+		assert.Equal(t, devModeHost, result.devMode)
 
-		content := `# This is a comment
-/home/user/.local/share/mise
-/home/user/.config/mise
+		_, err = parseArgs([]string{"--dev", "all", "ls"})
+		assert.Error(t, err)
+	})
 
-# Another comment
-/opt/custom-tools
+	t.Run("seccomp flag", func(t *testing.T) {
+		args := []string{"--seccomp=unconfined", "--seccomp", "profile.json", "ls"}
+		result, err := parseArgs(args)
 
-/usr/local/custom-lib
-`
-		_, err = tmpFile.WriteString(content)
 		require.NoError(t, err)
-		tmpFile.Close()
+		assert.Equal(t, "profile.json", result.seccomp)
+		assert.Equal(t, "ls", result.cmdName)
+	})
 
-		dirs, err := readJailConfig(tmpFile.Name())
+	t.Run("profile flag", func(t *testing.T) {
+		result, err := parseArgs([]string{"--profile", "offline", "make"})
 
 		require.NoError(t, err)
-		assert.Len(t, dirs, 4)
-		assert.Equal(t, "/home/user/.local/share/mise", dirs[0])
-		assert.Equal(t, "/home/user/.config/mise", dirs[1])
-		assert.Equal(t, "/opt/custom-tools", dirs[2])
-		assert.Equal(t, "/usr/local/custom-lib", dirs[3])
+		assert.Equal(t, "offline", result.profile)
+
+		_, err = parseArgs([]string{"--profile=../x", "make"})
+		assert.ErrorContains(t, err, "invalid name")
 	})
 
-	t.Run("empty config file", func(t *testing.T) {
-		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+	t.Run("no-inherit flag", func(t *testing.T) {
+		result, err := parseArgs([]string{"--no-inherit", "make"})
+
 		require.NoError(t, err)
-		defer os.Remove(tmpFile.Name())
-		tmpFile.Close()
+		assert.True(t, result.noInherit)
+	})
 
-		dirs, err := readJailConfig(tmpFile.Name())
+	t.Run("dry-run and output flags", func(t *testing.T) {
+		result, err := parseArgs([]string{"--dry-run", "--output=json", "make"})
 
 		require.NoError(t, err)
-		assert.Empty(t, dirs)
+		assert.True(t, result.dryRun)
+		assert.Equal(t, "json", result.output)
+
+		_, err = parseArgs([]string{"--dry-run", "--output=yaml", "make"})
+		assert.ErrorContains(t, err, "invalid output format")
+
+		_, err = parseArgs([]string{"--output=text", "make"})
+		assert.ErrorContains(t, err, "only applies to --dry-run")
 	})
 
-	t.Run("config file with only comments and whitespace", func(t *testing.T) {
-		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+	t.Run("init flag is on by default", func(t *testing.T) {
+		result, err := parseArgs([]string{"make"})
+
 		require.NoError(t, err)
-		defer os.Remove(tmpFile.Name())
+		assert.False(t, result.noInit)
 
-		content := `# Comment 1
-# Comment 2
+		result, err = parseArgs([]string{"--init=false", "make"})
+		require.NoError(t, err)
+		assert.True(t, result.noInit)
 
+		result, err = parseArgs([]string{"--init=false", "--init", "make"})
+		require.NoError(t, err)
+		assert.False(t, result.noInit)
+	})
 
+	t.Run("app flag", func(t *testing.T) {
+		result, err := parseArgs([]string{"--app=gh,aws", "--app", "claude", "make"})
 
-# Comment 3
-`
-		_, err = tmpFile.WriteString(content)
 		require.NoError(t, err)
-		tmpFile.Close()
+		assert.Equal(t, []string{"gh", "aws", "claude"}, result.apps)
+
+		_, err = parseArgs([]string{"--app=gh,", "make"})
+		assert.ErrorContains(t, err, "invalid name")
+	})
 
-		dirs, err := readJailConfig(tmpFile.Name())
+	t.Run("overlay flag takes no value", func(t *testing.T) {
+		result, err := parseArgs([]string{"--overlay", "ls"})
 
 		require.NoError(t, err)
-		assert.Empty(t, dirs)
-	})
+		assert.True(t, result.overlay)
+		assert.Equal(t, "ls", result.cmdName)
 
-	t.Run("config file does not exist", func(t *testing.T) {
-		dirs, err := readJailConfig("/nonexistent/path/.jail")
+		result, err = parseArgs([]string{"--overlay", "--overlay=false", "ls"})
+		require.NoError(t, err)
+		assert.False(t, result.overlay)
 
+		_, err = parseArgs([]string{"--overlay=maybe", "ls"})
 		assert.Error(t, err)
-		assert.Nil(t, dirs)
 	})
 
-	t.Run("config with mixed content", func(t *testing.T) {
-		tmpFile, err := os.CreateTemp("", ".jail-test-*")
+	t.Run("snapshot flag", func(t *testing.T) {
+		result, err := parseArgs([]string{"--snapshot", "make"})
+
+		require.NoError(t, err)
+		assert.True(t, result.snapshot)
+		assert.False(t, result.overlay)
+
+		_, err = parseArgs([]string{"--snapshot", "--overlay", "make"})
+		assert.ErrorContains(t, err, "cannot be combined")
+	})
+
+	t.Run("read-only workspace flag", func(t *testing.T) {
+		result, err := parseArgs([]string{"--ro", "-d", "/src", "grep", "-r", "TODO"})
+
 		require.NoError(t, err)
-		defer os.Remove(tmpFile.Name())
+		assert.True(t, result.readOnly)
+		assert.Equal(t, "/src", result.jailDir)
+
+		_, err = parseArgs([]string{"--ro", "--overlay", "make"})
+		assert.ErrorContains(t, err, "cannot be combined")
+	})
+
+	t.Run("flags after the command are passed to it", func(t *testing.T) {
+		args := []string{"ls", "--net=none", "-d", "x"}
+		result, err := parseArgs(args)
 
-		content := `/first/path
-# Comment
-/second/path
-   /third/path/with/leading/whitespace
-/fourth/path
-`
-		_, err = tmpFile.WriteString(content)
 		require.NoError(t, err)
-		tmpFile.Close()
+		assert.Empty(t, result.netMode)
+		assert.Equal(t, "ls", result.cmdName)
+		assert.Equal(t, []string{"--net=none", "-d", "x"}, result.cmdArgs)
+	})
 
-		dirs, err := readJailConfig(tmpFile.Name())
+	t.Run("double dash ends flag parsing", func(t *testing.T) {
+		args := []string{"--", "-weird-command", "arg"}
+		result, err := parseArgs(args)
 
 		require.NoError(t, err)
-		assert.Len(t, dirs, 4)
-		assert.Equal(t, "/first/path", dirs[0])
-		assert.Equal(t, "/second/path", dirs[1])
-		assert.Equal(t, "/third/path/with/leading/whitespace", dirs[2])
-		assert.Equal(t, "/fourth/path", dirs[3])
+		assert.Equal(t, "-weird-command", result.cmdName)
+		assert.Equal(t, []string{"arg"}, result.cmdArgs)
+	})
+
+	t.Run("unknown flag", func(t *testing.T) {
+		args := []string{"--bogus", "ls"}
+		result, err := parseArgs(args)
+
+		assert.Error(t, err)
+		assert.Nil(t, result)
+		assert.Contains(t, err.Error(), "unknown flag --bogus")
+	})
+
+	t.Run("flag without value", func(t *testing.T) {
+		args := []string{"--net"}
+		result, err := parseArgs(args)
+
+		assert.Error(t, err)
+		assert.Nil(t, result)
+		assert.Contains(t, err.Error(), "requires a value")
+	})
+
+	t.Run("error when no command specified", func(t *testing.T) {
+		args := []string{}
+		result, err := parseArgs(args)
+
+		assert.Error(t, err)
+		assert.Nil(t, result)
+		assert.Contains(t, err.Error(), "no command specified")
+	})
+
+	t.Run("error when only directory flag provided", func(t *testing.T) {
+		args := []string{"-d", "/tmp/test"}
+		result, err := parseArgs(args)
+
+		assert.Error(t, err)
+		assert.Nil(t, result)
+		assert.Contains(t, err.Error(), "no command specified")
 	})
 }
 
@@ -242,14 +330,14 @@ func TestSetOrUpdateEnv(t *testing.T) {
 // TestResolveCommand tests command path resolution
 func TestResolveCommand(t *testing.T) {
 	t.Run("absolute path command", func(t *testing.T) {
-		result, err := resolveCommand("/bin/bash", []string{})
+		result, err := resolveCommand("/bin/bash", []string{}, os.Stat)
 
 		require.NoError(t, err)
 		assert.Equal(t, "/bin/bash", result)
 	})
 
 	t.Run("relative path with slash", func(t *testing.T) {
-		result, err := resolveCommand("./mycommand", []string{})
+		result, err := resolveCommand("./mycommand", []string{}, os.Stat)
 
 		require.NoError(t, err)
 		assert.Equal(t, "./mycommand", result)
@@ -267,7 +355,7 @@ func TestResolveCommand(t *testing.T) {
 		require.NoError(t, err)
 
 		// Test resolving the command
-		result, err := resolveCommand("testcommand", []string{tmpDir})
+		result, err := resolveCommand("testcommand", []string{tmpDir}, os.Stat)
 
 		require.NoError(t, err)
 		assert.Equal(t, testCmd, result)
@@ -289,7 +377,7 @@ func TestResolveCommand(t *testing.T) {
 		require.NoError(t, err)
 
 		// Test resolving the command
-		result, err := resolveCommand("customcmd", []string{tmpDir})
+		result, err := resolveCommand("customcmd", []string{tmpDir}, os.Stat)
 
 		require.NoError(t, err)
 		assert.Equal(t, testCmd, result)
@@ -311,14 +399,14 @@ func TestResolveCommand(t *testing.T) {
 		require.NoError(t, err)
 
 		// Test resolving the command
-		result, err := resolveCommand("shimcmd", []string{tmpDir})
+		result, err := resolveCommand("shimcmd", []string{tmpDir}, os.Stat)
 
 		require.NoError(t, err)
 		assert.Equal(t, testCmd, result)
 	})
 
 	t.Run("command not found", func(t *testing.T) {
-		result, err := resolveCommand("nonexistentcommand12345", []string{})
+		result, err := resolveCommand("nonexistentcommand12345", []string{}, os.Stat)
 
 		assert.Error(t, err)
 		assert.Empty(t, result)
@@ -337,7 +425,7 @@ func TestResolveCommand(t *testing.T) {
 		require.NoError(t, err)
 
 		// Test resolving the command (should fail)
-		result, err := resolveCommand("notexecutable", []string{tmpDir})
+		result, err := resolveCommand("notexecutable", []string{tmpDir}, os.Stat)
 
 		assert.Error(t, err)
 		assert.Empty(t, result)
@@ -356,7 +444,7 @@ func TestResolveCommand(t *testing.T) {
 		require.NoError(t, err)
 
 		// Test resolving (should fail because it's a directory)
-		result, err := resolveCommand(uniqueCmdName, []string{tmpDir})
+		result, err := resolveCommand(uniqueCmdName, []string{tmpDir}, os.Stat)
 
 		assert.Error(t, err)
 		assert.Empty(t, result)
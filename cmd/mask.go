@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/wfaler/jail/jail"
+)
+
+// defaultMasks returns the patterns for common secret files hidden in every workspace
+func defaultMasks() []string {
+	return []string{
+		".env", ".env.*",
+		"*.pem", "*.key", "*.p12", "*.pfx", "*.keystore", "*.jks",
+		"id_rsa", "id_dsa", "id_ecdsa", "id_ed25519",
+		".netrc", ".git-credentials", ".pypirc",
+		"credentials.json", "service-account*.json",
+		"*.tfstate", "*.tfstate.backup",
+	}
+}
+
+// defaultUnmasks returns the patterns for templates that match defaultMasks but hold no secrets
+func defaultUnmasks() []string {
+	return []string{".env.example", ".env.sample", ".env.template", ".env.dist"}
+}
+
+// parseMaskPattern validates a pattern of a .jail "mask" or "unmask" line.
+// Patterns are relative to the workspace; see matchMask.
+func parseMaskPattern(pattern string) (string, error) {
+	if strings.HasPrefix(pattern, "/") {
+		return "", fmt.Errorf("mask pattern %s must be relative to the workspace", pattern)
+	}
+	for _, segment := range strings.Split(strings.TrimSuffix(pattern, "/"), "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", fmt.Errorf("invalid mask pattern %q", pattern)
+		}
+		if _, err := filepath.Match(segment, ""); err != nil {
+			return "", fmt.Errorf("invalid mask pattern %q: %w", pattern, err)
+		}
+	}
+	return pattern, nil
+}
+
+// matchMask reports whether a workspace-relative path matches a mask pattern.
+// Like .gitignore, a pattern without a slash matches a name at any depth, a
+// trailing slash only matches directories, "**" matches any number of
+// directories, and "dir/**" matches dir itself so it is masked as a whole.
+func matchMask(pattern, rel string, isDir bool) bool {
+	if strings.HasSuffix(pattern, "/") {
+		if !isDir {
+			return false
+		}
+		pattern = strings.TrimSuffix(pattern, "/")
+	}
+
+	if !strings.Contains(pattern, "/") {
+		ok, _ := filepath.Match(pattern, filepath.Base(rel))
+		return ok
+	}
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+// matchSegments matches path segments against pattern segments, where "**"
+// stands for zero or more segments
+func matchSegments(pattern, path []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			for i := 0; i <= len(path); i++ {
+				if matchSegments(pattern[1:], path[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(path) == 0 {
+			return false
+		}
+		if ok, _ := filepath.Match(pattern[0], path[0]); !ok {
+			return false
+		}
+		pattern, path = pattern[1:], path[1:]
+	}
+	return len(path) == 0
+}
+
+// matchesAny reports whether rel matches one of the patterns
+func matchesAny(patterns []string, rel string, isDir bool) bool {
+	for _, p := range patterns {
+		if matchMask(p, rel, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// findMasked walks the workspace below root and returns the workspace-relative
+// paths to mask. Masked directories are not descended into, nor are those
+// matching a skip pattern (see isExcluded), and symlinks are replaced by what
+// they point to inside the workspace. Paths matching an unmask pattern are
+// left visible unless they match a locked pattern.
+func findMasked(root, workspace string, masks, unmask, locked, skip []string) ([]string, error) {
+	base := jail.ResolveInRoot(root, workspace)
+	seen := make(map[string]bool)
+	var masked []string
+
+	err := filepath.WalkDir(base, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// Unreadable directories are just as unreadable for the jailed process
+			if os.IsPermission(err) && d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if path == base {
+			return nil
+		}
+		rel, err := filepath.Rel(base, path)
+		if err != nil {
+			return err
+		}
+		isMasked := matchesAny(masks, rel, d.IsDir()) && !matchesAny(unmask, rel, d.IsDir())
+		if !isMasked && !matchesAny(locked, rel, d.IsDir()) {
+			if d.IsDir() && isExcluded(rel, skip) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Type()&fs.ModeSymlink != 0 {
+			// Links leaving the workspace point at paths that are not mounted or are mounted on purpose
+			resolved := jail.ResolveInRoot(root, filepath.Join(workspace, rel))
+			if !isSubPath(resolved, base) || resolved == base {
+				return nil
+			}
+			if _, err := os.Stat(resolved); err != nil {
+				return nil
+			}
+			if rel, err = filepath.Rel(base, resolved); err != nil {
+				return err
+			}
+		}
+		if !seen[rel] {
+			seen[rel] = true
+			masked = append(masked, rel)
+		}
+		if d.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	return masked, err
+}
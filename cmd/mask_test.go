@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseMaskPattern tests validation of mask and unmask patterns
+func TestParseMaskPattern(t *testing.T) {
+	for _, pattern := range []string{".env*", "secrets/**", "*.pem", "config/", "**/prod/*.json"} {
+		_, err := parseMaskPattern(pattern)
+		assert.NoError(t, err, pattern)
+	}
+	for _, pattern := range []string{"/etc/shadow", "../x", "a//b", "./x", "[", "a/[/b"} {
+		_, err := parseMaskPattern(pattern)
+		assert.Error(t, err, pattern)
+	}
+}
+
+// TestMatchMask tests gitignore-style matching of workspace paths
+func TestMatchMask(t *testing.T) {
+	tests := []struct {
+		pattern string
+		rel     string
+		isDir   bool
+		want    bool
+	}{
+		{".env*", ".env", false, true},
+		{".env*", "services/api/.env.local", false, true},
+		{"*.pem", "certs/server.pem", false, true},
+		{"*.pem", "certs", true, false},
+		{"secrets/**", "secrets", true, true},
+		{"secrets/**", "secrets/db/password", false, true},
+		{"secrets/**", "app/secrets", true, false},
+		{"**/secrets", "app/secrets", true, true},
+		{"**/secrets", "secrets", true, true},
+		{"config/*.json", "config/prod.json", false, true},
+		{"config/*.json", "config/prod/x.json", false, false},
+		{"config/**/*.json", "config/prod/x.json", false, true},
+		{"private/", "private", true, true},
+		{"private/", "private", false, false},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, matchMask(tt.pattern, tt.rel, tt.isDir), "%s against %s", tt.pattern, tt.rel)
+	}
+}
+
+// TestFindMasked tests which workspace paths are masked
+func TestFindMasked(t *testing.T) {
+	root := t.TempDir()
+	workspace := filepath.Join(root, "project")
+	writeFiles(t, workspace, map[string]string{
+		".env":               "SECRET=1",
+		".env.example":       "SECRET=",
+		"src/main.go":        "package main",
+		"certs/server.pem":   "key",
+		"secrets/db/pass":    "pw",
+		"secrets/api/token":  "tk",
+		"config/prod.values": "x",
+	})
+	require.NoError(t, os.Symlink("config/prod.values", filepath.Join(workspace, ".env.prod")))
+	require.NoError(t, os.Symlink("/etc/hosts", filepath.Join(workspace, ".env.host")))
+
+	masked, err := findMasked(root, "/project", append(defaultMasks(), "secrets/**"), defaultUnmasks(), nil, nil)
+
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{".env", "config/prod.values", "certs/server.pem", "secrets"}, masked)
+
+	// Locked patterns from the machine policy ignore unmask
+	masked, err = findMasked(root, "/project", defaultMasks(), []string{"*.pem", ".env.example"}, []string{"*.pem", "src/"}, nil)
+
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{".env", "config/prod.values", "certs/server.pem", "src"}, masked)
+
+	// Skipped directories are not walked, but are masked themselves if they match
+	masked, err = findMasked(root, "/project", append(defaultMasks(), "secrets/**"), defaultUnmasks(), nil, []string{"certs", "secrets"})
+
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{".env", "config/prod.values", "secrets"}, masked)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// reservedTargets are jail paths managed by jail itself that mounts may not cover
+var reservedTargets = []string{"/proc", "/dev", "/workspace"}
+
+// mountEntry describes a single bind mount into the jail and the flags it is mounted with
+type mountEntry struct {
+	source   string
+	target   string
+	readOnly bool
+	noExec   bool
+	noSuid   bool
+	noDev    bool
+}
+
+// parseMountEntry parses a single .jail line of the form "<path> [options]" or
+// "<host path>:<jail path>[:options]" where options is a comma-separated list
+// such as "rw" or "ro,noexec,nosuid". Entries are mounted at the same path
+// inside the jail unless a jail path is given, and are read-only unless "rw" is given.
+// Paths may contain spaces: the last field is only taken as options if it
+// names a known option, and then all of its options must be valid.
+func parseMountEntry(line string) (mountEntry, error) {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return mountEntry{}, fmt.Errorf("empty mount entry")
+	}
+
+	last := fields[len(fields)-1]
+	if len(fields) > 1 && isOptionList(last) {
+		return parseMountSpec(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), last)), last)
+	}
+	return parseMountSpec(strings.TrimSpace(line), "")
+}
+
+// isOptionList reports whether field is meant as mount options: a
+// comma-separated list with at least one known option
+func isOptionList(field string) bool {
+	for _, opt := range strings.Split(field, ",") {
+		if (&mountEntry{}).applyOptions(opt) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMountSpec parses "<host path>[:<jail path>[:options]]" followed by the
+// options given after whitespace, if any
+func parseMountSpec(spec, options string) (mountEntry, error) {
+	parts := strings.SplitN(spec, ":", 3)
+	entry := mountEntry{source: parts[0], target: parts[0], readOnly: true}
+	if entry.source == "" {
+		return mountEntry{}, fmt.Errorf("missing host path in %q", spec)
+	}
+
+	if len(parts) > 1 {
+		if parts[1] == "" {
+			return mountEntry{}, fmt.Errorf("missing jail path in %q", spec)
+		}
+		entry.target = parts[1]
+	}
+
+	hasOptions := options != ""
+	if len(parts) == 3 {
+		if hasOptions {
+			return mountEntry{}, fmt.Errorf("mount options given twice in %q", spec+" "+options)
+		}
+		options, hasOptions = parts[2], true
+	}
+	if hasOptions {
+		if err := entry.applyOptions(options); err != nil {
+			return mountEntry{}, err
+		}
+	}
+
+	return entry, nil
+}
+
+// applyOptions sets the entry flags from a comma-separated option list
+func (m *mountEntry) applyOptions(options string) error {
+	for _, opt := range strings.Split(options, ",") {
+		switch opt {
+		case "ro":
+			m.readOnly = true
+		case "rw":
+			m.readOnly = false
+		case "noexec":
+			m.noExec = true
+		case "nosuid":
+			m.noSuid = true
+		case "nodev":
+			m.noDev = true
+		default:
+			return fmt.Errorf("unknown mount option %q", opt)
+		}
+	}
+	return nil
+}
+
+// String returns the mount as a .jail line, e.g. "/src:/dst rw,noexec"
+func (m mountEntry) String() string {
+	text := m.source
+	if target := filepath.Clean(m.target); target != m.source {
+		text += ":" + target
+	}
+	return text + " " + strings.Join(m.options(), ",")
+}
+
+// options returns the entry's mount options in .jail syntax, e.g. ["ro", "noexec"]
+func (m mountEntry) options() []string {
+	options := []string{"rw"}
+	if m.readOnly {
+		options[0] = "ro"
+	}
+	for _, o := range []struct {
+		set  bool
+		name string
+	}{{m.noExec, "noexec"}, {m.noSuid, "nosuid"}, {m.noDev, "nodev"}} {
+		if o.set {
+			options = append(options, o.name)
+		}
+	}
+	return options
+}
+
+// validateMounts checks that every mount target is an absolute path outside the
+// paths jail manages itself, and that no two mounts with different sources share
+// a target. Entries repeating an earlier source and target replace it, so a
+// workspace .jail can change the options of a global entry. It returns the
+// deduplicated list in its original order.
+func validateMounts(mounts []mountEntry) ([]mountEntry, error) {
+	result := make([]mountEntry, 0, len(mounts))
+	byTarget := make(map[string]int, len(mounts))
+
+	for _, m := range mounts {
+		if !filepath.IsAbs(m.target) {
+			return nil, fmt.Errorf("mount target %s for %s is not an absolute path", m.target, m.source)
+		}
+		m.target = filepath.Clean(m.target)
+
+		if m.target == "/" {
+			return nil, fmt.Errorf("mount target for %s cannot be /", m.source)
+		}
+		for _, reserved := range reservedTargets {
+			if isSubPath(m.target, reserved) {
+				return nil, fmt.Errorf("mount target %s for %s collides with reserved path %s", m.target, m.source, reserved)
+			}
+		}
+
+		if i, ok := byTarget[m.target]; ok {
+			if result[i].source != m.source {
+				return nil, fmt.Errorf("mount target %s is used by both %s and %s", m.target, result[i].source, m.source)
+			}
+			result[i] = m
+			continue
+		}
+
+		byTarget[m.target] = len(result)
+		result = append(result, m)
+	}
+
+	return result, nil
+}
+
+// isSubPath reports whether path equals dir or lies beneath it
+func isSubPath(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
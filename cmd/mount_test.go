@@ -0,0 +1,194 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseMountEntry tests parsing of individual .jail mount lines
+func TestParseMountEntry(t *testing.T) {
+	t.Run("path without options is read-only", func(t *testing.T) {
+		entry, err := parseMountEntry("/opt/tools")
+
+		require.NoError(t, err)
+		assert.Equal(t, mountEntry{source: "/opt/tools", target: "/opt/tools", readOnly: true}, entry)
+	})
+
+	t.Run("remapped target", func(t *testing.T) {
+		entry, err := parseMountEntry("/home/me/toolchains/go1.25:/opt/toolchain")
+
+		require.NoError(t, err)
+		assert.Equal(t, mountEntry{source: "/home/me/toolchains/go1.25", target: "/opt/toolchain", readOnly: true}, entry)
+	})
+
+	t.Run("remapped target with options", func(t *testing.T) {
+		entry, err := parseMountEntry("/home/me/fixtures:/data:rw,noexec")
+
+		require.NoError(t, err)
+		assert.Equal(t, "/data", entry.target)
+		assert.False(t, entry.readOnly)
+		assert.True(t, entry.noExec)
+	})
+
+	t.Run("remapped target with whitespace options", func(t *testing.T) {
+		entry, err := parseMountEntry("/home/me/fixtures:/data rw")
+
+		require.NoError(t, err)
+		assert.Equal(t, "/data", entry.target)
+		assert.False(t, entry.readOnly)
+	})
+
+	t.Run("options given twice", func(t *testing.T) {
+		_, err := parseMountEntry("/home/me/fixtures:/data:ro rw")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "given twice")
+	})
+
+	t.Run("empty jail path", func(t *testing.T) {
+		_, err := parseMountEntry("/home/me/fixtures:")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "missing jail path")
+	})
+
+	t.Run("rw option makes entry writable", func(t *testing.T) {
+		entry, err := parseMountEntry("/home/me/.npm rw")
+
+		require.NoError(t, err)
+		assert.Equal(t, "/home/me/.npm", entry.source)
+		assert.False(t, entry.readOnly)
+	})
+
+	t.Run("combined options", func(t *testing.T) {
+		entry, err := parseMountEntry("/opt/data ro,noexec,nosuid,nodev")
+
+		require.NoError(t, err)
+		assert.True(t, entry.readOnly)
+		assert.True(t, entry.noExec)
+		assert.True(t, entry.noSuid)
+		assert.True(t, entry.noDev)
+	})
+
+	t.Run("later options override earlier ones", func(t *testing.T) {
+		entry, err := parseMountEntry("/opt/data ro,rw")
+
+		require.NoError(t, err)
+		assert.False(t, entry.readOnly)
+	})
+
+	t.Run("unknown option", func(t *testing.T) {
+		_, err := parseMountEntry("/opt/data rw,exec-please")
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown mount option")
+	})
+
+	t.Run("words after options belong to the path", func(t *testing.T) {
+		entry, err := parseMountEntry("/opt/data rw extra")
+
+		require.NoError(t, err)
+		assert.Equal(t, mountEntry{source: "/opt/data rw extra", target: "/opt/data rw extra", readOnly: true}, entry)
+	})
+
+	t.Run("existing path with a space", func(t *testing.T) {
+		dir := filepath.Join(t.TempDir(), "my dir")
+		require.NoError(t, os.Mkdir(dir, 0755))
+
+		entry, err := parseMountEntry(dir)
+
+		require.NoError(t, err)
+		assert.Equal(t, mountEntry{source: dir, target: dir, readOnly: true}, entry)
+	})
+
+	t.Run("path with a space and options", func(t *testing.T) {
+		entry, err := parseMountEntry("/opt/my dir:/data rw,noexec")
+
+		require.NoError(t, err)
+		assert.Equal(t, mountEntry{source: "/opt/my dir", target: "/data", noExec: true}, entry)
+	})
+
+	t.Run("missing path with a space", func(t *testing.T) {
+		entry, err := parseMountEntry("/opt/jail-test-missing dir")
+
+		require.NoError(t, err)
+		assert.Equal(t, mountEntry{source: "/opt/jail-test-missing dir", target: "/opt/jail-test-missing dir", readOnly: true}, entry)
+	})
+}
+
+// TestValidateMounts tests mount target validation
+func TestValidateMounts(t *testing.T) {
+	t.Run("valid mounts are returned unchanged", func(t *testing.T) {
+		mounts := []mountEntry{
+			{source: "/usr", target: "/usr", readOnly: true},
+			{source: "/home/me/go1.25", target: "/opt/toolchain", readOnly: true},
+		}
+
+		result, err := validateMounts(mounts)
+
+		require.NoError(t, err)
+		assert.Equal(t, mounts, result)
+	})
+
+	t.Run("target is cleaned", func(t *testing.T) {
+		result, err := validateMounts([]mountEntry{{source: "/src", target: "/data/"}})
+
+		require.NoError(t, err)
+		assert.Equal(t, "/data", result[0].target)
+	})
+
+	t.Run("relative target", func(t *testing.T) {
+		_, err := validateMounts([]mountEntry{{source: "/src", target: "data"}})
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "not an absolute path")
+	})
+
+	t.Run("root target", func(t *testing.T) {
+		_, err := validateMounts([]mountEntry{{source: "/src", target: "/"}})
+
+		assert.Error(t, err)
+	})
+
+	for _, target := range []string{"/proc", "/proc/sys", "/dev", "/dev/shm", "/workspace", "/workspace/project"} {
+		t.Run("reserved target "+target, func(t *testing.T) {
+			_, err := validateMounts([]mountEntry{{source: "/src", target: target}})
+
+			assert.Error(t, err)
+			assert.Contains(t, err.Error(), "reserved path")
+		})
+	}
+
+	t.Run("similar prefix is not reserved", func(t *testing.T) {
+		_, err := validateMounts([]mountEntry{{source: "/src", target: "/devtools"}})
+
+		assert.NoError(t, err)
+	})
+
+	t.Run("different sources on the same target", func(t *testing.T) {
+		_, err := validateMounts([]mountEntry{
+			{source: "/a", target: "/data"},
+			{source: "/b", target: "/data"},
+		})
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "used by both /a and /b")
+	})
+
+	t.Run("repeated entry overrides earlier options", func(t *testing.T) {
+		result, err := validateMounts([]mountEntry{
+			{source: "/a", target: "/a", readOnly: true},
+			{source: "/b", target: "/b", readOnly: true},
+			{source: "/a", target: "/a"},
+		})
+
+		require.NoError(t, err)
+		require.Len(t, result, 2)
+		assert.Equal(t, mountEntry{source: "/a", target: "/a"}, result[0])
+		assert.Equal(t, "/b", result[1].source)
+	})
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Network modes selectable with --net or the "net" .jail directive
+const (
+	netModeHost  = "host"  // share the host network stack
+	netModeNone  = "none"  // private network namespace with only loopback
+	netModeProxy = "proxy" // private network namespace reaching allowed hosts through the egress proxy
+)
+
+// netModes lists the valid network modes
+var netModes = []string{netModeHost, netModeNone, netModeProxy}
+
+// netEnvVar exposes the active network mode to the jailed process
+const netEnvVar = "JAIL_NET"
+
+// parseNetMode validates a network mode name
+func parseNetMode(mode string) (string, error) {
+	for _, m := range netModes {
+		if mode == m {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown network mode %q (expected one of: %s)", mode, strings.Join(netModes, ", "))
+}
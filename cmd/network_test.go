@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseNetMode tests network mode validation
+func TestParseNetMode(t *testing.T) {
+	for _, mode := range []string{"host", "none", "proxy"} {
+		t.Run("valid mode "+mode, func(t *testing.T) {
+			result, err := parseNetMode(mode)
+
+			require.NoError(t, err)
+			assert.Equal(t, mode, result)
+		})
+	}
+
+	t.Run("unknown mode", func(t *testing.T) {
+		result, err := parseNetMode("bridge")
+
+		assert.Error(t, err)
+		assert.Empty(t, result)
+		assert.Contains(t, err.Error(), "host, none, proxy")
+	})
+}
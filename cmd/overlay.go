@@ -0,0 +1,388 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// overlayOpaqueXattr marks an upper directory that replaces the lower one entirely
+const overlayOpaqueXattr = "user.overlay.opaque"
+
+// overlaySession holds the upper layer of an --overlay run until it is applied
+// to the workspace or discarded
+type overlaySession struct {
+	ID        string    `json:"-"`
+	Workspace string    `json:"workspace"`
+	Created   time.Time `json:"created"`
+	dir       string
+}
+
+// overlayChange is one entry of the summary of an overlay session
+type overlayChange struct {
+	kind byte // 'A'dded, 'M'odified or 'D'eleted
+	path string
+}
+
+// String formats a change like "M src/main.go"
+func (c overlayChange) String() string {
+	return string(c.kind) + " " + c.path
+}
+
+// overlaySessionsDir returns the directory holding all overlay sessions
+func overlaySessionsDir() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "sessions"), nil
+}
+
+// newOverlaySession creates the upper and work directories for an overlay of workspace
+func newOverlaySession(workspace string) (*overlaySession, error) {
+	sessionsDir, err := overlaySessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	id, err := newStateID()
+	if err != nil {
+		return nil, fmt.Errorf("creating session id: %w", err)
+	}
+
+	s := &overlaySession{ID: id, Workspace: workspace, Created: time.Now(), dir: filepath.Join(sessionsDir, id)}
+	for _, dir := range []string{s.upperDir(), s.workDir()} {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("creating overlay session: %w", err)
+		}
+	}
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(filepath.Join(s.dir, "session.json"), data, 0600); err != nil {
+		return nil, fmt.Errorf("writing overlay session: %w", err)
+	}
+
+	return s, nil
+}
+
+// loadOverlaySession reads an existing session by id
+func loadOverlaySession(id string) (*overlaySession, error) {
+	sessionsDir, err := overlaySessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	if id == "" || strings.ContainsAny(id, "/.") {
+		return nil, fmt.Errorf("invalid session %q", id)
+	}
+
+	s := &overlaySession{ID: id, dir: filepath.Join(sessionsDir, id)}
+	data, err := os.ReadFile(filepath.Join(s.dir, "session.json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no overlay session %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("reading overlay session %s: %w", id, err)
+	}
+
+	return s, nil
+}
+
+// listOverlaySessions returns the ids of all pending sessions, oldest first
+func listOverlaySessions() ([]string, error) {
+	sessionsDir, err := overlaySessionsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(sessionsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			ids = append(ids, e.Name())
+		}
+	}
+	return ids, nil
+}
+
+func (s *overlaySession) upperDir() string { return filepath.Join(s.dir, "upper") }
+func (s *overlaySession) workDir() string  { return filepath.Join(s.dir, "work") }
+
+// isWhiteout reports whether an upper layer entry records a deletion
+func isWhiteout(info os.FileInfo) bool {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	return ok && info.Mode()&os.ModeCharDevice != 0 && st.Rdev == 0
+}
+
+// isOpaque reports whether an upper layer directory hides the lower directory's contents
+func isOpaque(path string) bool {
+	value := make([]byte, 1)
+	n, err := syscall.Getxattr(path, overlayOpaqueXattr, value)
+	return err == nil && n == 1 && value[0] == 'y'
+}
+
+// changes compares the upper layer with the workspace and lists what the
+// jailed process added, modified and deleted
+func (s *overlaySession) changes() ([]overlayChange, error) {
+	var changes []overlayChange
+	err := walkUpper(s.upperDir(), s.Workspace, "", &changes)
+	sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	return changes, err
+}
+
+// walkUpper collects the changes below the relative directory rel
+func walkUpper(upper, lower, rel string, changes *[]overlayChange) error {
+	entries, err := os.ReadDir(filepath.Join(upper, rel))
+	if err != nil {
+		return err
+	}
+
+	// An opaque directory was deleted and recreated: lower entries it does not contain are gone
+	if rel != "" && isOpaque(filepath.Join(upper, rel)) {
+		lowerEntries, _ := os.ReadDir(filepath.Join(lower, rel))
+		for _, le := range lowerEntries {
+			if _, err := os.Lstat(filepath.Join(upper, rel, le.Name())); os.IsNotExist(err) {
+				*changes = append(*changes, overlayChange{'D', displayPath(filepath.Join(rel, le.Name()), le.IsDir())})
+			}
+		}
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(rel, e.Name())
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+		lowerInfo, lowerErr := os.Lstat(filepath.Join(lower, path))
+		inLower := lowerErr == nil
+
+		switch {
+		case isWhiteout(info):
+			if inLower {
+				*changes = append(*changes, overlayChange{'D', displayPath(path, lowerInfo.IsDir())})
+			}
+		case info.IsDir():
+			before := len(*changes)
+			if err := walkUpper(upper, lower, path, changes); err != nil {
+				return err
+			}
+			if !inLower && len(*changes) == before {
+				*changes = append(*changes, overlayChange{'A', displayPath(path, true)})
+			}
+		case inLower:
+			*changes = append(*changes, overlayChange{'M', path})
+		default:
+			*changes = append(*changes, overlayChange{'A', path})
+		}
+	}
+
+	return nil
+}
+
+// displayPath marks directories with a trailing slash
+func displayPath(path string, isDir bool) string {
+	if isDir {
+		return path + "/"
+	}
+	return path
+}
+
+// apply writes the upper layer onto the workspace and removes the session
+func (s *overlaySession) apply() error {
+	if err := applyUpper(s.upperDir(), s.Workspace, ""); err != nil {
+		return fmt.Errorf("applying session %s: %w", s.ID, err)
+	}
+	return s.discard()
+}
+
+// applyUpper replays the upper layer entries below rel onto lower
+func applyUpper(upper, lower, rel string) error {
+	entries, err := os.ReadDir(filepath.Join(upper, rel))
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		path := filepath.Join(rel, e.Name())
+		src, dst := filepath.Join(upper, path), filepath.Join(lower, path)
+		info, err := e.Info()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case isWhiteout(info):
+			err = os.RemoveAll(dst)
+		case info.IsDir():
+			err = applyDir(src, dst, info)
+			if err == nil {
+				err = applyUpper(upper, lower, path)
+			}
+		case info.Mode()&os.ModeSymlink != 0:
+			var target string
+			if target, err = os.Readlink(src); err == nil {
+				err = replaceWithSymlink(target, dst)
+			}
+		case info.Mode().IsRegular():
+			err = replaceWithFile(src, dst, info.Mode().Perm())
+		default:
+			// Sockets and fifos are not worth carrying over
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// applyDir makes dst a directory matching the upper directory src
+func applyDir(src, dst string, info os.FileInfo) error {
+	existing, err := os.Lstat(dst)
+	if err == nil && (!existing.IsDir() || isOpaque(src)) {
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+	}
+	if err := os.Mkdir(dst, info.Mode().Perm()); err != nil && !os.IsExist(err) {
+		return err
+	}
+	return os.Chmod(dst, info.Mode().Perm())
+}
+
+// replaceWithSymlink replaces dst with a symlink to target
+func replaceWithSymlink(target, dst string) error {
+	if err := os.RemoveAll(dst); err != nil {
+		return err
+	}
+	return os.Symlink(target, dst)
+}
+
+// replaceWithFile replaces dst with a copy of the file src with permissions perm
+func replaceWithFile(src, dst string, perm os.FileMode) error {
+	if existing, err := os.Lstat(dst); err == nil && existing.IsDir() {
+		if err := os.RemoveAll(dst); err != nil {
+			return err
+		}
+	}
+
+	in, err := os.Open(src) //nolint:gosec // Path comes from jail's own state directory
+	if err != nil {
+		return err
+	}
+	defer func() { _ = in.Close() }()
+
+	// Write next to the destination and rename, so a failed copy leaves dst intact
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".jail-apply-*")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	if _, err := io.Copy(tmp, in); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dst)
+}
+
+// discard deletes the session and its upper layer
+func (s *overlaySession) discard() error {
+	// overlayfs leaves inaccessible directories in workdir
+	_ = filepath.WalkDir(s.dir, func(path string, d os.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			_ = os.Chmod(path, 0700) //nolint:gosec,mnd // owner needs access to remove the contents
+		}
+		return nil
+	})
+	if err := os.RemoveAll(s.dir); err != nil {
+		return fmt.Errorf("removing session %s: %w", s.ID, err)
+	}
+	return nil
+}
+
+// reportOverlaySession prints what a finished --overlay run changed and how to
+// apply or discard it. Sessions without changes are discarded right away.
+func reportOverlaySession(w io.Writer, s *overlaySession) error {
+	changes, err := s.changes()
+	if err != nil {
+		return fmt.Errorf("summarizing overlay session %s: %w", s.ID, err)
+	}
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintf(w, "jail: no changes to %s\n", s.Workspace)
+		return s.discard()
+	}
+
+	_, _ = fmt.Fprintf(w, "jail: overlay session %s changed %d path(s) in %s:\n", s.ID, len(changes), s.Workspace)
+	for _, c := range changes {
+		_, _ = fmt.Fprintf(w, "  %s\n", c)
+	}
+	_, err = fmt.Fprintf(w, "Run 'jail apply %s' to write the changes or 'jail discard %s' to drop them.\n", s.ID, s.ID)
+	return err
+}
+
+// runOverlayCommand implements "jail apply <session>" and "jail discard <session>".
+// Without a session it lists the pending ones.
+func runOverlayCommand(w io.Writer, command string, args []string) error {
+	if len(args) != 1 {
+		ids, err := listOverlaySessions()
+		if err != nil {
+			return err
+		}
+		if len(ids) == 0 {
+			return errors.New("no pending overlay sessions")
+		}
+		return fmt.Errorf("usage: jail %s <session>\npending sessions:\n  %s", command, strings.Join(ids, "\n  "))
+	}
+
+	s, err := loadOverlaySession(args[0])
+	if err != nil {
+		return err
+	}
+
+	if command == "discard" {
+		if err := s.discard(); err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "discarded session %s\n", s.ID)
+		return err
+	}
+
+	changes, err := s.changes()
+	if err != nil {
+		return err
+	}
+	if err := s.apply(); err != nil {
+		return err
+	}
+	for _, c := range changes {
+		if _, err := fmt.Fprintf(w, "%s\n", c); err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "applied %d change(s) to %s\n", len(changes), s.Workspace)
+	return err
+}
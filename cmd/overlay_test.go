@@ -0,0 +1,173 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testOverlaySession creates a session for a fresh workspace in a temporary state directory
+func testOverlaySession(t *testing.T) *overlaySession {
+	t.Helper()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	workspace := t.TempDir()
+
+	s, err := newOverlaySession(workspace)
+	require.NoError(t, err)
+	return s
+}
+
+// writeFiles creates files with the given contents below dir
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	}
+}
+
+// whiteout records the deletion of name in an upper layer like overlayfs does
+func whiteout(t *testing.T, upper, name string) {
+	t.Helper()
+	if err := syscall.Mknod(filepath.Join(upper, name), syscall.S_IFCHR, 0); err != nil {
+		t.Skipf("cannot create whiteout device: %v", err)
+	}
+}
+
+// TestOverlaySessionLifecycle tests creating, finding and discarding sessions
+func TestOverlaySessionLifecycle(t *testing.T) {
+	t.Run("sessions live in the state directory", func(t *testing.T) {
+		s := testOverlaySession(t)
+
+		loaded, err := loadOverlaySession(s.ID)
+
+		require.NoError(t, err)
+		assert.Equal(t, s.Workspace, loaded.Workspace)
+		assert.Equal(t, s.upperDir(), loaded.upperDir())
+		ids, err := listOverlaySessions()
+		require.NoError(t, err)
+		assert.Equal(t, []string{s.ID}, ids)
+	})
+
+	t.Run("rejects unknown and malformed session ids", func(t *testing.T) {
+		t.Setenv("XDG_STATE_HOME", t.TempDir())
+
+		_, err := loadOverlaySession("nope")
+		assert.ErrorContains(t, err, "no overlay session nope")
+		_, err = loadOverlaySession("../sessions")
+		assert.ErrorContains(t, err, "invalid session")
+	})
+
+	t.Run("discard removes inaccessible work directories", func(t *testing.T) {
+		s := testOverlaySession(t)
+		require.NoError(t, os.Mkdir(filepath.Join(s.workDir(), "work"), 0))
+
+		err := s.discard()
+
+		require.NoError(t, err)
+		assert.NoDirExists(t, s.dir)
+	})
+}
+
+// TestOverlayChanges tests the summary of an upper layer against the workspace
+func TestOverlayChanges(t *testing.T) {
+	t.Run("added and modified files", func(t *testing.T) {
+		s := testOverlaySession(t)
+		writeFiles(t, s.Workspace, map[string]string{"main.go": "old", "lib/util.go": "old"})
+		writeFiles(t, s.upperDir(), map[string]string{"main.go": "new", "lib/new.go": "new"})
+		require.NoError(t, os.Mkdir(filepath.Join(s.upperDir(), "empty"), 0755))
+
+		changes, err := s.changes()
+
+		require.NoError(t, err)
+		assert.Equal(t, []overlayChange{{'A', "empty/"}, {'A', "lib/new.go"}, {'M', "main.go"}}, changes)
+	})
+
+	t.Run("whiteouts are deletions", func(t *testing.T) {
+		s := testOverlaySession(t)
+		writeFiles(t, s.Workspace, map[string]string{"gone.txt": "x", "dir/file": "x"})
+		whiteout(t, s.upperDir(), "gone.txt")
+		whiteout(t, s.upperDir(), "dir")
+
+		changes, err := s.changes()
+
+		require.NoError(t, err)
+		assert.Equal(t, []overlayChange{{'D', "dir/"}, {'D', "gone.txt"}}, changes)
+	})
+
+	t.Run("no changes", func(t *testing.T) {
+		s := testOverlaySession(t)
+		writeFiles(t, s.Workspace, map[string]string{"main.go": "old"})
+
+		var out bytes.Buffer
+		err := reportOverlaySession(&out, s)
+
+		require.NoError(t, err)
+		assert.Contains(t, out.String(), "no changes")
+		assert.NoDirExists(t, s.dir)
+	})
+}
+
+// TestOverlayApply tests writing an upper layer back to the workspace
+func TestOverlayApply(t *testing.T) {
+	s := testOverlaySession(t)
+	writeFiles(t, s.Workspace, map[string]string{"keep": "keep", "mod": "old", "gone": "x", "dir/file": "x"})
+	writeFiles(t, s.upperDir(), map[string]string{"mod": "new", "sub/added": "added"})
+	require.NoError(t, os.Chmod(filepath.Join(s.upperDir(), "mod"), 0755))
+	require.NoError(t, os.Symlink("keep", filepath.Join(s.upperDir(), "link")))
+	whiteout(t, s.upperDir(), "gone")
+	whiteout(t, s.upperDir(), "dir")
+
+	var out bytes.Buffer
+	err := runOverlayCommand(&out, "apply", []string{s.ID})
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "applied 5 change(s)")
+	content, err := os.ReadFile(filepath.Join(s.Workspace, "mod"))
+	require.NoError(t, err)
+	assert.Equal(t, "new", string(content))
+	info, err := os.Stat(filepath.Join(s.Workspace, "mod"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+	assert.FileExists(t, filepath.Join(s.Workspace, "sub", "added"))
+	target, err := os.Readlink(filepath.Join(s.Workspace, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "keep", target)
+	assert.NoFileExists(t, filepath.Join(s.Workspace, "gone"))
+	assert.NoDirExists(t, filepath.Join(s.Workspace, "dir"))
+	assert.FileExists(t, filepath.Join(s.Workspace, "keep"))
+	assert.NoDirExists(t, s.dir)
+}
+
+// TestRunOverlayCommand tests the apply and discard subcommands
+func TestRunOverlayCommand(t *testing.T) {
+	t.Run("lists pending sessions without an argument", func(t *testing.T) {
+		s := testOverlaySession(t)
+
+		err := runOverlayCommand(&bytes.Buffer{}, "apply", nil)
+
+		assert.ErrorContains(t, err, s.ID)
+	})
+
+	t.Run("discard leaves the workspace alone", func(t *testing.T) {
+		s := testOverlaySession(t)
+		writeFiles(t, s.Workspace, map[string]string{"main.go": "old"})
+		writeFiles(t, s.upperDir(), map[string]string{"main.go": "new"})
+
+		var out bytes.Buffer
+		err := runOverlayCommand(&out, "discard", []string{s.ID})
+
+		require.NoError(t, err)
+		assert.Equal(t, "discarded session "+s.ID+"\n", out.String())
+		content, err := os.ReadFile(filepath.Join(s.Workspace, "main.go"))
+		require.NoError(t, err)
+		assert.Equal(t, "old", string(content))
+		assert.NoDirExists(t, s.dir)
+	})
+}
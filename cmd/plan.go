@@ -0,0 +1,336 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/wfaler/jail/jail"
+)
+
+// Output formats of --dry-run
+const (
+	planOutputText = "text"
+	planOutputJSON = "json"
+)
+
+// jailPlan is everything a run sets up: the jail.Spec that is run and the
+// settings it was computed from. It is computed from the arguments, the
+// config and the host filesystem without changing anything, so that
+// --dry-run can show it.
+type jailPlan struct {
+	*jail.Spec
+	Network string   `json:"network"`
+	Allow   []string `json:"allow,omitempty"`
+	Profile string   `json:"profile,omitempty"`
+}
+
+// planNamespaces returns the namespaces a run with cfg creates
+func planNamespaces(cfg *jailConfig) []string {
+	namespaces := []string{"mount", "user", "pid", "uts", "ipc"}
+	if cfg.netMode != netModeHost {
+		namespaces = append(namespaces, "net")
+	}
+	return namespaces
+}
+
+// planJail computes the plan of a run. The workspace of an --overlay run
+// gets its upper layer once the session exists, see setOverlaySession.
+//
+//nolint:gocognit,gocyclo // One step per part of the jail
+func planJail(args *jailArgs, cfg *jailConfig) (*jailPlan, error) {
+	jailDir, err := filepath.Abs(args.jailDir)
+	if err != nil {
+		return nil, fmt.Errorf("getting absolute path: %w", err)
+	}
+	p := &jailPlan{
+		Spec: &jail.Spec{
+			Namespaces: planNamespaces(cfg),
+			UIDMap:     jail.IDMap{Container: 0, Host: os.Getuid(), Size: 1},
+			GIDMap:     jail.IDMap{Container: 0, Host: os.Getgid(), Size: 1},
+			Seccomp:    cfg.seccomp,
+			Limits:     cfg.limits,
+			Init:       !args.noInit,
+		},
+		Network: cfg.netMode,
+		Profile: cfg.activeProfile,
+	}
+	if cfg.netMode == netModeProxy {
+		for _, rule := range cfg.allow {
+			p.Allow = append(p.Allow, rule.String())
+		}
+		p.Proxy = newEgressProxy(cfg.allow, os.Stderr)
+	}
+	origin := func(text string) string {
+		o, _ := cfg.originOf(text)
+		return o.String()
+	}
+
+	// Scratch directories and host paths, including those below
+	var host []jail.Mount
+	for _, e := range cfg.tmpfs {
+		host = append(host, jail.Mount{Type: jail.MountTmpfs, Target: e.target, Options: []string{"size=" + e.size}, Origin: origin(e.String())})
+	}
+	for _, m := range cfg.mounts {
+		host = append(host, planBindMount(m, origin(m.String())))
+	}
+
+	// State of the selected applications, such as ~/.claude. HOME stays the host's home directory.
+	hostHome := os.Getenv("HOME")
+	apps, err := planApps(hostHome, cfg.appDefs, cfg.activeApps)
+	if err != nil {
+		return nil, err
+	}
+	host = append(host, apps...)
+
+	// Runtime data, needed by some tools like Claude
+	if xdgRuntimeDir := os.Getenv("XDG_RUNTIME_DIR"); xdgRuntimeDir != "" {
+		if _, err := os.Stat(xdgRuntimeDir); err == nil {
+			host = append(host, jail.Mount{Type: jail.MountBind, Source: xdgRuntimeDir, Target: xdgRuntimeDir, Options: []string{"rw"}, Origin: "XDG_RUNTIME_DIR"})
+		}
+	}
+
+	// Docker might not be installed or running, so its socket is optional
+	docker := jail.Mount{Type: jail.MountBind, Options: []string{"rw"}, File: true, Optional: true, Origin: "Docker socket"}
+	docker.Source, docker.Skipped = planDockerSocket(getDockerSocketPath())
+	docker.Target = docker.Source
+	host = append(host, docker)
+
+	// Outer paths first, so that a mount inside another one, such as a tmpfs
+	// for ~/.cache/x next to a bind mount of ~/.cache, is not hidden by it
+	slices.SortStableFunc(host, func(a, b jail.Mount) int {
+		return cmp.Compare(pathDepth(a.Target), pathDepth(b.Target))
+	})
+	p.Mounts = append(p.Mounts, host...)
+
+	// The workspace is mounted at /workspace/{basename} to keep the project's name
+	workspacePath := filepath.Join("/workspace", filepath.Base(jailDir))
+	workspace := mountEntry{source: jailDir, target: workspacePath, readOnly: args.readOnly}
+	if args.overlay {
+		p.Mounts = append(p.Mounts, jail.Mount{Type: jail.MountOverlay, Source: jailDir, Target: workspacePath, Origin: originWorkspace})
+	} else {
+		p.Mounts = append(p.Mounts, jail.Mount{Type: jail.MountBind, Source: jailDir, Target: workspacePath, Options: workspace.options(), Origin: originWorkspace})
+	}
+	p.Workspace = workspacePath
+
+	// With --ro only the configured subpaths stay writable, as nested read-write bind mounts
+	if args.readOnly {
+		for _, path := range cfg.writable {
+			rel, err := writableSource("/", jailDir, path)
+			if err != nil {
+				return nil, err
+			}
+			// A missing path is created as a directory
+			source := filepath.Join(jailDir, rel)
+			info, err := os.Stat(source)
+			p.Mounts = append(p.Mounts, jail.Mount{
+				Type:    jail.MountBind,
+				Source:  source,
+				Target:  filepath.Join(workspacePath, rel),
+				Options: []string{"rw"},
+				File:    err == nil && !info.IsDir(),
+				Create:  true,
+				Origin:  origin("writable " + path),
+			})
+		}
+	}
+
+	// Secrets such as .env files and private keys are hidden behind empty read-only mounts.
+	// Finding them skips .git and, unless the machine policy locks masks, which
+	// must apply everywhere, the directories left out of snapshots, such as
+	// node_modules.
+	skip := []string{".git"}
+	if len(cfg.lockedMasks) == 0 {
+		skip = append(skip, cfg.snapshotExclude...)
+	}
+	masked, err := findMasked("/", jailDir, cfg.masks, cfg.unmask, cfg.lockedMasks, skip)
+	if err != nil {
+		return nil, fmt.Errorf("finding masked paths: %w", err)
+	}
+	for _, rel := range masked {
+		info, err := os.Stat(filepath.Join(jailDir, rel))
+		if err != nil {
+			return nil, fmt.Errorf("masking %s: %w", rel, err)
+		}
+		p.Mounts = append(p.Mounts, jail.Mount{Type: jail.MountMask, Target: filepath.Join(workspacePath, rel), File: !info.IsDir(), Origin: "mask"})
+	}
+
+	p.Mounts = append(p.Mounts, jail.Mount{Type: jail.MountProc, Target: "/proc"})
+	if cfg.devMode == devModeHost {
+		p.Mounts = append(p.Mounts, jail.Mount{Type: jail.MountDev, Source: "/dev", Target: "/dev", Origin: origin("dev " + cfg.devMode)})
+	} else {
+		p.Mounts = append(p.Mounts, jail.Mount{Type: jail.MountDev, Target: "/dev", Origin: origin("dev " + cfg.devMode)})
+		for _, dev := range append(append([]string{}, defaultDevices...), cfg.devices...) {
+			if _, err := os.Stat(dev); err == nil {
+				p.Devices = append(p.Devices, dev)
+			}
+		}
+	}
+
+	// HOME is set so applications find their state, e.g. $HOME/.claude. Variables
+	// from the config come first so that jail's own settings win.
+	if hostHome != "" {
+		p.Env = setOrUpdateEnv(p.Env, "HOME", hostHome)
+	}
+	for _, name := range sortedEnv(cfg.env) {
+		p.Env = setOrUpdateEnv(p.Env, name, cfg.env[name])
+	}
+	p.Env = setOrUpdateEnv(p.Env, netEnvVar, cfg.netMode)
+	if cfg.activeProfile != "" {
+		p.Env = setOrUpdateEnv(p.Env, profileEnvVar, cfg.activeProfile)
+	}
+	if cfg.netMode == netModeProxy {
+		p.Env = setProxyEnv(p.Env)
+	}
+
+	p.Command, err = resolveCommand(args.cmdName, mountTargets(cfg.mounts), p.statInJail)
+	if err != nil {
+		return nil, fmt.Errorf("finding command %s: %w", args.cmdName, err)
+	}
+	p.Args = append([]string{args.cmdName}, args.cmdArgs...)
+
+	return p, nil
+}
+
+// pathDepth returns the number of elements of an absolute path
+func pathDepth(path string) int {
+	return strings.Count(filepath.Clean("/"+path), "/")
+}
+
+// planBindMount returns the bind mount of a config entry, skipped if the
+// source does not exist on this system. Relative sources are looked up from /.
+func planBindMount(m mountEntry, origin string) jail.Mount {
+	pm := jail.Mount{Type: jail.MountBind, Source: m.source, Target: m.target, Options: m.options(), Origin: origin}
+	info, err := os.Stat(filepath.Join("/", m.source))
+	if err != nil {
+		pm.Skipped = "does not exist"
+		return pm
+	}
+	pm.File = !info.IsDir()
+	return pm
+}
+
+// planDockerSocket checks the Docker socket found by getDockerSocketPath and
+// returns its path, or why it is not mounted
+func planDockerSocket(path string) (string, string) {
+	if path == "" {
+		return "", "docker socket not found"
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return path, fmt.Sprintf("docker socket at %s not accessible: %v", path, err)
+	}
+	if info.Mode()&os.ModeSocket == 0 {
+		return path, fmt.Sprintf("docker socket at %s is not a socket", path)
+	}
+	return path, ""
+}
+
+// setOverlaySession makes the overlay workspace of the plan write to the
+// upper layer of session
+func (p *jailPlan) setOverlaySession(session *overlaySession) {
+	for i := range p.Mounts {
+		if p.Mounts[i].Type == jail.MountOverlay {
+			p.Mounts[i].Options = []string{"upperdir=" + session.upperDir(), "workdir=" + session.workDir()}
+		}
+	}
+}
+
+// hostSource returns the host path a path inside the jail is reached at, or
+// false if no bind mount covers it
+func (p *jailPlan) hostSource(path string) (string, bool) {
+	var covering *jail.Mount
+	for i := range p.Mounts {
+		m := &p.Mounts[i]
+		if m.Skipped == "" && isSubPath(path, m.Target) && (covering == nil || len(m.Target) >= len(covering.Target)) {
+			covering = m
+		}
+	}
+	if covering == nil || (covering.Type != jail.MountBind && covering.Type != jail.MountOverlay && covering.Type != jail.MountDev) || covering.Source == "" {
+		return "", false
+	}
+	return filepath.Join("/", covering.Source, strings.TrimPrefix(path, covering.Target)), true
+}
+
+// statInJail returns the file info of a path as the jailed process will see it
+func (p *jailPlan) statInJail(path string) (os.FileInfo, error) {
+	source, ok := p.hostSource(path)
+	if !ok {
+		return nil, &os.PathError{Op: "stat", Path: path, Err: syscall.ENOENT}
+	}
+	return os.Stat(source)
+}
+
+// printPlan writes the plan as text, or as JSON if format is planOutputJSON
+func printPlan(w io.Writer, p *jailPlan, format string) error {
+	if format == planOutputJSON {
+		data, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return fmt.Errorf("encoding plan: %w", err)
+		}
+		_, err = fmt.Fprintln(w, string(data))
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	idMap := func(m jail.IDMap) string {
+		return fmt.Sprintf("%d -> %d (%d)", m.Host, m.Container, m.Size)
+	}
+	fmt.Fprintf(tw, "command:\t%s\n", p.Command)
+	fmt.Fprintf(tw, "args:\t%s\n", strings.Join(p.Args, " "))
+	fmt.Fprintf(tw, "workspace:\t%s\n", p.Workspace)
+	fmt.Fprintf(tw, "namespaces:\t%s\n", strings.Join(p.Namespaces, " "))
+	fmt.Fprintf(tw, "uid map:\t%s\n", idMap(p.UIDMap))
+	fmt.Fprintf(tw, "gid map:\t%s\n", idMap(p.GIDMap))
+	fmt.Fprintf(tw, "network:\t%s\n", p.Network)
+	if len(p.Allow) > 0 {
+		fmt.Fprintf(tw, "allow:\t%s\n", strings.Join(p.Allow, " "))
+	}
+	fmt.Fprintf(tw, "seccomp:\t%s\n", p.Seccomp)
+	fmt.Fprintf(tw, "init:\t%t\n", p.Init)
+	if p.Profile != "" {
+		fmt.Fprintf(tw, "profile:\t%s\n", p.Profile)
+	}
+	for _, name := range sortedKeys(p.Limits) {
+		fmt.Fprintf(tw, "resource:\t%s=%d\n", name, p.Limits[name])
+	}
+	for _, dev := range p.Devices {
+		fmt.Fprintf(tw, "device:\t%s\n", dev)
+	}
+	for _, e := range p.Env {
+		fmt.Fprintf(tw, "env:\t%s\n", e)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w, "\nmounts:")
+	tw = tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "  TYPE\tTARGET\tSOURCE\tOPTIONS\tORIGIN")
+	for _, m := range p.Mounts {
+		source, options := m.Source, strings.Join(m.Options, ",")
+		if source == "" {
+			source = "-"
+		}
+		if options == "" {
+			options = "-"
+		}
+		target := m.Target
+		if m.Skipped != "" {
+			target = fmt.Sprintf("%s (skipped: %s)", target, m.Skipped)
+		}
+		origin := m.Origin
+		if origin == "" {
+			origin = originBuiltin
+		}
+		fmt.Fprintf(tw, "  %s\t%s\t%s\t%s\t%s\n", m.Type, target, source, options, origin)
+	}
+	return tw.Flush()
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wfaler/jail/jail"
+)
+
+// findPlanMount returns the mount of p with the given target
+func findPlanMount(t *testing.T, p *jailPlan, target string) jail.Mount {
+	t.Helper()
+	for _, m := range p.Mounts {
+		if m.Target == target {
+			return m
+		}
+	}
+	t.Fatalf("no mount at %s", target)
+	return jail.Mount{}
+}
+
+// TestPlanJail tests computing the plan of a run from the arguments and config
+func TestPlanJail(t *testing.T) {
+	home := t.TempDir()
+	workspace := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_RUNTIME_DIR", "")
+	defer func(path string) { policyConfigPath = path }(policyConfigPath)
+	policyConfigPath = filepath.Join(t.TempDir(), "config")
+
+	local := filepath.Join(workspace, ".jail")
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, ".jail.yaml"), []byte("version: 1\nenv:\n  GOFLAGS: -mod=mod\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, ".env"), []byte("SECRET=1\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "notes.txt"), []byte("draft\n"), 0644))
+	require.NoError(t, os.WriteFile(local, []byte("net none\n/nonexistent/jail-test\nwritable build\nwritable notes.txt\n"), 0644))
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".config", "gh"), 0755))
+	workspacePath := filepath.Join("/workspace", filepath.Base(workspace))
+
+	plan := func(t *testing.T, args *jailArgs) *jailPlan {
+		t.Helper()
+		cfg, err := loadJailConfig(args)
+		require.NoError(t, err)
+		p, err := planJail(args, cfg)
+		require.NoError(t, err)
+		return p
+	}
+
+	t.Run("namespaces, command and environment", func(t *testing.T) {
+		p := plan(t, &jailArgs{jailDir: workspace, cmdName: "sh", cmdArgs: []string{"-c", "true"}})
+
+		assert.Equal(t, []string{"mount", "user", "pid", "uts", "ipc", "net"}, p.Namespaces)
+		assert.Equal(t, jail.IDMap{Container: 0, Host: os.Getuid(), Size: 1}, p.UIDMap)
+		assert.Equal(t, netModeNone, p.Network)
+		assert.Equal(t, "/bin/sh", p.Command)
+		assert.Equal(t, []string{"sh", "-c", "true"}, p.Args)
+		assert.Equal(t, workspacePath, p.Workspace)
+		assert.Equal(t, []string{"HOME=" + home, "GOFLAGS=-mod=mod", "JAIL_NET=none"}, p.Env)
+		assert.True(t, p.Init)
+	})
+
+	t.Run("without init", func(t *testing.T) {
+		p := plan(t, &jailArgs{jailDir: workspace, noInit: true, cmdName: "sh"})
+
+		assert.False(t, p.Init)
+	})
+
+	t.Run("mounts with their origin", func(t *testing.T) {
+		p := plan(t, &jailArgs{jailDir: workspace, cmdName: "sh"})
+
+		assert.Equal(t, jail.Mount{Type: jail.MountTmpfs, Target: "/tmp", Options: []string{"size=1g"}, Origin: originBuiltin}, findPlanMount(t, p, "/tmp"))
+		assert.Equal(t, jail.Mount{Type: jail.MountBind, Source: "/usr", Target: "/usr", Options: []string{"ro"}, Origin: originBuiltin}, findPlanMount(t, p, "/usr"))
+		assert.Equal(t, jail.Mount{Type: jail.MountBind, Source: workspace, Target: workspacePath, Options: []string{"rw"}, Origin: originWorkspace}, findPlanMount(t, p, workspacePath))
+
+		missing := findPlanMount(t, p, "/nonexistent/jail-test")
+		assert.Equal(t, "does not exist", missing.Skipped)
+		assert.Equal(t, "workspace "+local+":2", missing.Origin)
+
+		assert.Equal(t, jail.Mount{Type: jail.MountMask, Target: filepath.Join(workspacePath, ".env"), File: true, Origin: "mask"}, findPlanMount(t, p, filepath.Join(workspacePath, ".env")))
+		assert.Equal(t, jail.Mount{Type: jail.MountDev, Target: "/dev", Origin: originBuiltin}, p.Mounts[len(p.Mounts)-1])
+		assert.Contains(t, p.Devices, "/dev/null")
+	})
+
+	t.Run("masks skip .git and snapshot-excluded directories", func(t *testing.T) {
+		global := filepath.Join(home, ".jail")
+		require.NoError(t, os.WriteFile(global, []byte("snapshot-exclude node_modules\n"), 0644))
+		defer os.Remove(global)
+		writeFiles(t, workspace, map[string]string{".git/hooks/test.pem": "key", "node_modules/pkg/.env": "SECRET=1"})
+		defer os.RemoveAll(filepath.Join(workspace, ".git"))
+		defer os.RemoveAll(filepath.Join(workspace, "node_modules"))
+		masks := func(p *jailPlan) []string {
+			var targets []string
+			for _, m := range p.Mounts {
+				if m.Type == jail.MountMask {
+					targets = append(targets, m.Target)
+				}
+			}
+			return targets
+		}
+
+		p := plan(t, &jailArgs{jailDir: workspace, cmdName: "sh"})
+
+		assert.Equal(t, []string{filepath.Join(workspacePath, ".env")}, masks(p))
+
+		// Masks locked by the machine policy apply inside excluded directories too
+		require.NoError(t, os.WriteFile(policyConfigPath, []byte("mask *.key\n"), 0644))
+		defer os.Remove(policyConfigPath)
+		p = plan(t, &jailArgs{jailDir: workspace, cmdName: "sh"})
+
+		assert.ElementsMatch(t, []string{filepath.Join(workspacePath, ".env"), filepath.Join(workspacePath, "node_modules", "pkg", ".env")}, masks(p))
+	})
+
+	t.Run("mounts inside other mounts come after them", func(t *testing.T) {
+		global := filepath.Join(home, ".jail")
+		require.NoError(t, os.MkdirAll(filepath.Join(home, ".cache"), 0755))
+		require.NoError(t, os.WriteFile(global, []byte("tmpfs ~/.cache/x\n~/.cache rw\n"), 0644))
+		defer os.Remove(global)
+
+		p := plan(t, &jailArgs{jailDir: workspace, cmdName: "sh"})
+
+		index := func(target string) int {
+			return slices.IndexFunc(p.Mounts, func(m jail.Mount) bool { return m.Target == target })
+		}
+		assert.Less(t, index(filepath.Join(home, ".cache")), index(filepath.Join(home, ".cache", "x")))
+		assert.Less(t, index("/tmp"), index(filepath.Join(home, ".cache")))
+	})
+
+	t.Run("writable paths of a read-only workspace", func(t *testing.T) {
+		p := plan(t, &jailArgs{jailDir: workspace, readOnly: true, cmdName: "sh"})
+
+		assert.Equal(t, []string{"ro"}, findPlanMount(t, p, workspacePath).Options)
+		writable := findPlanMount(t, p, filepath.Join(workspacePath, "build"))
+		assert.Equal(t, jail.Mount{
+			Type:    jail.MountBind,
+			Source:  filepath.Join(workspace, "build"),
+			Target:  filepath.Join(workspacePath, "build"),
+			Options: []string{"rw"},
+			Create:  true,
+			Origin:  "workspace " + local + ":3",
+		}, writable)
+		assert.NoDirExists(t, filepath.Join(workspace, "build"), "planning must not change anything")
+		assert.Equal(t, jail.Mount{
+			Type:    jail.MountBind,
+			Source:  filepath.Join(workspace, "notes.txt"),
+			Target:  filepath.Join(workspacePath, "notes.txt"),
+			Options: []string{"rw"},
+			File:    true,
+			Create:  true,
+			Origin:  "workspace " + local + ":4",
+		}, findPlanMount(t, p, filepath.Join(workspacePath, "notes.txt")))
+	})
+
+	t.Run("overlay workspace", func(t *testing.T) {
+		session := &overlaySession{dir: "/tmp/jail-overlay-x"}
+		p := plan(t, &jailArgs{jailDir: workspace, overlay: true, cmdName: "sh"})
+
+		p.setOverlaySession(session)
+
+		m := findPlanMount(t, p, workspacePath)
+		assert.Equal(t, jail.MountOverlay, m.Type)
+		assert.Equal(t, []string{"upperdir=" + session.upperDir(), "workdir=" + session.workDir()}, m.Options)
+	})
+
+	t.Run("application state", func(t *testing.T) {
+		p := plan(t, &jailArgs{jailDir: workspace, apps: []string{"gh"}, cmdName: "sh"})
+
+		m := findPlanMount(t, p, filepath.Join(home, ".config", "gh"))
+		assert.Equal(t, "app gh", m.Origin)
+		assert.Empty(t, m.Skipped)
+	})
+
+	t.Run("host network and devices", func(t *testing.T) {
+		p := plan(t, &jailArgs{jailDir: workspace, netMode: netModeHost, devMode: devModeHost, cmdName: "sh"})
+
+		assert.NotContains(t, p.Namespaces, "net")
+		assert.Equal(t, jail.Mount{Type: jail.MountDev, Source: "/dev", Target: "/dev", Origin: originFlag}, p.Mounts[len(p.Mounts)-1])
+		assert.Empty(t, p.Devices)
+	})
+
+	t.Run("command not in the jail", func(t *testing.T) {
+		args := &jailArgs{jailDir: workspace, cmdName: "jail-test-no-such-command"}
+		cfg, err := loadJailConfig(args)
+		require.NoError(t, err)
+
+		_, err = planJail(args, cfg)
+
+		assert.ErrorContains(t, err, "finding command jail-test-no-such-command")
+	})
+}
+
+// TestPlanHostSource tests mapping paths inside the jail to host paths
+func TestPlanHostSource(t *testing.T) {
+	p := &jailPlan{Spec: &jail.Spec{Mounts: []jail.Mount{
+		{Type: jail.MountTmpfs, Target: "/tmp"},
+		{Type: jail.MountBind, Source: "/usr", Target: "/usr"},
+		{Type: jail.MountBind, Source: "/opt/go", Target: "/usr/local/go"},
+		{Type: jail.MountBind, Source: "/missing", Target: "/usr/local/go/bin", Skipped: "does not exist"},
+		{Type: jail.MountBind, Source: "/src/app", Target: "/workspace/app"},
+	}}}
+
+	tests := []struct {
+		path string
+		want string
+		ok   bool
+	}{
+		{"/usr/bin/make", "/usr/bin/make", true},
+		{"/usr/local/go/bin/go", "/opt/go/bin/go", true},
+		{"/workspace/app", "/src/app", true},
+		{"/tmp/x", "", false},
+		{"/etc/passwd", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := p.hostSource(tt.path)
+
+		assert.Equal(t, tt.ok, ok, tt.path)
+		assert.Equal(t, tt.want, got, tt.path)
+	}
+
+	_, err := p.statInJail("/etc/passwd")
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// TestPlanNamespaces tests the namespaces created for each network mode
+func TestPlanNamespaces(t *testing.T) {
+	assert.Contains(t, planNamespaces(&jailConfig{netMode: netModeNone}), "net")
+	assert.Contains(t, planNamespaces(&jailConfig{netMode: netModeProxy}), "net")
+	assert.NotContains(t, planNamespaces(&jailConfig{netMode: netModeHost}), "net")
+}
+
+// TestPrintPlan tests the text and JSON output of --dry-run
+func TestPrintPlan(t *testing.T) {
+	p := &jailPlan{
+		Spec: &jail.Spec{
+			Namespaces: []string{"mount", "user"},
+			UIDMap:     jail.IDMap{Container: 0, Host: 1000, Size: 1},
+			GIDMap:     jail.IDMap{Container: 0, Host: 1000, Size: 1},
+			Seccomp:    jail.SeccompDefault,
+			Mounts: []jail.Mount{
+				{Type: jail.MountBind, Source: "/usr", Target: "/usr", Options: []string{"ro"}, Origin: originBuiltin},
+				{Type: jail.MountBind, Source: "/opt/x", Target: "/opt/x", Options: []string{"ro"}, Skipped: "does not exist", Origin: "workspace /src/.jail:1"},
+			},
+			Env:       []string{"JAIL_NET=none"},
+			Workspace: "/workspace/src",
+			Command:   "/usr/bin/make",
+			Args:      []string{"make", "test"},
+		},
+		Network: netModeNone,
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var out bytes.Buffer
+
+		require.NoError(t, printPlan(&out, p, planOutputText))
+
+		assert.Contains(t, out.String(), "command:     /usr/bin/make\n")
+		assert.Contains(t, out.String(), "workspace:   /workspace/src\n")
+		assert.Contains(t, out.String(), "uid map:     1000 -> 0 (1)\n")
+		assert.Contains(t, out.String(), "env:         JAIL_NET=none\n")
+		assert.Regexp(t, `(?m)^  bind +/usr +/usr +ro +built-in$`, out.String())
+		assert.Regexp(t, `(?m)^  bind +/opt/x \(skipped: does not exist\) +/opt/x +ro +workspace /src/.jail:1$`, out.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+
+		require.NoError(t, printPlan(&out, p, planOutputJSON))
+
+		var decoded jailPlan
+		require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+		assert.Equal(t, *p, decoded)
+		assert.Contains(t, out.String(), `"uid_map"`)
+	})
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// policyConfigPath is the machine-wide config that users cannot override. The
+// same settings may be written in the structured format to policyConfigPath.yaml.
+var policyConfigPath = "/etc/jail/config"
+
+// readPolicy reads the machine policy from path, in the .jail line format, and
+// from path.yaml. Profile and app sections are not allowed. It returns nil if
+// there is no policy.
+func readPolicy(path string) (*jailConfig, error) {
+	var policy *jailConfig
+	for _, read := range []struct {
+		path string
+		read func(string) (*jailConfig, error)
+	}{
+		{path, readConfigFile},
+		{path + ".yaml", readStructuredConfig},
+	} {
+		cfg, err := read.read(read.path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(cfg.profiles) > 0 || len(cfg.appDefs) > 0 {
+			return nil, fmt.Errorf("%s: profile and app sections are not supported in the machine policy", read.path)
+		}
+		cfg.fillOrigin(originPolicy, read.path)
+		if policy == nil {
+			policy = &jailConfig{}
+		}
+		policy.merge(cfg)
+	}
+	return policy, nil
+}
+
+// applyPolicy applies the machine policy on top of the user's config and flags:
+//   - net, dev and seccomp replace the user's choice; a conflicting flag is an error
+//   - allow replaces the user's allowlist
+//   - resource limits are upper bounds for the user's limits
+//   - masks cannot be exempted with unmask
+//   - env wins over the user's variables, and other lists are added
+func (c *jailConfig) applyPolicy(policy *jailConfig, args *jailArgs) error {
+	if policy == nil {
+		return nil
+	}
+
+	flags := []struct {
+		flag, value, policy, directive string
+	}{
+		{"--net", args.netMode, policy.netMode, "net"},
+		{"--dev", args.devMode, policy.devMode, "dev"},
+		{"--seccomp", args.seccomp, policy.seccomp, "seccomp"},
+	}
+	for _, f := range flags {
+		if f.value != "" && f.policy != "" && f.value != f.policy {
+			return fmt.Errorf("%s=%s is not allowed: %s sets %s %s", f.flag, f.value, policyConfigPath, f.directive, f.policy)
+		}
+	}
+
+	limits := make(map[string]uint64, len(c.limits))
+	for name, value := range c.limits {
+		limits[name] = value
+	}
+	allow := c.allow
+	locked := policy.masks
+	policy.masks = nil
+
+	c.merge(policy)
+
+	for name, value := range policy.limits {
+		if userValue, ok := limits[name]; ok && userValue < value {
+			c.limits[name] = userValue
+		}
+	}
+	if len(policy.allow) > 0 {
+		if len(allow) > 0 {
+			c.warnings = append(c.warnings, fmt.Sprintf("ignoring allow rules from config files: %s sets the allowlist", policyConfigPath))
+		}
+		c.allow = policy.allow
+	}
+	c.lockedMasks = append(c.lockedMasks, locked...)
+	return nil
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/wfaler/jail/jail"
+)
+
+// TestReadPolicy tests reading the machine policy
+func TestReadPolicy(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config")
+
+	t.Run("missing policy", func(t *testing.T) {
+		policy, err := readPolicy(path)
+
+		require.NoError(t, err)
+		assert.Nil(t, policy)
+	})
+
+	t.Run("line format", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("net none\nmask *.sqlite\n"), 0644))
+
+		policy, err := readPolicy(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeNone, policy.netMode)
+		assert.Equal(t, []string{"*.sqlite"}, policy.masks)
+	})
+
+	t.Run("structured format next to it", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path+".yaml", []byte("version: 1\nresources:\n  nproc: 256\n"), 0644))
+		defer os.Remove(path + ".yaml")
+
+		policy, err := readPolicy(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeNone, policy.netMode)
+		assert.Equal(t, map[string]uint64{"nproc": 256}, policy.limits)
+	})
+
+	t.Run("sections are rejected", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(path, []byte("[profile open]\nnet host\n"), 0644))
+
+		_, err := readPolicy(path)
+
+		assert.ErrorContains(t, err, "not supported in the machine policy")
+	})
+}
+
+// TestApplyPolicy tests how the machine policy overrides user settings
+func TestApplyPolicy(t *testing.T) {
+	user := func() *jailConfig {
+		return &jailConfig{
+			netMode: netModeHost,
+			allow:   []allowRule{{domain: "example.org"}},
+			masks:   []string{".env"},
+			env:     map[string]string{"A": "user", "B": "user"},
+			limits:  map[string]uint64{"nofile": 100, "nproc": 5000},
+		}
+	}
+
+	t.Run("no policy", func(t *testing.T) {
+		cfg := user()
+
+		require.NoError(t, cfg.applyPolicy(nil, &jailArgs{}))
+
+		assert.Equal(t, user(), cfg)
+	})
+
+	t.Run("policy settings win", func(t *testing.T) {
+		cfg := user()
+		policy := &jailConfig{
+			netMode: netModeProxy,
+			allow:   []allowRule{{domain: "internal.example.com"}},
+			masks:   []string{"*.sqlite"},
+			env:     map[string]string{"B": "policy"},
+			limits:  map[string]uint64{"nofile": 1000, "nproc": 512, "cpu": 60},
+		}
+
+		require.NoError(t, cfg.applyPolicy(policy, &jailArgs{}))
+
+		assert.Equal(t, netModeProxy, cfg.netMode)
+		assert.Equal(t, []allowRule{{domain: "internal.example.com"}}, cfg.allow)
+		assert.Equal(t, []string{".env"}, cfg.masks)
+		assert.Equal(t, []string{"*.sqlite"}, cfg.lockedMasks)
+		assert.Equal(t, map[string]string{"A": "user", "B": "policy"}, cfg.env)
+		assert.Equal(t, map[string]uint64{"nofile": 100, "nproc": 512, "cpu": 60}, cfg.limits)
+		assert.Len(t, cfg.warnings, 1)
+	})
+
+	t.Run("conflicting flags", func(t *testing.T) {
+		policy := &jailConfig{seccomp: jail.SeccompDefault}
+
+		err := user().applyPolicy(policy, &jailArgs{seccomp: jail.SeccompUnconfined})
+		assert.ErrorContains(t, err, "--seccomp=unconfined is not allowed")
+
+		err = user().applyPolicy(policy, &jailArgs{seccomp: jail.SeccompDefault})
+		assert.NoError(t, err)
+	})
+}
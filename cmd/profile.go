@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// profileEnvVar exposes the active profile to the jailed process
+const profileEnvVar = "JAIL_PROFILE"
+
+// sectionNamePattern matches valid profile and app names
+var sectionNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+// jailProfile is a named set of settings applied on top of the config files
+// when selected with --profile or by the name of the command
+type jailProfile struct {
+	extends  string      // profile whose settings are applied first
+	commands []string    // command names that select the profile without --profile
+	settings *jailConfig // settings added by the profile itself
+}
+
+// parseSectionName validates a profile or app name
+func parseSectionName(name string) (string, error) {
+	if !sectionNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid name %q (use letters, digits, '.', '_' and '-')", name)
+	}
+	return name, nil
+}
+
+// profile returns the profile with the given name, adding an empty one if needed
+func (c *jailConfig) profile(name string) *jailProfile {
+	if c.profiles == nil {
+		c.profiles = make(map[string]*jailProfile)
+	}
+	p, ok := c.profiles[name]
+	if !ok {
+		p = &jailProfile{settings: &jailConfig{}}
+		c.profiles[name] = p
+	}
+	return p
+}
+
+// parseLine applies a line inside a profile section: "extends <profile>",
+// "command <name>..." or any line of the .jail format
+func (p *jailProfile) parseLine(line string) error {
+	fields := strings.Fields(line)
+
+	switch fields[0] {
+	case "extends":
+		if len(fields) != 2 {
+			return fmt.Errorf("usage: extends <profile>")
+		}
+		name, err := parseSectionName(fields[1])
+		if err != nil {
+			return err
+		}
+		p.extends = name
+	case "command":
+		if len(fields) < 2 {
+			return fmt.Errorf("usage: command <name>...")
+		}
+		for _, f := range fields[1:] {
+			name, err := parseCommandName(f)
+			if err != nil {
+				return err
+			}
+			p.commands = append(p.commands, name)
+		}
+	default:
+		return p.settings.parseLine(line)
+	}
+
+	return nil
+}
+
+// parseLineAt applies a line like parseLine and records origin for the settings it makes
+func (p *jailProfile) parseLineAt(line string, origin configOrigin) error {
+	switch strings.Fields(line)[0] {
+	case "extends", "command":
+		return p.parseLine(line)
+	}
+	return p.settings.parseLineAt(line, origin)
+}
+
+// parseCommandName validates a command name that selects a profile or app
+func parseCommandName(name string) (string, error) {
+	if name == "" || strings.Contains(name, "/") {
+		return "", fmt.Errorf("command %q must be a name without a directory", name)
+	}
+	return name, nil
+}
+
+// merge adds the settings of a profile definition from a later config file
+func (p *jailProfile) merge(other *jailProfile) {
+	if other.extends != "" {
+		p.extends = other.extends
+	}
+	p.commands = append(p.commands, other.commands...)
+	p.settings.merge(other.settings)
+}
+
+// selectProfile returns the profile to apply: the one named by --profile, or
+// else the one listing the base name of the command. It returns "" if no
+// profile applies.
+func (c *jailConfig) selectProfile(args *jailArgs) (string, error) {
+	if args.profile != "" {
+		if _, ok := c.profiles[args.profile]; !ok {
+			return "", fmt.Errorf("unknown profile %q%s", args.profile, c.profileList())
+		}
+		return args.profile, nil
+	}
+	if args.cmdName == "" {
+		return "", nil
+	}
+
+	command := filepath.Base(args.cmdName)
+	var matches []string
+	for name, p := range c.profiles {
+		for _, cmd := range p.commands {
+			if cmd == command {
+				matches = append(matches, name)
+				break
+			}
+		}
+	}
+	sort.Strings(matches)
+	if len(matches) > 1 {
+		return "", fmt.Errorf("command %s selects several profiles (%s); choose one with --profile", command, strings.Join(matches, ", "))
+	}
+	if len(matches) == 1 {
+		return matches[0], nil
+	}
+	return "", nil
+}
+
+// profileList returns the defined profile names for error messages
+func (c *jailConfig) profileList() string {
+	if len(c.profiles) == 0 {
+		return " (no profiles are defined)"
+	}
+	names := make([]string, 0, len(c.profiles))
+	for name := range c.profiles {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return fmt.Sprintf(" (expected one of: %s)", strings.Join(names, ", "))
+}
+
+// profileSettings returns the settings of the named profile, preceded by those
+// of the profiles it extends
+func (c *jailConfig) profileSettings(name string) (*jailConfig, error) {
+	if _, ok := c.profiles[name]; !ok {
+		return nil, fmt.Errorf("unknown profile %q%s", name, c.profileList())
+	}
+
+	var chain []string
+	for seen := make(map[string]bool); name != ""; name = c.profiles[name].extends {
+		if seen[name] {
+			return nil, fmt.Errorf("profile %s extends itself: %s -> %s", chain[0], strings.Join(chain, " -> "), name)
+		}
+		if _, ok := c.profiles[name]; !ok {
+			return nil, fmt.Errorf("profile %s extends unknown profile %q", chain[len(chain)-1], name)
+		}
+		seen[name] = true
+		chain = append(chain, name)
+	}
+
+	settings := &jailConfig{}
+	for i := len(chain) - 1; i >= 0; i-- {
+		settings.merge(c.profiles[chain[i]].settings)
+	}
+	return settings, nil
+}
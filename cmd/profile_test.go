@@ -0,0 +1,143 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestReadJailConfigProfiles tests profile sections in .jail files
+func TestReadJailConfigProfiles(t *testing.T) {
+	dir := t.TempDir()
+	configPath := filepath.Join(dir, ".jail")
+
+	t.Run("lines after a header belong to the profile", func(t *testing.T) {
+		content := `/opt/shared
+net none
+
+[profile base]
+/home/user/go/pkg/mod rw
+
+[profile claude]
+extends base
+command claude claude-code
+net host
+seccomp claude.json
+`
+		require.NoError(t, os.WriteFile(configPath, []byte(content), 0644))
+
+		cfg, err := readJailConfig(configPath)
+
+		require.NoError(t, err)
+		assert.Equal(t, []string{"/opt/shared"}, mountTargets(cfg.mounts))
+		assert.Equal(t, netModeNone, cfg.netMode)
+		require.Len(t, cfg.profiles, 2)
+		assert.Equal(t, []string{"/home/user/go/pkg/mod"}, mountTargets(cfg.profiles["base"].settings.mounts))
+		claude := cfg.profiles["claude"]
+		assert.Equal(t, "base", claude.extends)
+		assert.Equal(t, []string{"claude", "claude-code"}, claude.commands)
+		assert.Equal(t, netModeHost, claude.settings.netMode)
+		assert.Equal(t, filepath.Join(dir, "claude.json"), claude.settings.seccomp)
+	})
+
+	t.Run("profile directives outside a profile", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(configPath, []byte("command claude\n"), 0644))
+
+		_, err := readJailConfig(configPath)
+
+		assert.ErrorContains(t, err, configPath+":1: command is only valid in a [profile <name>] or [app <name>] section")
+	})
+
+	t.Run("invalid lines in a profile", func(t *testing.T) {
+		require.NoError(t, os.WriteFile(configPath, []byte("[profile x]\ncommand /usr/bin/claude\n"), 0644))
+
+		_, err := readJailConfig(configPath)
+
+		assert.ErrorContains(t, err, configPath+":2: command \"/usr/bin/claude\" must be a name without a directory")
+	})
+}
+
+// TestSelectProfile tests choosing a profile by flag or command name
+func TestSelectProfile(t *testing.T) {
+	cfg := &jailConfig{}
+	cfg.profile("claude").commands = []string{"claude"}
+	cfg.profile("node").commands = []string{"npm", "npx"}
+	cfg.profile("offline")
+
+	tests := []struct {
+		name string
+		args jailArgs
+		want string
+	}{
+		{"flag", jailArgs{profile: "offline", cmdName: "npm"}, "offline"},
+		{"command name", jailArgs{cmdName: "npm"}, "node"},
+		{"command path", jailArgs{cmdName: "/usr/local/bin/claude"}, "claude"},
+		{"no match", jailArgs{cmdName: "make"}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := cfg.selectProfile(&tt.args)
+
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	t.Run("unknown profile", func(t *testing.T) {
+		_, err := cfg.selectProfile(&jailArgs{profile: "nope"})
+
+		assert.ErrorContains(t, err, `unknown profile "nope" (expected one of: claude, node, offline)`)
+	})
+
+	t.Run("ambiguous command", func(t *testing.T) {
+		cfg.profile("offline").commands = []string{"npm"}
+		defer func() { cfg.profile("offline").commands = nil }()
+
+		_, err := cfg.selectProfile(&jailArgs{cmdName: "npm"})
+
+		assert.ErrorContains(t, err, "command npm selects several profiles (node, offline)")
+	})
+}
+
+// TestProfileSettings tests resolving the profiles a profile extends
+func TestProfileSettings(t *testing.T) {
+	cfg := &jailConfig{}
+	base := cfg.profile("base")
+	base.settings = &jailConfig{netMode: netModeNone, masks: []string{"*.db"}}
+	node := cfg.profile("node")
+	node.extends = "base"
+	node.settings = &jailConfig{netMode: netModeProxy, masks: []string{"*.npmrc"}}
+	registry := cfg.profile("registry")
+	registry.extends = "node"
+	registry.settings = &jailConfig{env: map[string]string{"NPM_CONFIG_REGISTRY": "https://registry.example.com"}}
+
+	t.Run("extended settings come first", func(t *testing.T) {
+		settings, err := cfg.profileSettings("registry")
+
+		require.NoError(t, err)
+		assert.Equal(t, netModeProxy, settings.netMode)
+		assert.Equal(t, []string{"*.db", "*.npmrc"}, settings.masks)
+		assert.Equal(t, map[string]string{"NPM_CONFIG_REGISTRY": "https://registry.example.com"}, settings.env)
+	})
+
+	t.Run("unknown base profile", func(t *testing.T) {
+		base.extends = "missing"
+		defer func() { base.extends = "" }()
+
+		_, err := cfg.profileSettings("node")
+
+		assert.ErrorContains(t, err, `profile base extends unknown profile "missing"`)
+	})
+
+	t.Run("cycles", func(t *testing.T) {
+		base.extends = "registry"
+		defer func() { base.extends = "" }()
+
+		_, err := cfg.profileSettings("node")
+
+		assert.ErrorContains(t, err, "profile node extends itself: node -> base -> registry -> node")
+	})
+}
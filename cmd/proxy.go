@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"strings"
+	"sync"
+
+	"github.com/wfaler/jail/jail"
+)
+
+// errProxyDenied is returned when a destination is not on the allowlist
+var errProxyDenied = errors.New("destination not allowed")
+
+// allowRule is a single egress allowlist entry: a domain, a wildcard domain
+// ("*.example.com", matching any subdomain) or an IP network, optionally
+// restricted to one port
+type allowRule struct {
+	domain   string
+	wildcard bool
+	network  *net.IPNet
+	port     string
+}
+
+// parseAllowRule parses an allowlist entry such as "registry.npmjs.org",
+// "*.github.com:443", "10.0.0.0/8" or "192.168.1.10"
+func parseAllowRule(s string) (allowRule, error) {
+	// Bare IPs and CIDRs (including IPv6, which contain colons) have no port
+	if _, network, err := net.ParseCIDR(s); err == nil {
+		return allowRule{network: network}, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return allowRule{network: singleIPNet(ip)}, nil
+	}
+
+	rule := allowRule{}
+	host := s
+	if h, port, err := net.SplitHostPort(s); err == nil {
+		if _, err := net.LookupPort("tcp", port); err != nil {
+			return allowRule{}, fmt.Errorf("invalid port in allow rule %q", s)
+		}
+		host, rule.port = h, port
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		rule.network = singleIPNet(ip)
+		return rule, nil
+	}
+
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if strings.HasPrefix(host, "*.") {
+		rule.wildcard = true
+		host = strings.TrimPrefix(host, "*.")
+	}
+	if host == "" || strings.ContainsAny(host, "*/ ") {
+		return allowRule{}, fmt.Errorf("invalid allow rule %q", s)
+	}
+	rule.domain = host
+
+	return rule, nil
+}
+
+// singleIPNet returns a network containing only ip
+func singleIPNet(ip net.IP) *net.IPNet {
+	if ip4 := ip.To4(); ip4 != nil {
+		return &net.IPNet{IP: ip4, Mask: net.CIDRMask(32, 32)} //nolint:mnd // IPv4 host mask
+	}
+	return &net.IPNet{IP: ip, Mask: net.CIDRMask(128, 128)} //nolint:mnd // IPv6 host mask
+}
+
+// String returns the rule in the form parseAllowRule accepts
+func (r allowRule) String() string {
+	host := r.domain
+	switch {
+	case r.network != nil:
+		if ones, bits := r.network.Mask.Size(); ones == bits {
+			host = r.network.IP.String()
+		} else {
+			host = r.network.String()
+		}
+	case r.wildcard:
+		host = "*." + host
+	}
+	if r.port == "" {
+		return host
+	}
+	return net.JoinHostPort(host, r.port)
+}
+
+// matchesHost reports whether the rule allows the given host name and port
+func (r allowRule) matchesHost(host, port string) bool {
+	if r.domain == "" || (r.port != "" && r.port != port) {
+		return false
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if r.wildcard {
+		return strings.HasSuffix(host, "."+r.domain)
+	}
+	return host == r.domain
+}
+
+// matchesIP reports whether the rule allows the given address and port
+func (r allowRule) matchesIP(ip net.IP, port string) bool {
+	if r.network == nil || (r.port != "" && r.port != port) {
+		return false
+	}
+	return r.network.Contains(ip)
+}
+
+// egressProxy is an HTTP proxy supporting CONNECT tunnels and plain HTTP
+// requests that only connects to destinations on its allowlist
+type egressProxy struct {
+	rules    []allowRule
+	log      io.Writer
+	resolver *net.Resolver
+	dialer   net.Dialer
+	forward  *httputil.ReverseProxy
+}
+
+// newEgressProxy creates a proxy enforcing rules and logging denials to log
+func newEgressProxy(rules []allowRule, log io.Writer) *egressProxy {
+	p := &egressProxy{rules: rules, log: log, resolver: net.DefaultResolver}
+	p.forward = &httputil.ReverseProxy{
+		// Proxy requests already carry the absolute destination URL
+		Rewrite:   func(*httputil.ProxyRequest) {},
+		Transport: &http.Transport{DialContext: p.dial},
+		ErrorHandler: func(w http.ResponseWriter, _ *http.Request, err error) {
+			p.writeError(w, err)
+		},
+	}
+	return p
+}
+
+// resolve returns the address to connect to for a "host:port" destination,
+// or errProxyDenied if no rule allows it
+func (p *egressProxy) resolve(ctx context.Context, addr string) (string, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return "", err
+	}
+
+	if ip := net.ParseIP(host); ip != nil {
+		for _, r := range p.rules {
+			if r.matchesIP(ip, port) {
+				return addr, nil
+			}
+		}
+		return "", errProxyDenied
+	}
+
+	for _, r := range p.rules {
+		if r.matchesHost(host, port) {
+			return addr, nil
+		}
+	}
+
+	if !p.hasNetworkRules() {
+		return "", errProxyDenied
+	}
+
+	// Names not allowed by a domain rule may still resolve into an allowed network.
+	// Connect to the checked address so a second lookup cannot change the answer.
+	addrs, err := p.resolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		// A name we cannot check against the allowed networks is not allowed
+		return "", fmt.Errorf("%w: %w", errProxyDenied, err)
+	}
+	for _, a := range addrs {
+		for _, r := range p.rules {
+			if r.matchesIP(a.IP, port) {
+				return net.JoinHostPort(a.IP.String(), port), nil
+			}
+		}
+	}
+
+	return "", errProxyDenied
+}
+
+// hasNetworkRules reports whether any rule allows an IP network
+func (p *egressProxy) hasNetworkRules() bool {
+	for _, r := range p.rules {
+		if r.network != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// dial connects to addr if the allowlist permits it, logging denied attempts
+func (p *egressProxy) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	target, err := p.resolve(ctx, addr)
+	if errors.Is(err, errProxyDenied) {
+		fmt.Fprintf(p.log, "jail: proxy denied connection to %s\n", addr)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", addr, err)
+	}
+	return p.dialer.DialContext(ctx, network, target)
+}
+
+// writeError reports a failed proxy request to the client
+func (p *egressProxy) writeError(w http.ResponseWriter, err error) {
+	if errors.Is(err, errProxyDenied) {
+		http.Error(w, "jail: "+err.Error(), http.StatusForbidden)
+		return
+	}
+	http.Error(w, "jail: "+err.Error(), http.StatusBadGateway)
+}
+
+// ServeHTTP handles a single proxy request
+func (p *egressProxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodConnect {
+		p.tunnel(w, r)
+		return
+	}
+
+	if !r.URL.IsAbs() || r.URL.Scheme != "http" {
+		http.Error(w, "jail: only proxy requests are supported", http.StatusBadRequest)
+		return
+	}
+	p.forward.ServeHTTP(w, r)
+}
+
+// tunnel handles a CONNECT request by relaying bytes between client and destination
+func (p *egressProxy) tunnel(w http.ResponseWriter, r *http.Request) {
+	upstream, err := p.dial(r.Context(), "tcp", r.Host)
+	if err != nil {
+		p.writeError(w, err)
+		return
+	}
+	defer upstream.Close() //nolint:errcheck // Connection is finished either way
+
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "jail: tunnelling not supported", http.StatusInternalServerError)
+		return
+	}
+	client, buffered, err := hijacker.Hijack()
+	if err != nil {
+		return
+	}
+	defer client.Close() //nolint:errcheck // Connection is finished either way
+
+	if _, err := io.WriteString(client, "HTTP/1.1 200 Connection Established\r\n\r\n"); err != nil {
+		return
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		// Forward anything the client sent after the CONNECT headers, then the rest
+		_, _ = io.Copy(upstream, buffered)
+		closeWrite(upstream)
+	}()
+	_, _ = io.Copy(client, upstream)
+	closeWrite(client)
+	wg.Wait()
+}
+
+// closeWrite half-closes a connection so the peer sees EOF
+func closeWrite(conn net.Conn) {
+	if c, ok := conn.(interface{ CloseWrite() error }); ok {
+		_ = c.CloseWrite()
+	}
+}
+
+// setProxyEnv points tools inside the jail at the egress proxy
+func setProxyEnv(env []string) []string {
+	proxyURL := "http://" + jail.ProxyAddr
+	noProxy := "localhost,127.0.0.1,::1"
+
+	for _, key := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+		env = setOrUpdateEnv(env, key, proxyURL)
+	}
+	for _, key := range []string{"NO_PROXY", "no_proxy"} {
+		env = setOrUpdateEnv(env, key, noProxy)
+	}
+	return env
+}
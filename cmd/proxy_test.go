@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseAllowRule tests parsing of egress allowlist entries
+func TestParseAllowRule(t *testing.T) {
+	t.Run("domain", func(t *testing.T) {
+		rule, err := parseAllowRule("Registry.NPMjs.org")
+
+		require.NoError(t, err)
+		assert.Equal(t, "registry.npmjs.org", rule.domain)
+		assert.False(t, rule.wildcard)
+		assert.Empty(t, rule.port)
+	})
+
+	t.Run("wildcard domain with port", func(t *testing.T) {
+		rule, err := parseAllowRule("*.github.com:443")
+
+		require.NoError(t, err)
+		assert.Equal(t, "github.com", rule.domain)
+		assert.True(t, rule.wildcard)
+		assert.Equal(t, "443", rule.port)
+	})
+
+	t.Run("cidr", func(t *testing.T) {
+		rule, err := parseAllowRule("10.0.0.0/8")
+
+		require.NoError(t, err)
+		require.NotNil(t, rule.network)
+		assert.Equal(t, "10.0.0.0/8", rule.network.String())
+	})
+
+	t.Run("ipv6 address", func(t *testing.T) {
+		rule, err := parseAllowRule("2001:db8::1")
+
+		require.NoError(t, err)
+		require.NotNil(t, rule.network)
+		assert.Equal(t, "2001:db8::1/128", rule.network.String())
+	})
+
+	t.Run("ip with port", func(t *testing.T) {
+		rule, err := parseAllowRule("192.168.1.10:8080")
+
+		require.NoError(t, err)
+		assert.Equal(t, "192.168.1.10/32", rule.network.String())
+		assert.Equal(t, "8080", rule.port)
+	})
+
+	t.Run("invalid port", func(t *testing.T) {
+		_, err := parseAllowRule("example.com:https-ish")
+
+		assert.Error(t, err)
+	})
+
+	t.Run("misplaced wildcard", func(t *testing.T) {
+		_, err := parseAllowRule("api.*.example.com")
+
+		assert.Error(t, err)
+	})
+}
+
+// TestEgressProxyResolve tests allowlist decisions
+func TestEgressProxyResolve(t *testing.T) {
+	rules := make([]allowRule, 0, 4)
+	for _, s := range []string{"example.com", "*.github.com:443", "10.0.0.0/8", "127.0.0.1:8080"} {
+		rule, err := parseAllowRule(s)
+		require.NoError(t, err)
+		rules = append(rules, rule)
+	}
+	p := newEgressProxy(rules, io.Discard)
+
+	allowed := []string{"example.com:443", "EXAMPLE.com.:80", "api.github.com:443", "a.b.github.com:443", "10.1.2.3:22", "127.0.0.1:8080"}
+	for _, addr := range allowed {
+		t.Run("allows "+addr, func(t *testing.T) {
+			_, err := p.resolve(context.Background(), addr)
+
+			assert.NoError(t, err)
+		})
+	}
+
+	denied := []string{"www.example.com:443", "github.com:443", "api.github.com:80", "11.0.0.1:443", "127.0.0.1:8081", "notexample.com:443"}
+	for _, addr := range denied {
+		t.Run("denies "+addr, func(t *testing.T) {
+			_, err := p.resolve(context.Background(), addr)
+
+			assert.ErrorIs(t, err, errProxyDenied)
+		})
+	}
+
+	t.Run("names are not resolved without network rules", func(t *testing.T) {
+		domainOnly := newEgressProxy(rules[:2], io.Discard)
+		domainOnly.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(context.Context, string, string) (net.Conn, error) {
+				return nil, fmt.Errorf("unexpected lookup")
+			},
+		}
+
+		_, err := domainOnly.resolve(context.Background(), "other.org:443")
+
+		assert.ErrorIs(t, err, errProxyDenied)
+	})
+}
+
+// TestEgressProxyServe tests CONNECT tunnels and plain HTTP forwarding through the proxy
+func TestEgressProxyServe(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = io.WriteString(w, "hello from upstream")
+	}))
+	defer upstream.Close()
+	upstreamURL, err := url.Parse(upstream.URL)
+	require.NoError(t, err)
+
+	allowed, err := parseAllowRule(upstreamURL.Host)
+	require.NoError(t, err)
+	log := &lockedBuffer{}
+	proxy := httptest.NewServer(newEgressProxy([]allowRule{allowed}, log))
+	defer proxy.Close()
+	proxyURL, err := url.Parse(proxy.URL)
+	require.NoError(t, err)
+
+	client := &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxyURL)}}
+
+	t.Run("forwards plain http to allowed host", func(t *testing.T) {
+		resp, err := client.Get(upstream.URL)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "hello from upstream", string(body))
+	})
+
+	t.Run("rejects plain http to other hosts", func(t *testing.T) {
+		resp, err := client.Get("http://blocked.invalid/")
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Contains(t, log.String(), "denied connection to blocked.invalid:80")
+	})
+
+	t.Run("tunnels CONNECT to allowed host", func(t *testing.T) {
+		conn, err := net.Dial("tcp", proxyURL.Host)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", upstreamURL.Host, upstreamURL.Host)
+		reader := bufio.NewReader(conn)
+		resp, err := http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+
+		fmt.Fprintf(conn, "GET / HTTP/1.1\r\nHost: %s\r\nConnection: close\r\n\r\n", upstreamURL.Host)
+		resp, err = http.ReadResponse(reader, nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+
+		require.NoError(t, err)
+		assert.Equal(t, "hello from upstream", string(body))
+	})
+
+	t.Run("rejects CONNECT to other hosts", func(t *testing.T) {
+		conn, err := net.Dial("tcp", proxyURL.Host)
+		require.NoError(t, err)
+		defer conn.Close()
+
+		fmt.Fprintf(conn, "CONNECT evil.invalid:443 HTTP/1.1\r\nHost: evil.invalid:443\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+		assert.Contains(t, log.String(), "denied connection to evil.invalid:443")
+	})
+}
+
+// lockedBuffer is a bytes.Buffer safe for concurrent use by proxy handlers
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestSetProxyEnv tests the proxy environment variables
+func TestSetProxyEnv(t *testing.T) {
+	env := setProxyEnv([]string{"PATH=/usr/bin", "HTTPS_PROXY=http://corporate:8080"})
+
+	assert.Contains(t, env, "HTTP_PROXY=http://127.0.0.1:3128")
+	assert.Contains(t, env, "HTTPS_PROXY=http://127.0.0.1:3128")
+	assert.Contains(t, env, "https_proxy=http://127.0.0.1:3128")
+	assert.Contains(t, env, "NO_PROXY=localhost,127.0.0.1,::1")
+	assert.NotContains(t, env, "HTTPS_PROXY=http://corporate:8080")
+}
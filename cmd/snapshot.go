@@ -0,0 +1,501 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// workspaceSnapshot records the state of a workspace before a --snapshot run.
+// File contents are kept in a content-addressed blob store next to the manifest,
+// outside the workspace, so the jailed process cannot change them.
+type workspaceSnapshot struct {
+	ID        string          `json:"-"`
+	Workspace string          `json:"workspace"`
+	Created   time.Time       `json:"created"`
+	Exclude   []string        `json:"exclude,omitempty"`
+	Entries   []snapshotEntry `json:"entries"`
+	dir       string
+}
+
+// snapshotEntry is one file, directory or symlink of a snapshot manifest
+type snapshotEntry struct {
+	Path    string      `json:"path"`
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime time.Time   `json:"mtime"`
+	CTime   time.Time   `json:"ctime"`
+	Hash    string      `json:"sha256,omitempty"`
+	Target  string      `json:"target,omitempty"`
+}
+
+// parseSnapshotExclude validates the patterns of a .jail "snapshot-exclude" line
+func parseSnapshotExclude(patterns []string) ([]string, error) {
+	if len(patterns) == 0 {
+		return nil, fmt.Errorf("usage: snapshot-exclude <pattern>...")
+	}
+	for _, p := range patterns {
+		if _, err := filepath.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("invalid snapshot-exclude pattern %q: %w", p, err)
+		}
+	}
+	return patterns, nil
+}
+
+// isExcluded reports whether a workspace-relative path matches one of the
+// patterns, either as a whole or by its last element (so "node_modules"
+// excludes every node_modules directory)
+func isExcluded(rel string, patterns []string) bool {
+	for _, p := range patterns {
+		if ok, _ := filepath.Match(p, rel); ok {
+			return true
+		}
+		if ok, _ := filepath.Match(p, filepath.Base(rel)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// snapshotsDir returns the directory holding all workspace snapshots
+func snapshotsDir() (string, error) {
+	dir, err := stateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "snapshots"), nil
+}
+
+// takeSnapshot records every file, directory and symlink of workspace that is
+// not excluded. Other file types such as sockets are skipped.
+func takeSnapshot(workspace string, exclude []string) (*workspaceSnapshot, error) {
+	dir, err := snapshotsDir()
+	if err != nil {
+		return nil, err
+	}
+	id, err := newStateID()
+	if err != nil {
+		return nil, fmt.Errorf("creating snapshot id: %w", err)
+	}
+
+	s := &workspaceSnapshot{ID: id, Workspace: workspace, Created: time.Now(), Exclude: exclude, dir: filepath.Join(dir, id)}
+	if err := os.MkdirAll(s.blobsDir(), 0700); err != nil {
+		return nil, fmt.Errorf("creating snapshot: %w", err)
+	}
+
+	if err := s.record(); err != nil {
+		_ = s.discard()
+		return nil, fmt.Errorf("taking snapshot of %s: %w", workspace, err)
+	}
+	return s, nil
+}
+
+// record copies the workspace into the blob store and writes the manifest
+func (s *workspaceSnapshot) record() error {
+	entries, err := scanWorkspace(s.Workspace, s.Exclude)
+	if err != nil {
+		return err
+	}
+
+	for i := range entries {
+		e := &entries[i]
+		if !e.Mode.IsRegular() {
+			continue
+		}
+		if e.Hash, err = storeBlob(s.blobsDir(), filepath.Join(s.Workspace, e.Path)); err != nil {
+			return err
+		}
+	}
+	s.Entries = entries
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(s.dir, "manifest.json"), data, 0600)
+}
+
+// scanWorkspace lists the files, directories and symlinks below workspace,
+// sorted so that directories precede their contents. Contents are not hashed.
+func scanWorkspace(workspace string, exclude []string) ([]snapshotEntry, error) {
+	var entries []snapshotEntry
+	err := filepath.WalkDir(workspace, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == workspace {
+			return nil
+		}
+
+		rel, err := filepath.Rel(workspace, path)
+		if err != nil {
+			return err
+		}
+		if isExcluded(rel, exclude) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e := snapshotEntry{Path: rel, Mode: info.Mode(), ModTime: info.ModTime(), CTime: changeTime(info)}
+		switch {
+		case info.Mode().IsRegular():
+			e.Size = info.Size()
+		case info.Mode()&os.ModeSymlink != 0:
+			if e.Target, err = os.Readlink(path); err != nil {
+				return err
+			}
+		case !info.IsDir():
+			return nil
+		}
+		entries = append(entries, e)
+		return nil
+	})
+
+	return entries, err
+}
+
+// storeBlob copies a file into the blob store under its SHA-256 and returns the hash
+func storeBlob(blobsDir, path string) (string, error) {
+	in, err := os.Open(path) //nolint:gosec // Path comes from walking the workspace
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = in.Close() }()
+
+	tmp, err := os.CreateTemp(blobsDir, ".blob-*")
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+
+	hash := sha256.New()
+	if _, err := io.Copy(io.MultiWriter(tmp, hash), in); err != nil {
+		_ = tmp.Close()
+		return "", fmt.Errorf("copying %s: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if err := os.Rename(tmp.Name(), filepath.Join(blobsDir, sum)); err != nil {
+		return "", err
+	}
+	return sum, nil
+}
+
+// hashFile returns the SHA-256 of a file's contents
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path) //nolint:gosec // Path comes from walking the workspace
+	if err != nil {
+		return "", err
+	}
+	defer func() { _ = f.Close() }()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// loadSnapshot reads an existing snapshot by id
+func loadSnapshot(id string) (*workspaceSnapshot, error) {
+	dir, err := snapshotsDir()
+	if err != nil {
+		return nil, err
+	}
+	if id == "" || strings.ContainsAny(id, "/.") {
+		return nil, fmt.Errorf("invalid snapshot %q", id)
+	}
+
+	s := &workspaceSnapshot{ID: id, dir: filepath.Join(dir, id)}
+	data, err := os.ReadFile(filepath.Join(s.dir, "manifest.json"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no snapshot %s: %w", id, fs.ErrNotExist)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, s); err != nil {
+		return nil, fmt.Errorf("reading snapshot %s: %w", id, err)
+	}
+
+	return s, nil
+}
+
+// listSnapshots returns the ids of all snapshots, oldest first
+func listSnapshots() ([]string, error) {
+	dir, err := snapshotsDir()
+	if err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]string, 0, len(entries))
+	for _, e := range entries {
+		if e.IsDir() {
+			ids = append(ids, e.Name())
+		}
+	}
+	return ids, nil
+}
+
+// latestSnapshot returns the most recent snapshot of workspace
+func latestSnapshot(workspace string) (*workspaceSnapshot, error) {
+	ids, err := listSnapshots()
+	if err != nil {
+		return nil, err
+	}
+	for i := len(ids) - 1; i >= 0; i-- {
+		s, err := loadSnapshot(ids[i])
+		if err == nil && s.Workspace == workspace {
+			return s, nil
+		}
+	}
+	return nil, fmt.Errorf("no snapshots of %s", workspace)
+}
+
+func (s *workspaceSnapshot) blobsDir() string { return filepath.Join(s.dir, "blobs") }
+
+// changeTime returns the inode change time of a file, which unlike its
+// modification time cannot be set by the jailed process
+func changeTime(info os.FileInfo) time.Time {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return time.Time{}
+	}
+	return time.Unix(st.Ctim.Unix())
+}
+
+// changed reports whether the workspace path of e differs from the snapshot.
+// Contents are only hashed when size, modification and change time do not
+// settle it: a file rewritten at the same size with its modification time
+// restored still has a new change time.
+func (s *workspaceSnapshot) changed(e snapshotEntry, info os.FileInfo) (bool, error) {
+	if info.Mode() != e.Mode {
+		return !(e.Mode.IsDir() && info.IsDir()), nil
+	}
+	switch {
+	case e.Mode&os.ModeSymlink != 0:
+		target, err := os.Readlink(filepath.Join(s.Workspace, e.Path))
+		return target != e.Target, err
+	case e.Mode.IsRegular():
+		if info.Size() != e.Size {
+			return true, nil
+		}
+		if info.ModTime().Equal(e.ModTime) && changeTime(info).Equal(e.CTime) {
+			return false, nil
+		}
+		hash, err := hashFile(filepath.Join(s.Workspace, e.Path))
+		return hash != e.Hash, err
+	}
+	return false, nil
+}
+
+// changes compares the workspace with the snapshot. Deleted directories are
+// listed without their contents, added ones only when they are empty.
+func (s *workspaceSnapshot) changes() ([]overlayChange, error) {
+	current, err := scanWorkspace(s.Workspace, s.Exclude)
+	if err != nil {
+		return nil, err
+	}
+	existing := make(map[string]bool, len(current))
+	for _, e := range current {
+		existing[e.Path] = true
+	}
+
+	var changes []overlayChange
+	recorded := make(map[string]bool, len(s.Entries))
+	deletedDirs := make(map[string]bool)
+	for _, e := range s.Entries {
+		recorded[e.Path] = true
+		if deletedDirs[filepath.Dir(e.Path)] {
+			deletedDirs[e.Path] = e.Mode.IsDir()
+			continue
+		}
+
+		info, err := os.Lstat(filepath.Join(s.Workspace, e.Path))
+		if !existing[e.Path] || os.IsNotExist(err) {
+			deletedDirs[e.Path] = e.Mode.IsDir()
+			changes = append(changes, overlayChange{'D', displayPath(e.Path, e.Mode.IsDir())})
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if changed, err := s.changed(e, info); err != nil {
+			return nil, err
+		} else if changed {
+			changes = append(changes, overlayChange{'M', e.Path})
+		}
+	}
+
+	// New directories are implied by the new files in them
+	hasAddedChild := make(map[string]bool)
+	for _, e := range current {
+		if !recorded[e.Path] {
+			hasAddedChild[filepath.Dir(e.Path)] = true
+		}
+	}
+	for _, e := range current {
+		if !recorded[e.Path] && !(e.Mode.IsDir() && hasAddedChild[e.Path]) {
+			changes = append(changes, overlayChange{'A', displayPath(e.Path, e.Mode.IsDir())})
+		}
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	return changes, nil
+}
+
+// rollback restores every changed or deleted path of the snapshot. Paths added
+// since the snapshot are left in place. The snapshot is removed afterwards.
+func (s *workspaceSnapshot) rollback() error {
+	for _, e := range s.Entries {
+		path := filepath.Join(s.Workspace, e.Path)
+		info, err := os.Lstat(path)
+		if err == nil {
+			changed, err := s.changed(e, info)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				continue
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		switch {
+		case e.Mode.IsDir():
+			if err == nil {
+				if err := os.RemoveAll(path); err != nil {
+					return err
+				}
+			}
+			err = os.Mkdir(path, e.Mode.Perm())
+		case e.Mode&os.ModeSymlink != 0:
+			err = replaceWithSymlink(e.Target, path)
+		default:
+			err = replaceWithFile(filepath.Join(s.blobsDir(), e.Hash), path, e.Mode.Perm())
+		}
+		if err != nil {
+			return fmt.Errorf("restoring %s: %w", e.Path, err)
+		}
+	}
+
+	return s.discard()
+}
+
+// discard deletes the snapshot
+func (s *workspaceSnapshot) discard() error {
+	if err := os.RemoveAll(s.dir); err != nil {
+		return fmt.Errorf("removing snapshot %s: %w", s.ID, err)
+	}
+	return nil
+}
+
+// reportSnapshot prints what a finished --snapshot run changed and how to roll
+// it back. Snapshots without changes are discarded right away.
+func reportSnapshot(w io.Writer, s *workspaceSnapshot) error {
+	changes, err := s.changes()
+	if err != nil {
+		return fmt.Errorf("comparing %s with snapshot %s: %w", s.Workspace, s.ID, err)
+	}
+	if len(changes) == 0 {
+		_, _ = fmt.Fprintf(w, "jail: no changes to %s\n", s.Workspace)
+		return s.discard()
+	}
+
+	_, _ = fmt.Fprintf(w, "jail: %d path(s) in %s changed since snapshot %s:\n", len(changes), s.Workspace, s.ID)
+	for _, c := range changes {
+		_, _ = fmt.Fprintf(w, "  %s\n", c)
+	}
+	_, err = fmt.Fprintf(w, "Run 'jail rollback %s' to restore the snapshot or 'jail discard %s' to keep the changes.\n", s.ID, s.ID)
+	return err
+}
+
+// runRollback implements "jail rollback [<snapshot>]". Without a snapshot it
+// restores the latest snapshot of the current directory.
+func runRollback(w io.Writer, args []string) error {
+	var s *workspaceSnapshot
+	var err error
+	switch len(args) {
+	case 0:
+		var cwd string
+		if cwd, err = os.Getwd(); err != nil {
+			return err
+		}
+		s, err = latestSnapshot(cwd)
+	case 1:
+		s, err = loadSnapshot(args[0])
+	default:
+		return errors.New("usage: jail rollback [<snapshot>]")
+	}
+	if err != nil {
+		return err
+	}
+
+	changes, err := s.changes()
+	if err != nil {
+		return err
+	}
+	if err := s.rollback(); err != nil {
+		return fmt.Errorf("rolling back %s: %w", s.Workspace, err)
+	}
+
+	restored := 0
+	for _, c := range changes {
+		if c.kind == 'A' {
+			_, err = fmt.Fprintf(w, "kept %s (added after the snapshot)\n", c.path)
+		} else {
+			restored++
+			_, err = fmt.Fprintf(w, "restored %s\n", c.path)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err = fmt.Fprintf(w, "restored %d path(s) in %s from snapshot %s\n", restored, s.Workspace, s.ID)
+	return err
+}
+
+// runDiscard implements "jail discard <id>" for overlay sessions and snapshots
+func runDiscard(w io.Writer, args []string) error {
+	if len(args) == 1 {
+		s, err := loadSnapshot(args[0])
+		if err == nil {
+			if err := s.discard(); err != nil {
+				return err
+			}
+			_, err = fmt.Fprintf(w, "discarded snapshot %s\n", s.ID)
+			return err
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return runOverlayCommand(w, "discard", args)
+}
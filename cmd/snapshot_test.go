@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testSnapshot snapshots a workspace holding files in a temporary state directory
+func testSnapshot(t *testing.T, files map[string]string, exclude ...string) *workspaceSnapshot {
+	t.Helper()
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	workspace := t.TempDir()
+	writeFiles(t, workspace, files)
+
+	s, err := takeSnapshot(workspace, exclude)
+	require.NoError(t, err)
+	return s
+}
+
+// TestIsExcluded tests matching of snapshot-exclude patterns
+func TestIsExcluded(t *testing.T) {
+	patterns := []string{"node_modules", "build/out", "*.log"}
+
+	assert.True(t, isExcluded("node_modules", patterns))
+	assert.True(t, isExcluded("web/node_modules", patterns))
+	assert.True(t, isExcluded("build/out", patterns))
+	assert.True(t, isExcluded("logs/app.log", patterns))
+	assert.False(t, isExcluded("src/out", patterns))
+	assert.False(t, isExcluded("node_modules.txt", patterns))
+}
+
+// TestTakeSnapshot tests recording a workspace
+func TestTakeSnapshot(t *testing.T) {
+	t.Run("manifest and blobs live outside the workspace", func(t *testing.T) {
+		s := testSnapshot(t, map[string]string{"a.txt": "same", "dir/b.txt": "same"})
+
+		loaded, err := loadSnapshot(s.ID)
+
+		require.NoError(t, err)
+		assert.Equal(t, s.Workspace, loaded.Workspace)
+		paths := make([]string, 0, len(loaded.Entries))
+		for _, e := range loaded.Entries {
+			paths = append(paths, e.Path)
+		}
+		assert.Equal(t, []string{"a.txt", "dir", "dir/b.txt"}, paths)
+		blobs, err := os.ReadDir(s.blobsDir())
+		require.NoError(t, err)
+		assert.Len(t, blobs, 1, "identical contents are stored once")
+		assert.False(t, isSubPath(s.dir, s.Workspace))
+	})
+
+	t.Run("excluded paths are skipped", func(t *testing.T) {
+		s := testSnapshot(t, map[string]string{"main.go": "x", "node_modules/dep/index.js": "x"}, "node_modules")
+
+		require.Len(t, s.Entries, 1)
+		assert.Equal(t, "main.go", s.Entries[0].Path)
+	})
+}
+
+// TestSnapshotChanges tests comparing a workspace with its snapshot
+func TestSnapshotChanges(t *testing.T) {
+	t.Run("added, modified and deleted paths", func(t *testing.T) {
+		s := testSnapshot(t, map[string]string{"mod": "old", "same": "same", "gone/x": "x", "gone/y": "y"}, "ignored")
+		writeFiles(t, s.Workspace, map[string]string{"mod": "new", "added/file": "new", "ignored/file": "x"})
+		require.NoError(t, os.RemoveAll(filepath.Join(s.Workspace, "gone")))
+		require.NoError(t, os.Mkdir(filepath.Join(s.Workspace, "empty"), 0755))
+
+		changes, err := s.changes()
+
+		require.NoError(t, err)
+		assert.Equal(t, []overlayChange{{'A', "added/file"}, {'A', "empty/"}, {'D', "gone/"}, {'M', "mod"}}, changes)
+	})
+
+	t.Run("touched files with the same contents are unchanged", func(t *testing.T) {
+		s := testSnapshot(t, map[string]string{"file": "same"})
+		later := time.Now().Add(time.Hour)
+		require.NoError(t, os.Chtimes(filepath.Join(s.Workspace, "file"), later, later))
+
+		var out bytes.Buffer
+		err := reportSnapshot(&out, s)
+
+		require.NoError(t, err)
+		assert.Contains(t, out.String(), "no changes")
+		assert.NoDirExists(t, s.dir)
+	})
+
+	t.Run("files rewritten at the same size with the old modification time are modified", func(t *testing.T) {
+		s := testSnapshot(t, map[string]string{"file": "old"})
+		path := filepath.Join(s.Workspace, "file")
+		info, err := os.Stat(path)
+		require.NoError(t, err)
+		time.Sleep(10 * time.Millisecond) // let the change time move on
+		require.NoError(t, os.WriteFile(path, []byte("new"), 0644))
+		require.NoError(t, os.Chtimes(path, info.ModTime(), info.ModTime()))
+
+		changes, err := s.changes()
+
+		require.NoError(t, err)
+		assert.Equal(t, []overlayChange{{'M', "file"}}, changes)
+	})
+
+	t.Run("permission changes are modifications", func(t *testing.T) {
+		s := testSnapshot(t, map[string]string{"run.sh": "echo"})
+		require.NoError(t, os.Chmod(filepath.Join(s.Workspace, "run.sh"), 0755))
+
+		changes, err := s.changes()
+
+		require.NoError(t, err)
+		assert.Equal(t, []overlayChange{{'M', "run.sh"}}, changes)
+	})
+}
+
+// TestRunRollback tests restoring a workspace from a snapshot
+func TestRunRollback(t *testing.T) {
+	t.Setenv("XDG_STATE_HOME", t.TempDir())
+	workspace := t.TempDir()
+	writeFiles(t, workspace, map[string]string{"mod": "old", "gone/x": "x", "replaced": "file"})
+	require.NoError(t, os.Symlink("mod", filepath.Join(workspace, "link")))
+	s, err := takeSnapshot(workspace, nil)
+	require.NoError(t, err)
+
+	writeFiles(t, s.Workspace, map[string]string{"mod": "new", "added": "new"})
+	require.NoError(t, os.RemoveAll(filepath.Join(s.Workspace, "gone")))
+	require.NoError(t, os.Remove(filepath.Join(s.Workspace, "replaced")))
+	require.NoError(t, os.Mkdir(filepath.Join(s.Workspace, "replaced"), 0755))
+	require.NoError(t, os.Remove(filepath.Join(s.Workspace, "link")))
+	require.NoError(t, os.Symlink("added", filepath.Join(s.Workspace, "link")))
+
+	var out bytes.Buffer
+	err = runRollback(&out, []string{s.ID})
+
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "kept added (added after the snapshot)")
+	assert.Contains(t, out.String(), "restored 4 path(s)")
+	for name, want := range map[string]string{"mod": "old", "gone/x": "x", "replaced": "file", "added": "new"} {
+		content, err := os.ReadFile(filepath.Join(s.Workspace, name))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(content), name)
+	}
+	target, err := os.Readlink(filepath.Join(s.Workspace, "link"))
+	require.NoError(t, err)
+	assert.Equal(t, "mod", target)
+	assert.NoDirExists(t, s.dir)
+}
+
+// TestLatestSnapshot tests choosing the snapshot for "jail rollback" without arguments
+func TestLatestSnapshot(t *testing.T) {
+	s := testSnapshot(t, map[string]string{"file": "x"})
+	other, err := takeSnapshot(t.TempDir(), nil)
+	require.NoError(t, err)
+
+	latest, err := latestSnapshot(s.Workspace)
+
+	require.NoError(t, err)
+	assert.Equal(t, s.ID, latest.ID)
+	_, err = latestSnapshot(filepath.Join(other.Workspace, "elsewhere"))
+	assert.ErrorContains(t, err, "no snapshots of")
+}
+
+// TestRunDiscard tests that discard drops snapshots and overlay sessions
+func TestRunDiscard(t *testing.T) {
+	s := testSnapshot(t, map[string]string{"file": "x"})
+
+	var out bytes.Buffer
+	err := runDiscard(&out, []string{s.ID})
+
+	require.NoError(t, err)
+	assert.Equal(t, "discarded snapshot "+s.ID+"\n", out.String())
+	assert.NoDirExists(t, s.dir)
+	assert.FileExists(t, filepath.Join(s.Workspace, "file"))
+	err = runDiscard(&out, []string{"20000101-000000-000000"})
+	assert.ErrorContains(t, err, "no overlay session")
+}
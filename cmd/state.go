@@ -0,0 +1,34 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// stateDir returns the directory where jail keeps data that outlives a run,
+// $XDG_STATE_HOME/jail or ~/.local/state/jail. It is not mounted into jails,
+// so jailed processes cannot tamper with it.
+func stateDir() (string, error) {
+	base := os.Getenv("XDG_STATE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("finding state directory: %w", err)
+		}
+		base = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(base, "jail"), nil
+}
+
+// newStateID returns a sortable, unique name for a session or snapshot
+func newStateID() (string, error) {
+	suffix := make([]byte, 3) //nolint:mnd // six hex digits avoid collisions within a second
+	if _, err := rand.Read(suffix); err != nil {
+		return "", err
+	}
+	return time.Now().Format("20060102-150405") + "-" + hex.EncodeToString(suffix), nil
+}
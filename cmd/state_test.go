@@ -0,0 +1,43 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestStateDir tests where jail keeps sessions and snapshots
+func TestStateDir(t *testing.T) {
+	t.Run("uses XDG_STATE_HOME", func(t *testing.T) {
+		t.Setenv("XDG_STATE_HOME", "/state")
+
+		dir, err := stateDir()
+
+		require.NoError(t, err)
+		assert.Equal(t, "/state/jail", dir)
+	})
+
+	t.Run("falls back to ~/.local/state", func(t *testing.T) {
+		t.Setenv("XDG_STATE_HOME", "")
+		t.Setenv("HOME", "/home/dev")
+
+		dir, err := stateDir()
+
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join("/home/dev", ".local", "state", "jail"), dir)
+	})
+}
+
+// TestNewStateID tests that ids sort by creation time and do not repeat
+func TestNewStateID(t *testing.T) {
+	first, err := newStateID()
+	require.NoError(t, err)
+	second, err := newStateID()
+	require.NoError(t, err)
+
+	assert.Regexp(t, regexp.MustCompile(`^\d{8}-\d{6}-[0-9a-f]{6}$`), first)
+	assert.NotEqual(t, first, second)
+}
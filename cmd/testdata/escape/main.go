@@ -0,0 +1,70 @@
+// Command escape tries to break out of the jail's root directory and reports
+// whether the host path given as its argument became reachable. It is built
+// and run inside the jail by the integration tests.
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// escapeDepth is more ".." steps than any real path is deep
+const escapeDepth = 64
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprintln(os.Stderr, "usage: escape dotdot|fd <host path>")
+		os.Exit(2)
+	}
+	method, target := os.Args[1], os.Args[2]
+
+	var err error
+	switch method {
+	case "dotdot":
+		err = escapeDotDot()
+	case "fd":
+		err = escapeFd()
+	default:
+		err = fmt.Errorf("unknown method %s", method)
+	}
+	if err != nil {
+		fmt.Printf("contained: %v\n", err)
+		return
+	}
+
+	if _, err := os.Stat(target); err != nil {
+		fmt.Printf("contained: %v\n", err)
+		return
+	}
+	fmt.Println("escaped")
+}
+
+// escapeDotDot walks up from the root directory and chroots to wherever it ends
+func escapeDotDot() error {
+	for range escapeDepth {
+		if err := os.Chdir(".."); err != nil {
+			return err
+		}
+	}
+	return syscall.Chroot(".")
+}
+
+// escapeFd keeps a directory fd open across a nested chroot, then walks up from it,
+// the classic escape for a process that may call chroot
+func escapeFd() error {
+	if err := os.MkdirAll("/tmp/escape", 0o755); err != nil {
+		return err
+	}
+	fd, err := syscall.Open("/", syscall.O_RDONLY|syscall.O_DIRECTORY, 0)
+	if err != nil {
+		return err
+	}
+	if err := syscall.Chroot("/tmp/escape"); err != nil {
+		return err
+	}
+	if err := syscall.Fchdir(fd); err != nil {
+		return err
+	}
+	return escapeDotDot()
+}
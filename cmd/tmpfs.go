@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// defaultTmpfsSize limits each tmpfs unless a .jail entry gives its own size
+const defaultTmpfsSize = "1g"
+
+// tmpfsSizePattern matches tmpfs size= values: bytes with an optional k, m or g
+// suffix, or a percentage of RAM
+var tmpfsSizePattern = regexp.MustCompile(`^([0-9]+[kKmMgG]?|[0-9]+%)$`)
+
+// tmpfsEntry describes a private, size-limited tmpfs mounted into the jail
+type tmpfsEntry struct {
+	target string
+	size   string
+}
+
+// defaultTmpfs returns the scratch directories every jail gets
+func defaultTmpfs() []tmpfsEntry {
+	return []tmpfsEntry{{target: "/tmp", size: defaultTmpfsSize}}
+}
+
+// String returns the entry as a .jail line
+func (e tmpfsEntry) String() string {
+	return fmt.Sprintf("tmpfs %s size=%s", filepath.Clean(e.target), e.size)
+}
+
+// parseTmpfsEntry parses the arguments of a .jail "tmpfs <path> [size=<size>]" line
+func parseTmpfsEntry(args []string) (tmpfsEntry, error) {
+	if len(args) == 0 || len(args) > 2 {
+		return tmpfsEntry{}, fmt.Errorf("usage: tmpfs <path> [size=<size>]")
+	}
+
+	entry := tmpfsEntry{target: args[0], size: defaultTmpfsSize}
+	if len(args) == 2 {
+		size, ok := strings.CutPrefix(args[1], "size=")
+		if !ok {
+			return tmpfsEntry{}, fmt.Errorf("unknown tmpfs option %q", args[1])
+		}
+		if !tmpfsSizePattern.MatchString(size) {
+			return tmpfsEntry{}, fmt.Errorf("invalid tmpfs size %q (use e.g. 512m, 2g or 10%%)", size)
+		}
+		entry.size = size
+	}
+
+	return entry, nil
+}
+
+// validateTmpfs checks tmpfs targets like mount targets and deduplicates them,
+// later entries replacing earlier ones. A bind mount at the same path takes
+// the place of the tmpfs, so a .jail can still share e.g. the host /tmp.
+func validateTmpfs(entries []tmpfsEntry, mounts []mountEntry) ([]tmpfsEntry, error) {
+	bound := make(map[string]bool, len(mounts))
+	for _, m := range mounts {
+		bound[m.target] = true
+	}
+
+	result := make([]tmpfsEntry, 0, len(entries))
+	byTarget := make(map[string]int, len(entries))
+	for _, e := range entries {
+		if !filepath.IsAbs(e.target) {
+			return nil, fmt.Errorf("tmpfs target %s is not an absolute path", e.target)
+		}
+		e.target = filepath.Clean(e.target)
+
+		if e.target == "/" {
+			return nil, fmt.Errorf("tmpfs target cannot be /")
+		}
+		for _, reserved := range reservedTargets {
+			if isSubPath(e.target, reserved) {
+				return nil, fmt.Errorf("tmpfs target %s collides with reserved path %s", e.target, reserved)
+			}
+		}
+		if bound[e.target] {
+			continue
+		}
+
+		if i, ok := byTarget[e.target]; ok {
+			result[i] = e
+			continue
+		}
+		byTarget[e.target] = len(result)
+		result = append(result, e)
+	}
+
+	return result, nil
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseTmpfsEntry tests parsing of .jail tmpfs directives
+func TestParseTmpfsEntry(t *testing.T) {
+	t.Run("path only uses the default size", func(t *testing.T) {
+		entry, err := parseTmpfsEntry([]string{"/var/tmp"})
+
+		require.NoError(t, err)
+		assert.Equal(t, tmpfsEntry{target: "/var/tmp", size: defaultTmpfsSize}, entry)
+	})
+
+	t.Run("explicit sizes", func(t *testing.T) {
+		for _, size := range []string{"512m", "2G", "65536", "25%"} {
+			entry, err := parseTmpfsEntry([]string{"/run", "size=" + size})
+
+			require.NoError(t, err, size)
+			assert.Equal(t, size, entry.size)
+		}
+	})
+
+	t.Run("invalid size", func(t *testing.T) {
+		_, err := parseTmpfsEntry([]string{"/tmp", "size=lots"})
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "invalid tmpfs size")
+	})
+
+	t.Run("unknown option", func(t *testing.T) {
+		_, err := parseTmpfsEntry([]string{"/tmp", "mode=0700"})
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "unknown tmpfs option")
+	})
+
+	t.Run("missing path", func(t *testing.T) {
+		_, err := parseTmpfsEntry(nil)
+
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "usage")
+	})
+}
+
+// TestValidateTmpfs tests tmpfs target validation and deduplication
+func TestValidateTmpfs(t *testing.T) {
+	t.Run("later entries replace earlier ones", func(t *testing.T) {
+		entries := []tmpfsEntry{{target: "/tmp", size: "1g"}, {target: "/var/tmp/", size: "1g"}, {target: "/tmp", size: "64m"}}
+
+		result, err := validateTmpfs(entries, nil)
+
+		require.NoError(t, err)
+		assert.Equal(t, []tmpfsEntry{{target: "/tmp", size: "64m"}, {target: "/var/tmp", size: "1g"}}, result)
+	})
+
+	t.Run("bind mount at the same path wins", func(t *testing.T) {
+		result, err := validateTmpfs(defaultTmpfs(), []mountEntry{{source: "/tmp", target: "/tmp"}})
+
+		require.NoError(t, err)
+		assert.Empty(t, result)
+	})
+
+	t.Run("invalid targets", func(t *testing.T) {
+		for _, target := range []string{"tmp", "/", "/dev/shm", "/workspace/scratch"} {
+			_, err := validateTmpfs([]tmpfsEntry{{target: target, size: "1g"}}, nil)
+
+			assert.Error(t, err, target)
+		}
+	})
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/wfaler/jail/jail"
+)
+
+// parseWritablePath validates a path from a .jail "writable" line. Paths are
+// relative to the workspace and may not leave it.
+func parseWritablePath(path string) (string, error) {
+	if filepath.IsAbs(path) {
+		return "", fmt.Errorf("writable path %s must be relative to the workspace", path)
+	}
+	clean := filepath.Clean(path)
+	if clean == "." {
+		return "", fmt.Errorf("writable path %s is the whole workspace; run without --ro instead", path)
+	}
+	if clean == ".." || strings.HasPrefix(clean, "../") {
+		return "", fmt.Errorf("writable path %s is outside the workspace", path)
+	}
+	return clean, nil
+}
+
+// writableSource resolves a writable path below workspace as seen from root
+// and returns it relative to the resolved workspace. Symlinks are followed
+// within root, but the result must stay inside the workspace so that a link
+// cannot make host paths writable.
+func writableSource(root, workspace, path string) (string, error) {
+	base := jail.ResolveInRoot(root, workspace)
+	resolved := jail.ResolveInRoot(root, filepath.Join(workspace, path))
+	if !isSubPath(resolved, base) || resolved == base {
+		return "", fmt.Errorf("writable path %s resolves outside the workspace", path)
+	}
+	return filepath.Rel(base, resolved)
+}
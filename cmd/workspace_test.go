@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseWritablePath tests validation of workspace-relative writable paths
+func TestParseWritablePath(t *testing.T) {
+	t.Run("relative paths are cleaned", func(t *testing.T) {
+		path, err := parseWritablePath("build/")
+
+		require.NoError(t, err)
+		assert.Equal(t, "build", path)
+	})
+
+	t.Run("rejected paths", func(t *testing.T) {
+		for _, path := range []string{"/tmp", ".", "./", "..", "../other", "build/../../x"} {
+			_, err := parseWritablePath(path)
+			assert.Error(t, err, path)
+		}
+	})
+}
+
+// TestWritableSource tests that writable paths cannot leave the workspace through symlinks
+func TestWritableSource(t *testing.T) {
+	root := t.TempDir()
+	workspace := filepath.Join(root, "home", "dev", "project")
+	require.NoError(t, os.MkdirAll(filepath.Join(workspace, "out"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "etc"), 0755))
+	require.NoError(t, os.Symlink("out", filepath.Join(workspace, "build")))
+	require.NoError(t, os.Symlink("/etc", filepath.Join(workspace, "escape")))
+	require.NoError(t, os.Symlink("..", filepath.Join(workspace, "parent")))
+
+	t.Run("links within the workspace are followed", func(t *testing.T) {
+		rel, err := writableSource(root, "/home/dev/project", "build")
+
+		require.NoError(t, err)
+		assert.Equal(t, "out", rel)
+	})
+
+	t.Run("missing paths stay as given", func(t *testing.T) {
+		rel, err := writableSource(root, "/home/dev/project", ".cache/go")
+
+		require.NoError(t, err)
+		assert.Equal(t, ".cache/go", rel)
+	})
+
+	t.Run("links leaving the workspace are rejected", func(t *testing.T) {
+		_, err := writableSource(root, "/home/dev/project", "escape")
+		assert.ErrorContains(t, err, "outside the workspace")
+
+		_, err = writableSource(root, "/home/dev/project", "parent")
+		assert.ErrorContains(t, err, "outside the workspace")
+	})
+}
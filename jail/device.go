@@ -0,0 +1,104 @@
+package jail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// devSymlinks are the standard links of a private /dev
+var devSymlinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+	"ptmx":   "pts/ptmx",
+}
+
+// CheckDevice reports an error if a clean absolute path cannot be listed in
+// Spec.Devices: it must lie below /dev and not collide with what a private
+// /dev provides itself
+func CheckDevice(path string) error {
+	if path == "/dev" || !isSubPath(path, "/dev") {
+		return fmt.Errorf("device %s is not below /dev", path)
+	}
+	for _, managed := range []string{"/dev/pts", "/dev/shm"} {
+		if isSubPath(path, managed) {
+			return fmt.Errorf("device %s collides with %s, which jail mounts itself", path, managed)
+		}
+	}
+	if _, ok := devSymlinks[strings.TrimPrefix(path, "/dev/")]; ok {
+		return fmt.Errorf("device %s collides with a /dev symlink", path)
+	}
+	return nil
+}
+
+// setupPrivateDev builds a minimal /dev below root: a tmpfs holding the given
+// devices bound from the host, a private devpts instance, a tmpfs /dev/shm and
+// the standard symlinks. Devices missing on the host are skipped.
+func setupPrivateDev(root string, devices []string) error {
+	devDir := filepath.Join(root, "dev")
+	if err := syscall.Mount("tmpfs", devDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NOEXEC, "mode=0755,size=64k"); err != nil {
+		return fmt.Errorf("mounting tmpfs on /dev: %w", err)
+	}
+
+	for _, dev := range devices {
+		if err := bindDevice(root, dev); err != nil {
+			return err
+		}
+	}
+
+	// A new devpts instance only holds the ptys created inside the jail
+	ptsDir := filepath.Join(devDir, "pts")
+	if err := os.Mkdir(ptsDir, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
+		return fmt.Errorf("creating /dev/pts: %w", err)
+	}
+	if err := syscall.Mount("devpts", ptsDir, "devpts", syscall.MS_NOSUID|syscall.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+		return fmt.Errorf("mounting devpts: %w", err)
+	}
+
+	if err := mountTmpfs(root, "/dev/shm", rootTmpfsSize); err != nil {
+		return err
+	}
+
+	for name, target := range devSymlinks {
+		if err := os.Symlink(target, filepath.Join(devDir, name)); err != nil {
+			return fmt.Errorf("creating /dev/%s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// bindDevice bind mounts a host device node, or a directory of them such as
+// /dev/dri, to the same path below root
+func bindDevice(root, dev string) error {
+	source := hostPath(dev)
+	info, err := os.Stat(source)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("checking device %s: %w", dev, err)
+	}
+
+	target := filepath.Join(root, dev)
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
+		return fmt.Errorf("creating parent directory of %s: %w", dev, err)
+	}
+	if info.IsDir() {
+		err = os.Mkdir(target, 0755) //nolint:gosec,mnd // 0755 is appropriate for directory permissions
+	} else {
+		err = os.WriteFile(target, nil, 0600)
+	}
+	if err != nil {
+		return fmt.Errorf("creating mount point for %s: %w", dev, err)
+	}
+
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind mounting %s: %w", dev, err)
+	}
+	return nil
+}
@@ -0,0 +1,22 @@
+package jail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCheckDevice tests which paths may be listed in Spec.Devices
+func TestCheckDevice(t *testing.T) {
+	t.Run("devices below /dev", func(t *testing.T) {
+		for _, path := range []string{"/dev/kvm", "/dev/net/tun", "/dev/dri"} {
+			assert.NoError(t, CheckDevice(path), path)
+		}
+	})
+
+	t.Run("paths outside /dev or managed by the jail", func(t *testing.T) {
+		for _, path := range []string{"/dev", "/etc/passwd", "/devices", "/dev/pts/0", "/dev/shm", "/dev/fd", "/dev/stdin"} {
+			assert.Error(t, CheckDevice(path), path)
+		}
+	})
+}
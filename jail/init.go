@@ -0,0 +1,141 @@
+package jail
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+// forwardedSignals are passed on by the init process to the command's process
+// group: those Run forwards from stage 1, plus terminal resizes
+var forwardedSignals = append(slices.Clone(stage1Signals), syscall.SIGWINCH)
+
+// prSetChildSubreaper is PR_SET_CHILD_SUBREAPER, which the syscall package does not define
+const prSetChildSubreaper = 36
+
+// signalExitBase is added to the signal number of a command killed by a
+// signal to form the exit status, as shells do
+const signalExitBase = 128
+
+// runInit runs the command of s as the child of the calling process, which is
+// PID 1 of the jail, like tini: the child gets its own process group (in the
+// foreground if stdin is a terminal), the signals in forwardedSignals are
+// forwarded to that group, every process that ends up as our child is reaped,
+// and we exit with the child's status once it exits. It only returns on error.
+// The caller must hold the thread (runtime.LockOSThread) so the child inherits
+// its Landlock domain and seccomp filter.
+func runInit(s *Spec) error {
+	signals := make(chan os.Signal, 16) //nolint:mnd // room for a burst of signals; SIGCHLD reaps all exited children at once
+	signal.Notify(signals, append([]os.Signal{syscall.SIGCHLD}, forwardedSignals...)...)
+
+	attr := &syscall.ProcAttr{
+		Env:   s.commandEnv(),
+		Files: []uintptr{0, 1, 2},
+		Sys:   &syscall.SysProcAttr{Setpgid: true},
+	}
+	if isTerminal(0) {
+		attr.Sys.Foreground = true
+		attr.Sys.Ctty = 0
+	}
+	pid, err := syscall.ForkExec(s.Command, s.Args, attr)
+	if err != nil {
+		return fmt.Errorf("exec %s: %w", s.Args[0], err)
+	}
+
+	for sig := range signals {
+		if sig != syscall.SIGCHLD {
+			// The group may already be gone; there is nothing left to signal then
+			_ = syscall.Kill(-pid, sig.(syscall.Signal)) //nolint:forcetypeassert // only syscall signals are registered
+			continue
+		}
+		if status, exited := reapChildren(pid); exited {
+			os.Exit(exitStatus(status))
+		}
+	}
+	return nil
+}
+
+// becomeSubreaper makes orphaned descendants children of the calling process
+// even when it is not PID 1, such as in a jail without a PID namespace
+func becomeSubreaper() error {
+	if _, _, errno := syscall.RawSyscall(syscall.SYS_PRCTL, prSetChildSubreaper, 1, 0); errno != 0 {
+		return fmt.Errorf("becoming child subreaper: %w", errno)
+	}
+	return nil
+}
+
+// reapChildren waits for every exited child without blocking and reports the
+// status of pid if it was among them
+func reapChildren(pid int) (syscall.WaitStatus, bool) {
+	var childStatus syscall.WaitStatus
+	exited := false
+	for {
+		var status syscall.WaitStatus
+		reaped, err := syscall.Wait4(-1, &status, syscall.WNOHANG, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil || reaped <= 0 {
+			return childStatus, exited
+		}
+		if reaped == pid {
+			childStatus, exited = status, true
+		}
+	}
+}
+
+// exitStatus returns the exit status that reports a wait status: the exit
+// code, or 128 plus the signal number for a process killed by a signal
+func exitStatus(status syscall.WaitStatus) int {
+	if status.Signaled() {
+		return signalExitBase + int(status.Signal())
+	}
+	return status.ExitStatus()
+}
+
+// isTerminal reports whether fd refers to a terminal
+func isTerminal(fd int) bool {
+	var termios syscall.Termios
+	//nolint:gosec // ioctl requires passing a pointer to struct termios
+	_, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.TCGETS, uintptr(unsafe.Pointer(&termios)))
+	return errno == 0
+}
+
+// terminal returns stdin as a file if it is a terminal
+func terminal(stdin io.Reader) (*os.File, bool) {
+	f, ok := stdin.(*os.File)
+	if !ok || !isTerminal(int(f.Fd())) {
+		return nil, false
+	}
+	return f, true
+}
+
+// foregroundKeeper returns a function that makes the current foreground
+// process group of stdin the foreground group again, for when the command's
+// process group took over the terminal. It does nothing if stdin is not a terminal.
+func foregroundKeeper(stdin io.Reader) func() {
+	f, ok := terminal(stdin)
+	if !ok {
+		return func() {}
+	}
+	var pgrp int32
+	//nolint:gosec // ioctl requires passing a pointer to the process group id
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCGPGRP, uintptr(unsafe.Pointer(&pgrp))); errno != 0 {
+		return func() {}
+	}
+
+	return func() {
+		// A process outside the foreground group gets SIGTTOU for changing it
+		ignored := signal.Ignored(syscall.SIGTTOU)
+		signal.Ignore(syscall.SIGTTOU)
+		//nolint:gosec // ioctl requires passing a pointer to the process group id
+		_, _, _ = syscall.Syscall(syscall.SYS_IOCTL, f.Fd(), syscall.TIOCSPGRP, uintptr(unsafe.Pointer(&pgrp)))
+		if !ignored {
+			signal.Reset(syscall.SIGTTOU)
+		}
+	}
+}
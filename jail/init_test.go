@@ -0,0 +1,64 @@
+package jail
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestExitStatus tests the exit status reported for exited and killed commands
+func TestExitStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		status syscall.WaitStatus
+		want   int
+	}{
+		{"success", 0, 0},
+		{"exit code", 3 << 8, 3},
+		{"killed by SIGKILL", syscall.WaitStatus(syscall.SIGKILL), 137},
+		{"killed by SIGTERM", syscall.WaitStatus(syscall.SIGTERM), 143},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, exitStatus(tt.status))
+		})
+	}
+}
+
+// TestReapChildren tests that every exited child is reaped and the watched one reported
+func TestReapChildren(t *testing.T) {
+	start := func(t *testing.T, script string) int {
+		t.Helper()
+		cmd := exec.Command("sh", "-c", script)
+		require.NoError(t, cmd.Start())
+		return cmd.Process.Pid
+	}
+	other := start(t, "exit 0")
+	watched := start(t, "sleep 0.2; exit 5")
+
+	var status syscall.WaitStatus
+	exited := false
+	for deadline := time.Now().Add(5 * time.Second); !exited && time.Now().Before(deadline); {
+		status, exited = reapChildren(watched)
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	require.True(t, exited)
+	assert.Equal(t, 5, exitStatus(status))
+	_, err := syscall.Wait4(other, nil, syscall.WNOHANG, nil)
+	assert.ErrorIs(t, err, syscall.ECHILD, "the other child must be reaped too")
+}
+
+// TestIsTerminal tests terminal detection
+func TestIsTerminal(t *testing.T) {
+	devNull, err := os.Open(os.DevNull)
+	require.NoError(t, err)
+	defer devNull.Close()
+
+	assert.False(t, isTerminal(int(devNull.Fd())))
+}
@@ -0,0 +1,523 @@
+// Package jail runs a command in Linux namespaces with a private root
+// filesystem assembled from the mounts of a Spec.
+//
+// A jail is set up in two stages. Run, in the calling process (stage 1),
+// validates the spec, compiles its seccomp filter and starts a copy of the
+// running program (/proc/self/exe) in new namespaces with argv[0] set to
+// stage2Name. It writes the spec as JSON to a pipe the copy inherits as file
+// descriptor 3 (specFd) and closes it. Init, in that copy (stage 2), reads and
+// closes the pipe, builds the jail root, applies the resource limits,
+// Landlock rules and seccomp filter and execs the command, or with Spec.Init
+// starts it and stays behind as its init process. Stage 2 works only
+// from what stage 1 sent: it reads no config, flags or profile files of its
+// own, and nothing of the handoff reaches the jailed command. Programs that
+// call Run must therefore call Init at the very start of main:
+//
+//	func main() {
+//		jail.Init()
+//		...
+//		err := jail.Run(ctx, spec, jail.Stdio{Stdin: os.Stdin, Stdout: os.Stdout, Stderr: os.Stderr})
+//	}
+//
+// Init returns immediately in a program that was not started by Run.
+package jail
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// stage2Name is the argv[0] Run starts stage 2 with, which Init recognizes it by
+const stage2Name = "jail: setup"
+
+// specFd is the descriptor stage 2 reads the stage2 JSON from: the read end of
+// a pipe, the first entry of exec.Cmd.ExtraFiles
+const specFd = 3
+
+// Mount types
+const (
+	MountTmpfs   = "tmpfs"
+	MountBind    = "bind"
+	MountOverlay = "overlay" // lower layer from Source, see Mount.Options
+	MountMask    = "mask"    // an empty read-only file or directory over Target
+	MountProc    = "proc"
+	MountDev     = "dev" // a private /dev with Spec.Devices, or the host /dev bound from Source
+)
+
+// namespaceFlags are the clone flags of the namespaces a spec can list
+var namespaceFlags = map[string]uintptr{
+	"mount": syscall.CLONE_NEWNS,   // isolate the filesystem
+	"user":  syscall.CLONE_NEWUSER, // run unprivileged
+	"pid":   syscall.CLONE_NEWPID,
+	"uts":   syscall.CLONE_NEWUTS, // hostname
+	"ipc":   syscall.CLONE_NEWIPC,
+	"net":   syscall.CLONE_NEWNET, // no access to the host network; loopback is brought up
+}
+
+// Spec describes a jail: its namespaces, the mounts that make up its root
+// filesystem and the command run in it. It is computed from host paths
+// without changing anything, so it can be shown before it is run.
+type Spec struct {
+	Namespaces []string          `json:"namespaces"` // see namespaceFlags; "mount" and "user" are required
+	UIDMap     IDMap             `json:"uid_map"`
+	GIDMap     IDMap             `json:"gid_map"`
+	Mounts     []Mount           `json:"mounts"`            // in the order they are made
+	Devices    []string          `json:"devices,omitempty"` // host devices bound into a private /dev
+	Env        []string          `json:"env"`               // NAME=value, set on top of the calling process's environment
+	Workspace  string            `json:"workspace"`         // directory inside the jail the command starts in
+	Command    string            `json:"command"`           // path of the program inside the jail
+	Args       []string          `json:"args"`              // argv, starting with the command as given
+	Limits     map[string]uint64 `json:"limits,omitempty"`  // resource limits by name, see LimitNames
+	Seccomp    string            `json:"seccomp"`           // SeccompDefault, SeccompUnconfined or the path of a Docker/OCI profile
+	Init       bool              `json:"init"`              // run the command under a minimal init that reaps zombies and forwards signals
+
+	// Proxy, if set, serves HTTP proxy requests made to ProxyAddr inside the
+	// jail's network namespace. It runs in the calling process, outside the jail.
+	Proxy http.Handler `json:"-"`
+}
+
+// IDMap maps a range of host ids to ids inside the user namespace
+type IDMap struct {
+	Container int `json:"container"`
+	Host      int `json:"host"`
+	Size      int `json:"size"`
+}
+
+// Mount is a mount made inside the jail
+type Mount struct {
+	Type     string   `json:"type"`
+	Source   string   `json:"source,omitempty"` // host path
+	Target   string   `json:"target"`           // path inside the jail
+	Options  []string `json:"options,omitempty"`
+	File     bool     `json:"file,omitempty"`     // the target is a file rather than a directory
+	Create   bool     `json:"create,omitempty"`   // a missing source is created as a directory
+	Optional bool     `json:"optional,omitempty"` // failing to mount it is a warning rather than an error
+	Skipped  string   `json:"skipped,omitempty"`  // why the mount is not made; skipped mounts are only listed
+	Origin   string   `json:"origin,omitempty"`   // where the mount comes from, used in messages
+}
+
+// Stdio are the standard streams of the jailed command
+type Stdio struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+}
+
+// stage2 is what Run passes to Init
+type stage2 struct {
+	Spec   *Spec                `json:"spec"`
+	Filter []syscall.SockFilter `json:"filter,omitempty"` // compiled Spec.Seccomp, nil when unconfined
+	Proxy  bool                 `json:"proxy"`            // hand a listener for Spec.Proxy to stage 1
+}
+
+// Validate reports problems with a spec that would stop it from running, such
+// as an unknown namespace or mount type or a broken seccomp profile
+func (s *Spec) Validate() error {
+	for _, ns := range s.Namespaces {
+		if _, ok := namespaceFlags[ns]; !ok {
+			return fmt.Errorf("unknown namespace %q", ns)
+		}
+	}
+	for _, ns := range []string{"mount", "user"} {
+		if !slices.Contains(s.Namespaces, ns) {
+			return fmt.Errorf("the %s namespace is required", ns)
+		}
+	}
+	if s.Proxy != nil && !slices.Contains(s.Namespaces, "net") {
+		return errors.New("a proxy requires the net namespace")
+	}
+	for _, m := range s.Mounts {
+		if m.Skipped != "" {
+			continue
+		}
+		if !strings.HasPrefix(m.Target, "/") {
+			return fmt.Errorf("mount target %q is not an absolute path", m.Target)
+		}
+		switch m.Type {
+		case MountTmpfs, MountOverlay, MountMask, MountProc, MountDev:
+		case MountBind:
+			if _, err := parseMountOptions(m.Options); err != nil {
+				return fmt.Errorf("mount %s: %w", m.Target, err)
+			}
+		default:
+			return fmt.Errorf("mount %s: unknown type %q", m.Target, m.Type)
+		}
+	}
+	for _, dev := range s.Devices {
+		if err := CheckDevice(dev); err != nil {
+			return err
+		}
+	}
+	for name := range s.Limits {
+		if _, ok := resourceLimits[name]; !ok {
+			return fmt.Errorf("unknown resource limit %q", name)
+		}
+	}
+	if s.Command == "" || len(s.Args) == 0 {
+		return errors.New("no command specified")
+	}
+	if _, err := loadSeccompFilter(s.Seccomp); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cloneFlags returns the clone flags that create the namespaces of the spec
+func (s *Spec) cloneFlags() uintptr {
+	var flags uintptr
+	for _, ns := range s.Namespaces {
+		flags |= namespaceFlags[ns]
+	}
+	return flags
+}
+
+// idMaps returns the uid and gid maps of the spec. An empty map makes the
+// current user and group root inside the user namespace, which is not real root.
+func (s *Spec) idMaps() (uid, gid []syscall.SysProcIDMap) {
+	uidMap, gidMap := s.UIDMap, s.GIDMap
+	if uidMap.Size == 0 {
+		uidMap = IDMap{Container: 0, Host: os.Getuid(), Size: 1}
+	}
+	if gidMap.Size == 0 {
+		gidMap = IDMap{Container: 0, Host: os.Getgid(), Size: 1}
+	}
+	return []syscall.SysProcIDMap{{ContainerID: uidMap.Container, HostID: uidMap.Host, Size: uidMap.Size}},
+		[]syscall.SysProcIDMap{{ContainerID: gidMap.Container, HostID: gidMap.Host, Size: gidMap.Size}}
+}
+
+// Run runs the command of spec in a new jail and waits for it to exit. If the
+// command exits with a non-zero status, the error is an *exec.ExitError; see
+// ExitStatus. Cancelling ctx or the death of the caller kills the jail. The
+// signals in stage1Signals sent to the calling process are forwarded to the
+// jail instead of taking their default action. With Spec.Init, the jail runs
+// in its own process group, in the foreground if stdin is a terminal. Without
+// it the command is PID 1 and ignores signals it has no handler for, so Run
+// kills the jail for those and ExitStatus reports the signal.
+// See the package documentation for the requirement to call Init.
+func Run(ctx context.Context, spec *Spec, stdio Stdio) error {
+	if err := spec.Validate(); err != nil {
+		return err
+	}
+
+	// The filter is compiled here so that stage 2 never reads a profile file
+	filter, err := loadSeccompFilter(spec.Seccomp)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(stage2{Spec: spec, Filter: filter, Proxy: spec.Proxy != nil})
+	if err != nil {
+		return fmt.Errorf("encoding spec: %w", err)
+	}
+
+	specReader, specWriter, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("creating spec pipe: %w", err)
+	}
+	defer specWriter.Close() //nolint:errcheck // Closed after writing; this covers early returns
+
+	cmd := exec.CommandContext(ctx, "/proc/self/exe")
+	cmd.Args = []string{stage2Name}
+	cmd.Stdin = stdio.Stdin
+	cmd.Stdout = stdio.Stdout
+	cmd.Stderr = stdio.Stderr
+	cmd.ExtraFiles = []*os.File{specReader}
+
+	uidMap, gidMap := spec.idMaps()
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  spec.cloneFlags(),
+		UidMappings: uidMap,
+		GidMappings: gidMap,
+
+		// Prevent gaining privileges
+		AmbientCaps: []uintptr{},
+		// Take the jail down with us rather than leave it orphaned
+		Pdeathsig: syscall.SIGKILL,
+	}
+
+	// Stage 2 hands us a listener inside its network namespace and we serve
+	// the proxy on it from the host side
+	var proxyHandoff, proxyHandoffChild *os.File
+	if spec.Proxy != nil {
+		proxyHandoff, proxyHandoffChild, err = newProxyHandoff()
+		if err != nil {
+			_ = specReader.Close()
+			return err
+		}
+		cmd.ExtraFiles = append(cmd.ExtraFiles, proxyHandoffChild)
+	}
+
+	// With Init the jail gets its own process group, so that signals from the
+	// terminal reach it once rather than also through forwardSignals, and takes
+	// over the terminal; take it back afterwards
+	if spec.Init {
+		cmd.SysProcAttr.Setpgid = true
+		if tty, ok := terminal(stdio.Stdin); ok {
+			cmd.SysProcAttr.Foreground = true
+			cmd.SysProcAttr.Ctty = int(tty.Fd())
+		}
+		defer foregroundKeeper(stdio.Stdin)()
+	}
+
+	err = cmd.Start()
+	_ = specReader.Close()
+	if proxyHandoffChild != nil {
+		_ = proxyHandoffChild.Close()
+	}
+	if err != nil {
+		if proxyHandoff != nil {
+			_ = proxyHandoff.Close()
+		}
+		return err
+	}
+
+	// A stage 2 that dies before reading the spec reports its own error
+	_, writeErr := specWriter.Write(data)
+	if closeErr := specWriter.Close(); writeErr == nil {
+		writeErr = closeErr
+	}
+	if writeErr != nil && !errors.Is(writeErr, syscall.EPIPE) {
+		fmt.Fprintf(stdio.Stderr, "Error: sending spec: %v\n", writeErr)
+	}
+
+	if proxyHandoff != nil {
+		if err := serveProxy(proxyHandoff, spec.Proxy); err != nil {
+			fmt.Fprintf(stdio.Stderr, "Error: starting egress proxy: %v\n", err)
+		}
+	}
+
+	stop := forwardSignals(cmd.Process, !spec.Init)
+	err = cmd.Wait()
+	if sig := stop(); sig != 0 && err != nil {
+		return &signalError{sig: sig, err: err}
+	}
+	return err
+}
+
+// stage1Signals are the signals Run forwards to the jail
+var stage1Signals = []os.Signal{syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// terminalSignals are the signals a terminal sends to its whole foreground
+// process group
+var terminalSignals = []os.Signal{syscall.SIGINT, syscall.SIGQUIT}
+
+// forwardSignals passes the signals in stage1Signals on to process until the
+// returned function is called. If process is the command as PID 1, which
+// ignores signals it has no handler for, it is killed for those instead and
+// stop returns the signal. Terminal signals it handles are not passed on, as
+// it shares our process group and got them from the terminal already.
+func forwardSignals(process *os.Process, pid1 bool) (stop func() syscall.Signal) {
+	signals := make(chan os.Signal, len(stage1Signals))
+	signal.Notify(signals, stage1Signals...)
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	var killedBy syscall.Signal
+	go func() {
+		defer close(exited)
+		for {
+			select {
+			case sig := <-signals:
+				// The jail may have exited in the meantime
+				switch handled := handlesSignal(process.Pid, sig); {
+				case !pid1 || handled && !slices.Contains(terminalSignals, sig):
+					_ = process.Signal(sig)
+				case !handled:
+					killedBy = sig.(syscall.Signal)
+					_ = process.Kill()
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() syscall.Signal {
+		signal.Stop(signals)
+		close(done)
+		<-exited
+		return killedBy
+	}
+}
+
+// handlesSignal reports whether process pid has a handler installed for sig
+func handlesSignal(pid int, sig os.Signal) bool {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return false
+	}
+	for line := range strings.Lines(string(data)) {
+		mask, ok := strings.CutPrefix(line, "SigCgt:")
+		if !ok {
+			continue
+		}
+		caught, err := strconv.ParseUint(strings.TrimSpace(mask), 16, 64)
+		if err != nil {
+			return false
+		}
+		return caught&(1<<(sig.(syscall.Signal)-1)) != 0
+	}
+	return false
+}
+
+// signalError is the error Run returns when it killed the jail for a signal
+// the command does not handle
+type signalError struct {
+	sig syscall.Signal
+	err error
+}
+
+func (e *signalError) Error() string {
+	return "signal: " + e.sig.String()
+}
+
+func (e *signalError) Unwrap() error {
+	return e.err
+}
+
+// ExitStatus returns the exit status a shell reports for a command Run
+// returned err for: 0 for nil, the exit code of the command, or 128 plus the
+// signal number if it was killed by a signal. ok is false if err does not
+// come from the command's exit.
+func ExitStatus(err error) (status int, ok bool) {
+	if err == nil {
+		return 0, true
+	}
+	var sigErr *signalError
+	if errors.As(err, &sigErr) {
+		return 128 + int(sigErr.sig), true
+	}
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return 0, false
+	}
+	ws, isWaitStatus := exitErr.Sys().(syscall.WaitStatus)
+	if !isWaitStatus {
+		return exitErr.ExitCode(), true
+	}
+	return exitStatus(ws), true
+}
+
+// Init sets up the jail and execs its command when the program was started by
+// Run, and returns immediately otherwise. In a jail it does not return: it
+// exits with status 1 if the jail cannot be set up.
+func Init() {
+	if len(os.Args) != 1 || os.Args[0] != stage2Name {
+		return
+	}
+	err := setupAndExec(os.NewFile(specFd, "jail-spec"))
+	fmt.Fprintf(os.Stderr, "Setup error: %v\n", err)
+	os.Exit(1)
+}
+
+// readStage2 reads what Run sent over the spec pipe and closes it, so the
+// descriptor does not reach the jailed command
+func readStage2(pipe *os.File) (*stage2, error) {
+	defer pipe.Close() //nolint:errcheck // Only read once
+
+	var s stage2
+	if err := json.NewDecoder(pipe).Decode(&s); err != nil {
+		return nil, fmt.Errorf("reading spec: %w", err)
+	}
+	if s.Spec == nil {
+		return nil, errors.New("reading spec: no spec sent")
+	}
+	return &s, nil
+}
+
+// setupAndExec builds the jail described by the stage2 data read from pipe
+// inside the new namespaces and execs its command. It only returns on error.
+func setupAndExec(pipe *os.File) error {
+	s, err := readStage2(pipe)
+	if err != nil {
+		return err
+	}
+	spec := s.Spec
+
+	// Critical: Make all mounts private to prevent propagation issues
+	if err := syscall.Mount("", "/", "", syscall.MS_PRIVATE|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("making root mount private: %w", err)
+	}
+
+	// Build the jail root on a tmpfs inside this mount namespace. Mount points and
+	// stub files exist only in memory and vanish with the jail. From here on host
+	// paths are reached through hostPath until the old root is detached.
+	if err := pivotToTmpfsRoot(); err != nil {
+		return err
+	}
+
+	// Our own network namespace starts with loopback down
+	if slices.Contains(spec.Namespaces, "net") {
+		if err := setupLoopback(); err != nil {
+			return fmt.Errorf("setting up network: %w", err)
+		}
+	}
+	if s.Proxy {
+		if err := handOverProxyListener(); err != nil {
+			return fmt.Errorf("setting up egress proxy: %w", err)
+		}
+	}
+
+	if err := mountSpec(spec, "/"); err != nil {
+		return err
+	}
+
+	// Drop the host filesystem from this mount namespace
+	if err := detachOldRoot(); err != nil {
+		return err
+	}
+
+	if err := os.Chdir(spec.Workspace); err != nil {
+		return fmt.Errorf("chdir to %s: %w", spec.Workspace, err)
+	}
+
+	// Before the seccomp filter, which may not allow prctl
+	if spec.Init {
+		if err := becomeSubreaper(); err != nil {
+			return err
+		}
+	}
+
+	// Landlock applies to the calling thread only, so set it up on the thread
+	// that execs the command. The command cannot ptrace the other threads of an
+	// init process, which Landlock leaves out of its domain.
+	runtime.LockOSThread()
+	if err := applyLimits(spec.Limits); err != nil {
+		return err
+	}
+	if _, err := applyLandlock(spec.landlockRules()); err != nil {
+		return err
+	}
+	if s.Filter != nil {
+		if err := installSeccompFilter(s.Filter); err != nil {
+			return err
+		}
+	}
+
+	if spec.Init {
+		return runInit(spec)
+	}
+	if err := syscall.Exec(spec.Command, spec.Args, spec.commandEnv()); err != nil {
+		return fmt.Errorf("exec %s: %w", spec.Args[0], err)
+	}
+	return nil
+}
+
+// commandEnv returns the environment of the calling process with the
+// variables of the spec set
+func (s *Spec) commandEnv() []string {
+	env := slices.DeleteFunc(os.Environ(), func(e string) bool {
+		name, _, _ := strings.Cut(e, "=")
+		return slices.ContainsFunc(s.Env, func(set string) bool { return strings.HasPrefix(set, name+"=") })
+	})
+	return append(env, s.Env...)
+}
@@ -0,0 +1,262 @@
+package jail
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// validSpec returns a spec that passes Validate
+func validSpec() *Spec {
+	return &Spec{
+		Namespaces: []string{"mount", "user", "pid", "net"},
+		Mounts: []Mount{
+			{Type: MountTmpfs, Target: "/tmp", Options: []string{"size=1g"}},
+			{Type: MountBind, Source: "/usr", Target: "/usr", Options: []string{"ro", "nosuid"}},
+			{Type: MountBind, Source: "/missing", Target: "relative", Options: []string{"bogus"}, Skipped: "does not exist"},
+			{Type: MountProc, Target: "/proc"},
+			{Type: MountDev, Target: "/dev"},
+		},
+		Devices:   []string{"/dev/null"},
+		Workspace: "/workspace/app",
+		Command:   "/usr/bin/make",
+		Args:      []string{"make", "test"},
+		Limits:    map[string]uint64{"nofile": 1024},
+		Seccomp:   SeccompDefault,
+	}
+}
+
+// TestSpecValidate tests the problems Validate reports before a jail is started
+func TestSpecValidate(t *testing.T) {
+	t.Run("valid spec", func(t *testing.T) {
+		assert.NoError(t, validSpec().Validate())
+	})
+
+	tests := []struct {
+		name   string
+		change func(s *Spec)
+		want   string
+	}{
+		{"unknown namespace", func(s *Spec) { s.Namespaces = append(s.Namespaces, "cgroup") }, `unknown namespace "cgroup"`},
+		{"missing user namespace", func(s *Spec) { s.Namespaces = []string{"mount"} }, "the user namespace is required"},
+		{"proxy without network namespace", func(s *Spec) { s.Namespaces = []string{"mount", "user"}; s.Proxy = http.NotFoundHandler() }, "requires the net namespace"},
+		{"relative target", func(s *Spec) { s.Mounts = append(s.Mounts, Mount{Type: MountTmpfs, Target: "tmp"}) }, "not an absolute path"},
+		{"unknown mount type", func(s *Spec) { s.Mounts = append(s.Mounts, Mount{Type: "nfs", Target: "/mnt"}) }, `unknown type "nfs"`},
+		{"unknown bind option", func(s *Spec) { s.Mounts[1].Options = []string{"exec-please"} }, "unknown mount option"},
+		{"device outside /dev", func(s *Spec) { s.Devices = []string{"/etc/passwd"} }, "not below /dev"},
+		{"unknown limit", func(s *Spec) { s.Limits = map[string]uint64{"stack": 1} }, `unknown resource limit "stack"`},
+		{"no command", func(s *Spec) { s.Args = nil }, "no command specified"},
+		{"missing seccomp profile", func(s *Spec) { s.Seccomp = "/nonexistent/profile.json" }, "profile.json"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := validSpec()
+			tt.change(s)
+
+			err := s.Validate()
+
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
+
+// TestSpecCloneFlags tests the clone flags of the namespaces of a spec
+func TestSpecCloneFlags(t *testing.T) {
+	assert.Equal(t, uintptr(syscall.CLONE_NEWNS|syscall.CLONE_NEWUSER), (&Spec{Namespaces: []string{"mount", "user"}}).cloneFlags())
+	assert.NotZero(t, validSpec().cloneFlags()&syscall.CLONE_NEWNET)
+}
+
+// TestSpecIDMaps tests that empty id maps make the current user root in the jail
+func TestSpecIDMaps(t *testing.T) {
+	t.Run("defaults", func(t *testing.T) {
+		uid, gid := (&Spec{}).idMaps()
+
+		assert.Equal(t, []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}, uid)
+		assert.Equal(t, []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}, gid)
+	})
+
+	t.Run("explicit maps", func(t *testing.T) {
+		uid, _ := (&Spec{UIDMap: IDMap{Container: 1000, Host: 1000, Size: 1}}).idMaps()
+
+		assert.Equal(t, []syscall.SysProcIDMap{{ContainerID: 1000, HostID: 1000, Size: 1}}, uid)
+	})
+}
+
+// TestSpecCommandEnv tests that the variables of a spec override the inherited environment
+func TestSpecCommandEnv(t *testing.T) {
+	t.Setenv("JAIL_TEST_KEEP", "host")
+	t.Setenv("JAIL_TEST_SET", "host")
+
+	env := (&Spec{Env: []string{"JAIL_TEST_SET=jail", "JAIL_TEST_NEW=1"}}).commandEnv()
+
+	assert.Contains(t, env, "JAIL_TEST_KEEP=host")
+	assert.Contains(t, env, "JAIL_TEST_SET=jail")
+	assert.Contains(t, env, "JAIL_TEST_NEW=1")
+	assert.NotContains(t, env, "JAIL_TEST_SET=host")
+}
+
+// TestReadStage2 tests that a spec survives the trip from Run to Init over the spec pipe
+func TestReadStage2(t *testing.T) {
+	send := func(t *testing.T, data []byte) *os.File {
+		t.Helper()
+		r, w, err := os.Pipe()
+		require.NoError(t, err)
+		_, err = w.Write(data)
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+		return r
+	}
+
+	t.Run("spec, filter and proxy", func(t *testing.T) {
+		s := validSpec()
+		s.Proxy = http.NotFoundHandler()
+		filter := []syscall.SockFilter{{Code: 0x6, K: 0x7fff0000}}
+		data, err := json.Marshal(stage2{Spec: s, Filter: filter, Proxy: true})
+		require.NoError(t, err)
+
+		decoded, err := readStage2(send(t, data))
+
+		require.NoError(t, err)
+		assert.True(t, decoded.Proxy)
+		assert.Equal(t, filter, decoded.Filter)
+		assert.Nil(t, decoded.Spec.Proxy, "the proxy runs in stage 1 only")
+		s.Proxy = nil
+		assert.Equal(t, s, decoded.Spec)
+		assert.Contains(t, string(data), `"uid_map"`)
+	})
+
+	t.Run("stage 1 sent nothing", func(t *testing.T) {
+		_, err := readStage2(send(t, nil))
+
+		assert.ErrorContains(t, err, "reading spec")
+	})
+
+	t.Run("no spec", func(t *testing.T) {
+		_, err := readStage2(send(t, []byte(`{"proxy": false}`)))
+
+		assert.ErrorContains(t, err, "no spec sent")
+	})
+}
+
+// TestInit tests that Init returns in a program not started by Run
+func TestInit(t *testing.T) {
+	require.NotEqual(t, stage2Name, os.Args[0])
+
+	Init()
+}
+
+// TestRunExitStatus tests the exit status reported for errors returned by Run
+func TestRunExitStatus(t *testing.T) {
+	run := func(t *testing.T, script string) error {
+		t.Helper()
+		return exec.Command("sh", "-c", script).Run()
+	}
+
+	t.Run("success", func(t *testing.T) {
+		status, ok := ExitStatus(nil)
+
+		assert.True(t, ok)
+		assert.Equal(t, 0, status)
+	})
+
+	t.Run("exit code", func(t *testing.T) {
+		status, ok := ExitStatus(run(t, "exit 3"))
+
+		assert.True(t, ok)
+		assert.Equal(t, 3, status)
+	})
+
+	t.Run("killed by signal", func(t *testing.T) {
+		status, ok := ExitStatus(run(t, "kill -TERM $$"))
+
+		assert.True(t, ok)
+		assert.Equal(t, 143, status)
+	})
+
+	t.Run("other error", func(t *testing.T) {
+		_, ok := ExitStatus(errors.New("starting stage 2: boom"))
+
+		assert.False(t, ok)
+	})
+}
+
+// TestForwardSignals tests that signals sent to the calling process reach the jail
+func TestForwardSignals(t *testing.T) {
+	start := func(t *testing.T, script string) *exec.Cmd {
+		t.Helper()
+		cmd := exec.Command("sh", "-c", script)
+		require.NoError(t, cmd.Start())
+		time.Sleep(200 * time.Millisecond) // let the shell install its traps
+		return cmd
+	}
+
+	t.Run("handled signal is forwarded", func(t *testing.T) {
+		cmd := start(t, "trap 'exit 42' USR1; while :; do sleep 0.05; done")
+		stop := forwardSignals(cmd.Process, true)
+
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR1))
+		err := cmd.Wait()
+
+		status, ok := ExitStatus(err)
+		assert.True(t, ok)
+		assert.Equal(t, 42, status)
+		assert.Zero(t, stop())
+	})
+
+	t.Run("init process gets unhandled signals", func(t *testing.T) {
+		cmd := start(t, "trap 'exit 42' USR1; while :; do sleep 0.05; done")
+		stop := forwardSignals(cmd.Process, false)
+
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR2))
+		err := cmd.Wait()
+
+		status, ok := ExitStatus(err)
+		assert.True(t, ok)
+		assert.Equal(t, 128+int(syscall.SIGUSR2), status)
+		assert.Zero(t, stop())
+	})
+
+	t.Run("PID 1 command is killed for unhandled signals", func(t *testing.T) {
+		cmd := start(t, "while :; do sleep 0.05; done")
+		stop := forwardSignals(cmd.Process, true)
+
+		require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGUSR2))
+		err := cmd.Wait()
+		sig := stop()
+
+		assert.Equal(t, syscall.SIGUSR2, sig)
+		status, ok := ExitStatus(&signalError{sig: sig, err: err})
+		assert.True(t, ok)
+		assert.Equal(t, 128+int(syscall.SIGUSR2), status)
+	})
+}
+
+// TestHandlesSignal tests reading the signals a process has handlers for
+func TestHandlesSignal(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "trap 'exit 42' USR1; while :; do sleep 0.05; done")
+	require.NoError(t, cmd.Start())
+	defer func() {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+	}()
+	time.Sleep(200 * time.Millisecond) // let the shell install its trap
+
+	handlesUSR1 := handlesSignal(cmd.Process.Pid, syscall.SIGUSR1)
+	handlesUSR2 := handlesSignal(cmd.Process.Pid, syscall.SIGUSR2)
+
+	assert.True(t, handlesUSR1)
+	assert.False(t, handlesUSR2)
+}
+
+// TestLimitNames tests that the resource limit names are sorted
+func TestLimitNames(t *testing.T) {
+	assert.Equal(t, []string{"cpu", "fsize", "memory", "nofile", "nproc"}, LimitNames())
+}
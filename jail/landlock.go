@@ -0,0 +1,199 @@
+package jail
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Landlock syscalls share their numbers across architectures
+const (
+	sysLandlockCreateRuleset = 444
+	sysLandlockAddRule       = 445
+	sysLandlockRestrictSelf  = 446
+
+	landlockCreateRulesetVersion = 1 // LANDLOCK_CREATE_RULESET_VERSION
+	landlockRulePathBeneath      = 1 // LANDLOCK_RULE_PATH_BENEATH
+
+	oPath = 0x200000 // O_PATH, missing from the syscall package
+)
+
+// Landlock filesystem access rights (LANDLOCK_ACCESS_FS_*)
+const (
+	landlockExecute    = 1 << 0
+	landlockWriteFile  = 1 << 1
+	landlockReadFile   = 1 << 2
+	landlockReadDir    = 1 << 3
+	landlockRemoveDir  = 1 << 4
+	landlockRemoveFile = 1 << 5
+	landlockMakeChar   = 1 << 6
+	landlockMakeDir    = 1 << 7
+	landlockMakeReg    = 1 << 8
+	landlockMakeSock   = 1 << 9
+	landlockMakeFifo   = 1 << 10
+	landlockMakeBlock  = 1 << 11
+	landlockMakeSym    = 1 << 12
+	landlockRefer      = 1 << 13 // ABI 2
+	landlockTruncate   = 1 << 14 // ABI 3
+	landlockIoctlDev   = 1 << 15 // ABI 5
+
+	landlockAccessABI1 = landlockRefer - 1 // every right up to MakeSym
+)
+
+// Access rights granted to the different kinds of jail paths
+const (
+	landlockAccessRead  = landlockReadFile | landlockReadDir
+	landlockAccessWrite = landlockWriteFile | landlockRemoveDir | landlockRemoveFile | landlockMakeDir |
+		landlockMakeReg | landlockMakeSock | landlockMakeFifo | landlockMakeSym | landlockRefer | landlockTruncate
+	landlockAccessDev  = landlockAccessRead | landlockWriteFile | landlockIoctlDev
+	landlockAccessProc = landlockAccessRead | landlockWriteFile
+
+	// Rights that apply to files rather than directories
+	landlockAccessFile = landlockExecute | landlockWriteFile | landlockReadFile | landlockTruncate | landlockIoctlDev
+)
+
+// landlockHandledAccess returns the filesystem rights a ruleset handles for a
+// Landlock ABI version. Handled rights are denied unless a rule grants them.
+func landlockHandledAccess(abi int) uint64 {
+	handled := uint64(landlockAccessABI1)
+	if abi >= 2 { //nolint:mnd // Landlock ABI versions
+		handled |= landlockRefer
+	}
+	if abi >= 3 { //nolint:mnd // Landlock ABI versions
+		handled |= landlockTruncate
+	}
+	if abi >= 5 { //nolint:mnd // Landlock ABI versions
+		handled |= landlockIoctlDev
+	}
+	return handled
+}
+
+// landlockRule grants access to everything beneath a path inside the jail
+type landlockRule struct {
+	path   string
+	access uint64
+}
+
+// mountAccess returns the Landlock rights matching a mount's options
+func mountAccess(m mountOptions) uint64 {
+	access := uint64(landlockAccessRead)
+	if !m.noExec {
+		access |= landlockExecute
+	}
+	if !m.readOnly {
+		access |= landlockAccessWrite
+	}
+	return access
+}
+
+// LandlockABI returns the Landlock ABI version supported by the running
+// kernel, or 0 with the reason when Landlock is unavailable
+func LandlockABI() (int, error) {
+	abi, _, errno := syscall.Syscall(sysLandlockCreateRuleset, 0, 0, landlockCreateRulesetVersion)
+	switch {
+	case errno == syscall.ENOSYS:
+		return 0, errors.New("kernel built without Landlock")
+	case errno == syscall.EOPNOTSUPP:
+		return 0, errors.New("Landlock disabled at boot (add landlock to the lsm= kernel parameter)") //nolint:staticcheck // Landlock is a name
+	case errno != 0:
+		return 0, errno
+	}
+	return int(abi), nil
+}
+
+// applyLandlock restricts the calling thread, and the program it execs, to the
+// given rules. Paths that do not exist are skipped. It returns the ABI version
+// enforced, or 0 without error when the kernel does not support Landlock.
+// The caller must hold the thread (runtime.LockOSThread) until it execs.
+func applyLandlock(rules []landlockRule) (int, error) {
+	// Older kernels run without Landlock; LandlockABI tells why
+	abi, _ := LandlockABI()
+	if abi == 0 {
+		return 0, nil
+	}
+
+	handled := landlockHandledAccess(abi)
+	attr := struct{ handledAccessFS uint64 }{handled}
+	//nolint:gosec // landlock_create_ruleset takes a pointer to struct landlock_ruleset_attr
+	fd, _, errno := syscall.Syscall(sysLandlockCreateRuleset, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return 0, fmt.Errorf("creating landlock ruleset: %w", errno)
+	}
+	rulesetFd := int(fd)
+	defer func() { _ = syscall.Close(rulesetFd) }()
+
+	for _, rule := range rules {
+		if err := addLandlockRule(rulesetFd, rule, handled); err != nil {
+			return 0, err
+		}
+	}
+
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return 0, fmt.Errorf("setting no_new_privs: %w", errno)
+	}
+	if _, _, errno := syscall.Syscall(sysLandlockRestrictSelf, uintptr(rulesetFd), 0, 0); errno != 0 {
+		return 0, fmt.Errorf("enforcing landlock ruleset: %w", errno)
+	}
+
+	return abi, nil
+}
+
+// addLandlockRule adds a path beneath rule, limited to the rights the ruleset handles
+func addLandlockRule(rulesetFd int, rule landlockRule, handled uint64) error {
+	fd, err := syscall.Open(rule.path, oPath|syscall.O_CLOEXEC, 0)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("opening %s for landlock: %w", rule.path, err)
+	}
+	defer func() { _ = syscall.Close(fd) }()
+
+	access := rule.access & handled
+	var st syscall.Stat_t
+	if err := syscall.Fstat(fd, &st); err != nil {
+		return fmt.Errorf("stat %s for landlock: %w", rule.path, err)
+	}
+	if st.Mode&syscall.S_IFMT != syscall.S_IFDIR {
+		access &= landlockAccessFile
+	}
+
+	// struct landlock_path_beneath_attr is packed: the kernel reads the first 12 bytes
+	attr := struct {
+		allowedAccess uint64
+		parentFd      int32
+	}{access, int32(fd)} //nolint:gosec // file descriptors fit in int32
+	//nolint:gosec // landlock_add_rule takes a pointer to struct landlock_path_beneath_attr
+	if _, _, errno := syscall.Syscall6(sysLandlockAddRule, uintptr(rulesetFd), landlockRulePathBeneath,
+		uintptr(unsafe.Pointer(&attr)), 0, 0, 0); errno != 0 {
+		return fmt.Errorf("adding landlock rule for %s: %w", rule.path, errno)
+	}
+
+	return nil
+}
+
+// landlockRules returns the Landlock rules for every path the jailed process may use
+func (s *Spec) landlockRules() []landlockRule {
+	// Listing the jail root only shows what was mounted into it
+	rules := []landlockRule{
+		{path: "/", access: landlockReadDir},
+		{path: "/proc", access: landlockAccessProc},
+		{path: "/dev", access: landlockAccessDev},
+		{path: "/dev/shm", access: mountAccess(mountOptions{})},
+	}
+	for _, m := range s.Mounts {
+		if m.Skipped != "" {
+			continue
+		}
+		switch m.Type {
+		case MountTmpfs, MountOverlay:
+			rules = append(rules, landlockRule{path: m.Target, access: mountAccess(mountOptions{})})
+		case MountBind:
+			options, _ := parseMountOptions(m.Options)
+			rules = append(rules, landlockRule{path: m.Target, access: mountAccess(options)})
+		}
+	}
+	return rules
+}
@@ -0,0 +1,75 @@
+package jail
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestLandlockHandledAccess tests which rights are handled for each Landlock ABI
+func TestLandlockHandledAccess(t *testing.T) {
+	t.Run("ABI 1 handles the original rights", func(t *testing.T) {
+		handled := landlockHandledAccess(1)
+
+		assert.NotZero(t, handled&landlockExecute)
+		assert.NotZero(t, handled&landlockMakeSym)
+		assert.Zero(t, handled&landlockRefer)
+		assert.Zero(t, handled&landlockTruncate)
+	})
+
+	t.Run("later ABIs add refer, truncate and device ioctls", func(t *testing.T) {
+		assert.NotZero(t, landlockHandledAccess(2)&landlockRefer)
+		assert.Zero(t, landlockHandledAccess(2)&landlockTruncate)
+		assert.NotZero(t, landlockHandledAccess(3)&landlockTruncate)
+		assert.Zero(t, landlockHandledAccess(4)&landlockIoctlDev)
+		assert.NotZero(t, landlockHandledAccess(7)&landlockIoctlDev)
+	})
+}
+
+// TestMountAccess tests the Landlock rights derived from mount options
+func TestMountAccess(t *testing.T) {
+	t.Run("read-only mount", func(t *testing.T) {
+		access := mountAccess(mountOptions{readOnly: true})
+
+		assert.Equal(t, uint64(landlockAccessRead|landlockExecute), access)
+	})
+
+	t.Run("read-write mount", func(t *testing.T) {
+		access := mountAccess(mountOptions{})
+
+		assert.NotZero(t, access&landlockWriteFile)
+		assert.NotZero(t, access&landlockMakeDir)
+		assert.NotZero(t, access&landlockExecute)
+		assert.Zero(t, access&landlockMakeChar, "device nodes are never allowed")
+	})
+
+	t.Run("noexec mount", func(t *testing.T) {
+		access := mountAccess(mountOptions{readOnly: true, noExec: true})
+
+		assert.Equal(t, uint64(landlockAccessRead), access)
+	})
+}
+
+// TestSpecLandlockRules tests the Landlock rules derived from the mounts of a spec
+func TestSpecLandlockRules(t *testing.T) {
+	s := &Spec{Mounts: []Mount{
+		{Type: MountTmpfs, Target: "/tmp"},
+		{Type: MountBind, Source: "/usr", Target: "/usr", Options: []string{"ro"}},
+		{Type: MountBind, Source: "/data", Target: "/data", Options: []string{"rw", "noexec"}},
+		{Type: MountBind, Source: "/missing", Target: "/missing", Skipped: "does not exist"},
+		{Type: MountMask, Target: "/workspace/app/.env", File: true},
+		{Type: MountProc, Target: "/proc"},
+	}}
+
+	rules := s.landlockRules()
+
+	assert.Equal(t, []landlockRule{
+		{path: "/", access: landlockReadDir},
+		{path: "/proc", access: landlockAccessProc},
+		{path: "/dev", access: landlockAccessDev},
+		{path: "/dev/shm", access: mountAccess(mountOptions{})},
+		{path: "/tmp", access: mountAccess(mountOptions{})},
+		{path: "/usr", access: mountAccess(mountOptions{readOnly: true})},
+		{path: "/data", access: mountAccess(mountOptions{noExec: true})},
+	}, rules)
+}
@@ -0,0 +1,49 @@
+package jail
+
+import (
+	"fmt"
+	"sort"
+	"syscall"
+)
+
+// rlimitNproc is RLIMIT_NPROC, which the syscall package does not define
+const rlimitNproc = 6
+
+// resourceLimits maps the names of Spec.Limits to rlimits
+var resourceLimits = map[string]int{
+	"nofile": syscall.RLIMIT_NOFILE, // open files
+	"nproc":  rlimitNproc,           // processes and threads of the jail user
+	"memory": syscall.RLIMIT_AS,     // address space in bytes
+	"cpu":    syscall.RLIMIT_CPU,    // CPU time in seconds
+	"fsize":  syscall.RLIMIT_FSIZE,  // size of files written, in bytes
+}
+
+// LimitNames returns the supported resource limit names in order
+func LimitNames() []string {
+	names := make([]string, 0, len(resourceLimits))
+	for name := range resourceLimits {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// applyLimits sets the soft and hard rlimits of the current process, which the
+// jailed command inherits. Limits cannot be raised above the current hard limit.
+func applyLimits(limits map[string]uint64) error {
+	for _, name := range LimitNames() {
+		value, ok := limits[name]
+		if !ok {
+			continue
+		}
+		limit := syscall.Rlimit{Cur: value, Max: value}
+		if err := syscall.Setrlimit(resourceLimits[name], &limit); err != nil {
+			var current syscall.Rlimit
+			if syscall.Getrlimit(resourceLimits[name], &current) == nil && value > current.Max {
+				return fmt.Errorf("setting %s limit to %d: above the hard limit %d", name, value, current.Max)
+			}
+			return fmt.Errorf("setting %s limit: %w", name, err)
+		}
+	}
+	return nil
+}
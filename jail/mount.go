@@ -0,0 +1,396 @@
+package jail
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// rootTmpfsSize is the size of the tmpfs the jail root and /dev/shm are built on
+const rootTmpfsSize = "1g"
+
+// mountOptions are the options of a bind mount
+type mountOptions struct {
+	readOnly bool
+	noExec   bool
+	noSuid   bool
+	noDev    bool
+}
+
+// parseMountOptions parses bind mount options: "ro" or "rw" (the default),
+// "noexec", "nosuid" and "nodev"
+func parseMountOptions(options []string) (mountOptions, error) {
+	var o mountOptions
+	for _, option := range options {
+		switch option {
+		case "ro":
+			o.readOnly = true
+		case "rw":
+			o.readOnly = false
+		case "noexec":
+			o.noExec = true
+		case "nosuid":
+			o.noSuid = true
+		case "nodev":
+			o.noDev = true
+		default:
+			return o, fmt.Errorf("unknown mount option %q", option)
+		}
+	}
+	return o, nil
+}
+
+// flags returns the mount flags to apply when remounting the bind mount
+func (o mountOptions) flags() uintptr {
+	var flags uintptr
+	if o.readOnly {
+		flags |= syscall.MS_RDONLY
+	}
+	if o.noExec {
+		flags |= syscall.MS_NOEXEC
+	}
+	if o.noSuid {
+		flags |= syscall.MS_NOSUID
+	}
+	if o.noDev {
+		flags |= syscall.MS_NODEV
+	}
+	return flags
+}
+
+// option returns the value of a "name=value" option of m
+func (m Mount) option(name string) string {
+	for _, o := range m.Options {
+		if value, ok := strings.CutPrefix(o, name+"="); ok {
+			return value
+		}
+	}
+	return ""
+}
+
+// statfs f_flags values (ST_*) that correspond to mount flags locked by the kernel
+// when a mount is inherited into an unprivileged user namespace
+const (
+	stNoAtime     = 0x400
+	stNoDirAtime  = 0x800
+	stRelAtime    = 0x1000
+	stMountLocked = syscall.MS_RDONLY | syscall.MS_NOSUID | syscall.MS_NODEV | syscall.MS_NOEXEC
+)
+
+// lockedMountFlags returns the flags of the mount containing path that cannot be
+// cleared from inside a user namespace. A bind remount has to repeat them or the
+// kernel rejects it with EPERM.
+func lockedMountFlags(path string) (uintptr, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+
+	// The low ST_* bits share their values with the MS_* flags
+	flags := uintptr(st.Flags) & stMountLocked
+	if st.Flags&stNoAtime != 0 {
+		flags |= syscall.MS_NOATIME
+	}
+	if st.Flags&stNoDirAtime != 0 {
+		flags |= syscall.MS_NODIRATIME
+	}
+	if st.Flags&stRelAtime != 0 {
+		flags |= syscall.MS_RELATIME
+	}
+	return flags, nil
+}
+
+// bindMount bind mounts source, as reachable from the current root, onto
+// target and applies the mount flags of the options. name is the path used in
+// errors.
+func bindMount(name, source, target string, o mountOptions) error {
+	if err := syscall.Mount(source, target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+		return fmt.Errorf("bind mounting %s: %w", name, err)
+	}
+
+	flags := o.flags()
+	if flags == 0 {
+		// Plain read-write bind mount, nothing to remount
+		return nil
+	}
+
+	locked, err := lockedMountFlags(target)
+	if err != nil {
+		return fmt.Errorf("reading mount flags of %s: %w", name, err)
+	}
+
+	if err := syscall.Mount("", target, "", syscall.MS_BIND|syscall.MS_REMOUNT|syscall.MS_REC|flags|locked, ""); err != nil {
+		return fmt.Errorf("remounting %s with options: %w", name, err)
+	}
+
+	return nil
+}
+
+// oldRootDir is where the host root stays reachable while the jail root is built
+const oldRootDir = "/.oldroot"
+
+// pivotToTmpfsRoot makes a fresh tmpfs the root directory and moves the host
+// root to oldRootDir, so the jail is assembled in memory and nothing is
+// written to the host. The tmpfs is mounted over /tmp temporarily; pivot_root
+// moves it away again, uncovering the host /tmp under oldRootDir.
+func pivotToTmpfsRoot() error {
+	if err := syscall.Mount("tmpfs", "/tmp", "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=0755,size="+rootTmpfsSize); err != nil {
+		return fmt.Errorf("mounting tmpfs for the jail root: %w", err)
+	}
+
+	putOld := filepath.Join("/tmp", oldRootDir)
+	if err := os.Mkdir(putOld, 0700); err != nil {
+		return fmt.Errorf("creating %s: %w", oldRootDir, err)
+	}
+	if err := syscall.PivotRoot("/tmp", putOld); err != nil {
+		return fmt.Errorf("pivot_root: %w", err)
+	}
+
+	if err := syscall.Chdir("/"); err != nil {
+		return fmt.Errorf("chdir to /: %w", err)
+	}
+	return nil
+}
+
+// maxSymlinks bounds symlink resolution in ResolveInRoot, like the kernel's ELOOP limit
+const maxSymlinks = 40
+
+// hostPath returns where a host path is reachable after pivotToTmpfsRoot
+func hostPath(path string) string {
+	return ResolveInRoot(oldRootDir, path)
+}
+
+// ResolveInRoot returns path below root with symlinks resolved as if root
+// were the root directory, since an absolute link such as /var/run -> /run
+// would otherwise point outside it. Paths that do not exist are returned as
+// far as they could be resolved.
+func ResolveInRoot(root, path string) string {
+	resolved := "/"
+	rest := strings.Split(filepath.Clean("/"+path), "/")
+	for links := 0; len(rest) > 0; {
+		name := rest[0]
+		rest = rest[1:]
+		if name == "" || name == "." {
+			continue
+		}
+
+		next := filepath.Join(resolved, name)
+		target, err := os.Readlink(filepath.Join(root, next))
+		if err != nil {
+			// Not a symlink, or missing
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return filepath.Join(root, next)
+		}
+		if filepath.IsAbs(target) {
+			resolved = "/"
+		}
+		rest = append(strings.Split(target, "/"), rest...)
+	}
+
+	return filepath.Join(root, resolved)
+}
+
+// detachOldRoot unmounts the host root once the jail root is complete. Unlike
+// chroot this leaves no path back to the host filesystem, even for a process
+// that may call chroot itself.
+func detachOldRoot() error {
+	if err := syscall.Unmount(oldRootDir, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("detaching old root: %w", err)
+	}
+	if err := os.Remove(oldRootDir); err != nil {
+		return fmt.Errorf("removing %s: %w", oldRootDir, err)
+	}
+	return nil
+}
+
+// mountTmpfs mounts a fresh tmpfs of the given size at target below root
+func mountTmpfs(root, target, size string) error {
+	dir := filepath.Join(root, target)
+	if err := os.MkdirAll(dir, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
+		return fmt.Errorf("creating tmpfs mount point %s: %w", target, err)
+	}
+
+	// World-writable with the sticky bit, like the host /tmp
+	if err := syscall.Mount("tmpfs", dir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV, "mode=1777,size="+size); err != nil {
+		return fmt.Errorf("mounting tmpfs on %s: %w", target, err)
+	}
+	return nil
+}
+
+// mountOverlay mounts an overlayfs of lower and upper at target. All paths must be
+// reachable from the current root and free of the separators overlayfs options use.
+func mountOverlay(lower, upper, work, target string) error {
+	for _, dir := range []string{lower, upper, work} {
+		if strings.ContainsAny(dir, ",:\\") {
+			return fmt.Errorf("cannot use %s in an overlay: path contains ',', ':' or '\\'", dir)
+		}
+	}
+
+	// userxattr keeps overlay metadata in user.* xattrs, which unprivileged mounts require
+	data := fmt.Sprintf("lowerdir=%s,upperdir=%s,workdir=%s,userxattr", lower, upper, work)
+	if err := syscall.Mount("overlay", target, "overlay", 0, data); err != nil {
+		return fmt.Errorf("mounting overlay workspace (requires Linux 5.11+): %w", err)
+	}
+	return nil
+}
+
+// maskSourceDir holds the empty file bound over masked files while they are mounted
+const maskSourceDir = "/.jail-mask"
+
+// maskPaths hides the masked paths below root: files are covered by an empty
+// read-only file and directories by an empty read-only tmpfs
+func maskPaths(root string, masks []Mount) error {
+	if len(masks) == 0 {
+		return nil
+	}
+
+	// The empty file lives on a throwaway tmpfs that is detached again once bound
+	if err := os.Mkdir(maskSourceDir, 0700); err != nil {
+		return fmt.Errorf("creating %s: %w", maskSourceDir, err)
+	}
+	if err := syscall.Mount("tmpfs", maskSourceDir, "tmpfs", syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "size=4k"); err != nil {
+		return fmt.Errorf("mounting tmpfs for masks: %w", err)
+	}
+	emptyFile := filepath.Join(maskSourceDir, "empty")
+	if err := os.WriteFile(emptyFile, nil, 0444); err != nil {
+		return fmt.Errorf("creating mask file: %w", err)
+	}
+
+	for _, m := range masks {
+		target := filepath.Join(root, m.Target)
+		var err error
+		if m.File {
+			err = bindMount(m.Target, emptyFile, target, mountOptions{readOnly: true, noExec: true})
+		} else {
+			err = syscall.Mount("tmpfs", target, "tmpfs", syscall.MS_RDONLY|syscall.MS_NOSUID|syscall.MS_NODEV|syscall.MS_NOEXEC, "size=4k,mode=0555")
+		}
+		if err != nil {
+			return fmt.Errorf("masking %s: %w", m.Target, err)
+		}
+	}
+
+	if err := syscall.Unmount(maskSourceDir, syscall.MNT_DETACH); err != nil {
+		return fmt.Errorf("detaching %s: %w", maskSourceDir, err)
+	}
+	if err := os.Remove(maskSourceDir); err != nil {
+		return fmt.Errorf("removing %s: %w", maskSourceDir, err)
+	}
+	return nil
+}
+
+// mountSpec makes the mounts of a spec below root, reaching host paths
+// through hostPath. Masks are made together, after the mounts before them.
+func mountSpec(s *Spec, root string) error {
+	var masks []Mount
+	for _, m := range s.Mounts {
+		if m.Skipped != "" {
+			if m.Optional {
+				fmt.Fprintf(os.Stderr, "Warning: %s not mounted: %s\n", m.Origin, m.Skipped)
+			}
+			continue
+		}
+		if m.Type == MountMask {
+			masks = append(masks, m)
+			continue
+		}
+		if err := maskPaths(root, masks); err != nil {
+			return err
+		}
+		masks = nil
+
+		err := mountEntry(s, m, root)
+		if err != nil && m.Optional {
+			fmt.Fprintf(os.Stderr, "Warning: %s not mounted: %v\n", m.Origin, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return maskPaths(root, masks)
+}
+
+// mountEntry makes a single mount of a spec below root
+func mountEntry(s *Spec, m Mount, root string) error {
+	target := filepath.Join(root, m.Target)
+	switch m.Type {
+	case MountTmpfs:
+		return mountTmpfs(root, m.Target, m.option("size"))
+	case MountOverlay:
+		if err := os.MkdirAll(target, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
+			return fmt.Errorf("creating mount point %s: %w", m.Target, err)
+		}
+		return mountOverlay(hostPath(m.Source), hostPath(m.option("upperdir")), hostPath(m.option("workdir")), target)
+	case MountProc:
+		if err := os.MkdirAll(target, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
+			return fmt.Errorf("creating %s: %w", m.Target, err)
+		}
+		if err := syscall.Mount("proc", target, "proc", 0, ""); err != nil {
+			return fmt.Errorf("mounting proc: %w", err)
+		}
+		return nil
+	case MountDev:
+		if err := os.MkdirAll(target, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
+			return fmt.Errorf("creating %s: %w", m.Target, err)
+		}
+		if m.Source == "" {
+			return setupPrivateDev(root, s.Devices)
+		}
+		if err := syscall.Mount(hostPath(m.Source), target, "", syscall.MS_BIND|syscall.MS_REC, ""); err != nil {
+			return fmt.Errorf("bind mounting %s: %w", m.Source, err)
+		}
+		return nil
+	case MountBind:
+		options, err := parseMountOptions(m.Options)
+		if err != nil {
+			return err
+		}
+		source := hostPath(m.Source)
+		if _, err := os.Lstat(source); os.IsNotExist(err) && m.Create {
+			if err := os.MkdirAll(source, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
+				return fmt.Errorf("creating %s: %w", m.Source, err)
+			}
+		}
+
+		// Files, such as sockets, are mounted over an empty file, unless the
+		// target exists, as a file of a read-only workspace does
+		if m.File {
+			if err := createFileTarget(target); err != nil {
+				return fmt.Errorf("creating mount point %s: %w", m.Target, err)
+			}
+		} else if err := os.MkdirAll(target, 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
+			return fmt.Errorf("creating mount point %s: %w", m.Target, err)
+		}
+		return bindMount(m.Source, source, target, options)
+	default:
+		return fmt.Errorf("unknown mount type %q", m.Type)
+	}
+}
+
+// createFileTarget creates an empty file at target to mount a file over,
+// leaving an existing target as it is
+func createFileTarget(target string) error {
+	if _, err := os.Lstat(target); err == nil {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil { //nolint:gosec,mnd // 0755 is appropriate for directory permissions
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// isSubPath reports whether path equals dir or lies beneath it
+func isSubPath(path, dir string) bool {
+	return path == dir || strings.HasPrefix(path, dir+"/")
+}
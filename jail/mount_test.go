@@ -0,0 +1,115 @@
+package jail
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TestParseMountOptions tests parsing the options of bind mounts
+func TestParseMountOptions(t *testing.T) {
+	t.Run("read-write by default", func(t *testing.T) {
+		o, err := parseMountOptions(nil)
+
+		require.NoError(t, err)
+		assert.Equal(t, mountOptions{}, o)
+	})
+
+	t.Run("later options override earlier ones", func(t *testing.T) {
+		o, err := parseMountOptions([]string{"ro", "noexec", "rw"})
+
+		require.NoError(t, err)
+		assert.Equal(t, mountOptions{noExec: true}, o)
+	})
+
+	t.Run("unknown option", func(t *testing.T) {
+		_, err := parseMountOptions([]string{"exec-please"})
+
+		assert.ErrorContains(t, err, "unknown mount option")
+	})
+}
+
+// TestMountOptionsFlags tests conversion of mount options to mount flags
+func TestMountOptionsFlags(t *testing.T) {
+	t.Run("read-write mount has no flags", func(t *testing.T) {
+		assert.Equal(t, uintptr(0), mountOptions{}.flags())
+	})
+
+	t.Run("read-only mount", func(t *testing.T) {
+		assert.Equal(t, uintptr(syscall.MS_RDONLY), mountOptions{readOnly: true}.flags())
+	})
+
+	t.Run("all options", func(t *testing.T) {
+		o := mountOptions{readOnly: true, noExec: true, noSuid: true, noDev: true}
+		expected := uintptr(syscall.MS_RDONLY | syscall.MS_NOEXEC | syscall.MS_NOSUID | syscall.MS_NODEV)
+
+		assert.Equal(t, expected, o.flags())
+	})
+}
+
+// TestResolveInRoot tests symlink resolution below a different root directory
+func TestResolveInRoot(t *testing.T) {
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "run", "user"), 0755))
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "home", "dev"), 0755))
+	require.NoError(t, os.Symlink("/run", filepath.Join(root, "var-run")))
+	require.NoError(t, os.Symlink("../run/user", filepath.Join(root, "home", "dev", "runtime")))
+	require.NoError(t, os.Symlink("loop", filepath.Join(root, "loop")))
+
+	t.Run("plain path", func(t *testing.T) {
+		assert.Equal(t, filepath.Join(root, "home", "dev"), ResolveInRoot(root, "/home/dev"))
+	})
+
+	t.Run("absolute symlink stays inside root", func(t *testing.T) {
+		assert.Equal(t, filepath.Join(root, "run", "docker.sock"), ResolveInRoot(root, "/var-run/docker.sock"))
+	})
+
+	t.Run("relative symlink", func(t *testing.T) {
+		assert.Equal(t, filepath.Join(root, "home", "run", "user"), ResolveInRoot(root, "/home/dev/runtime"))
+	})
+
+	t.Run("missing path", func(t *testing.T) {
+		assert.Equal(t, filepath.Join(root, "run", "missing", "x"), ResolveInRoot(root, "/var-run/missing/x"))
+	})
+
+	t.Run("symlink loop", func(t *testing.T) {
+		assert.Equal(t, filepath.Join(root, "loop"), ResolveInRoot(root, "/loop"))
+	})
+}
+
+// TestMountOverlayRejectsSeparators tests that option separators in paths are refused
+func TestMountOverlayRejectsSeparators(t *testing.T) {
+	err := mountOverlay("/work/a,b", "/upper", "/work", "/target")
+
+	assert.ErrorContains(t, err, "contains ','")
+}
+
+// TestCreateFileTarget tests creating the empty file a file mount is made over
+func TestCreateFileTarget(t *testing.T) {
+	root := t.TempDir()
+
+	t.Run("missing target is created", func(t *testing.T) {
+		target := filepath.Join(root, "run", "docker.sock")
+
+		err := createFileTarget(target)
+
+		require.NoError(t, err)
+		assert.FileExists(t, target)
+	})
+
+	t.Run("existing target is kept", func(t *testing.T) {
+		target := filepath.Join(root, "notes.txt")
+		require.NoError(t, os.WriteFile(target, []byte("draft\n"), 0644))
+
+		err := createFileTarget(target)
+
+		require.NoError(t, err)
+		content, err := os.ReadFile(target)
+		require.NoError(t, err)
+		assert.Equal(t, "draft\n", string(content))
+	})
+}
@@ -0,0 +1,40 @@
+package jail
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// ifreqFlags mirrors struct ifreq for the SIOCGIFFLAGS/SIOCSIFFLAGS ioctls
+type ifreqFlags struct {
+	name  [syscall.IFNAMSIZ]byte
+	flags uint16
+	_     [22]byte // pad to sizeof(struct ifreq)
+}
+
+// setupLoopback brings up the loopback interface of a freshly created network namespace
+func setupLoopback() error {
+	fd, err := syscall.Socket(syscall.AF_INET, syscall.SOCK_DGRAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("creating socket: %w", err)
+	}
+	defer syscall.Close(fd) //nolint:errcheck // Nothing useful to do if close fails
+
+	var ifr ifreqFlags
+	copy(ifr.name[:], "lo")
+
+	//nolint:gosec // ioctl requires passing a pointer to struct ifreq
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCGIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return fmt.Errorf("reading loopback flags: %w", errno)
+	}
+
+	ifr.flags |= syscall.IFF_UP | syscall.IFF_RUNNING
+
+	//nolint:gosec // ioctl requires passing a pointer to struct ifreq
+	if _, _, errno := syscall.Syscall(syscall.SYS_IOCTL, uintptr(fd), syscall.SIOCSIFFLAGS, uintptr(unsafe.Pointer(&ifr))); errno != 0 {
+		return fmt.Errorf("bringing up loopback: %w", errno)
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package jail
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"syscall"
+	"time"
+)
+
+// ProxyAddr is where Spec.Proxy listens inside the jail's network namespace
+const ProxyAddr = "127.0.0.1:3128"
+
+// proxyHandoffFd is the descriptor number of the Unix socket stage 2 uses to hand
+// the proxy listener to stage 1 (the entry of exec.Cmd.ExtraFiles after the spec pipe)
+const proxyHandoffFd = specFd + 1
+
+// proxyHeaderTimeout bounds how long a client may take to send request headers
+const proxyHeaderTimeout = 30 * time.Second
+
+// newProxyHandoff creates the socket pair used to pass the proxy listener from
+// stage 2 to stage 1. The second file is inherited by stage 2 as proxyHandoffFd.
+func newProxyHandoff() (parent, child *os.File, err error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM|syscall.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("creating proxy socket pair: %w", err)
+	}
+	return os.NewFile(uintptr(fds[0]), "proxy-handoff"), os.NewFile(uintptr(fds[1]), "proxy-handoff-child"), nil
+}
+
+// serveProxy waits for stage 2 to send the loopback listener over handoff and
+// then serves handler on it in the background
+func serveProxy(handoff *os.File, handler http.Handler) error {
+	defer handoff.Close() //nolint:errcheck // Only used to receive a single descriptor
+
+	conn, err := net.FileConn(handoff)
+	if err != nil {
+		return fmt.Errorf("opening proxy handoff socket: %w", err)
+	}
+	defer conn.Close() //nolint:errcheck // Only used to receive a single descriptor
+
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return fmt.Errorf("proxy handoff is not a unix socket")
+	}
+
+	buf := make([]byte, 1)
+	oob := make([]byte, syscall.CmsgSpace(4)) //nolint:mnd // one 32-bit file descriptor
+	n, oobn, _, _, err := unixConn.ReadMsgUnix(buf, oob)
+	if n == 0 && oobn == 0 {
+		// Stage 2 exited before handing over the listener and reports its own error
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("receiving proxy listener: %w", err)
+	}
+
+	msgs, err := syscall.ParseSocketControlMessage(oob[:oobn])
+	if err != nil || len(msgs) != 1 {
+		return fmt.Errorf("receiving proxy listener: no descriptor sent")
+	}
+	fds, err := syscall.ParseUnixRights(&msgs[0])
+	if err != nil || len(fds) != 1 {
+		return fmt.Errorf("receiving proxy listener: no descriptor sent")
+	}
+
+	listenerFile := os.NewFile(uintptr(fds[0]), "proxy-listener")
+	listener, err := net.FileListener(listenerFile)
+	_ = listenerFile.Close()
+	if err != nil {
+		return fmt.Errorf("opening proxy listener: %w", err)
+	}
+
+	server := &http.Server{
+		Handler:           handler,
+		ReadHeaderTimeout: proxyHeaderTimeout,
+	}
+	go server.Serve(listener) //nolint:errcheck // Serves until jail exits
+
+	return nil
+}
+
+// handOverProxyListener listens on ProxyAddr inside the jail's network namespace
+// and sends the listening socket to stage 1, which runs the proxy outside the jail
+func handOverProxyListener() error {
+	handoff := os.NewFile(proxyHandoffFd, "proxy-handoff")
+	defer handoff.Close() //nolint:errcheck // Must not leak into the jailed process
+
+	listener, err := net.Listen("tcp", ProxyAddr)
+	if err != nil {
+		return fmt.Errorf("listening on %s: %w", ProxyAddr, err)
+	}
+	defer listener.Close() //nolint:errcheck // Stage 1 holds its own copy
+
+	tcpListener, ok := listener.(*net.TCPListener)
+	if !ok {
+		return fmt.Errorf("unexpected listener type %T", listener)
+	}
+	listenerFile, err := tcpListener.File()
+	if err != nil {
+		return fmt.Errorf("getting proxy listener descriptor: %w", err)
+	}
+	defer listenerFile.Close() //nolint:errcheck // Stage 1 holds its own copy
+
+	rights := syscall.UnixRights(int(listenerFile.Fd()))
+	if err := syscall.Sendmsg(int(handoff.Fd()), []byte{0}, rights, nil, 0); err != nil {
+		return fmt.Errorf("sending proxy listener: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,440 @@
+package jail
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"slices"
+	"syscall"
+	"unsafe"
+)
+
+// Values of Spec.Seccomp besides a profile path
+const (
+	SeccompDefault    = "default"    // the built-in profile, see defaultSeccompProfile
+	SeccompUnconfined = "unconfined" // no syscall filter
+)
+
+// Limits and values of the kernel's seccomp and BPF interfaces
+const (
+	seccompMaxProgram      = 4096 // BPF_MAXINSNS
+	seccompMaxJump         = 255  // conditional jump offsets are 8 bits
+	prSetNoNewPrivs        = 38   // PR_SET_NO_NEW_PRIVS
+	seccompSetModeFilter   = 1    // SECCOMP_SET_MODE_FILTER
+	seccompFilterFlagTsync = 1    // SECCOMP_FILTER_FLAG_TSYNC
+	seccompDataNr          = 0    // offsetof(struct seccomp_data, nr)
+	seccompDataArch        = 4    // offsetof(struct seccomp_data, arch)
+	seccompDataArgs        = 16   // offsetof(struct seccomp_data, args)
+	seccompRetKillProc     = 0x80000000
+	seccompRetKill         = 0x00000000
+	seccompRetTrap         = 0x00030000
+	seccompRetErrno        = 0x00050000
+	seccompRetTrace        = 0x7ff00000
+	seccompRetLog          = 0x7ffc0000
+	seccompRetAllow        = 0x7fff0000
+)
+
+// namespaceCloneFlags are the clone(2) flags that create new namespaces
+const namespaceCloneFlags = syscall.CLONE_NEWNS | syscall.CLONE_NEWUTS | syscall.CLONE_NEWIPC |
+	syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET | 0x02000000 // CLONE_NEWCGROUP
+
+// defaultSeccompDenied lists the syscalls the default profile rejects with EPERM:
+// mounting and namespace manipulation, kernel keyrings, tracing other processes,
+// BPF, perf events and loading kernel code. Names unknown on this architecture are skipped.
+var defaultSeccompDenied = []string{
+	"mount", "umount2", "pivot_root", "open_tree", "move_mount", "fsopen", "fsconfig", "fsmount", "fspick", "mount_setattr",
+	"unshare", "setns",
+	"keyctl", "add_key", "request_key",
+	"ptrace", "process_vm_readv", "process_vm_writev",
+	"bpf", "perf_event_open", "userfaultfd",
+	"kexec_load", "kexec_file_load", "init_module", "finit_module", "delete_module",
+	"open_by_handle_at", "name_to_handle_at",
+	"reboot", "swapon", "swapoff", "acct", "quotactl", "iopl", "ioperm",
+}
+
+// seccompProfile is a seccomp filter in the Docker/OCI JSON profile format
+type seccompProfile struct {
+	DefaultAction   string           `json:"defaultAction"`
+	DefaultErrnoRet *uint32          `json:"defaultErrnoRet,omitempty"`
+	Architectures   []string         `json:"architectures,omitempty"`
+	ArchMap         []seccompArchMap `json:"archMap,omitempty"`
+	Syscalls        []seccompRule    `json:"syscalls,omitempty"`
+}
+
+// seccompArchMap is the Docker form of listing supported architectures
+type seccompArchMap struct {
+	Architecture     string   `json:"architecture"`
+	SubArchitectures []string `json:"subArchitectures,omitempty"`
+}
+
+// seccompRule applies an action to a set of syscalls, optionally only when
+// all argument conditions match
+type seccompRule struct {
+	Names    []string         `json:"names,omitempty"`
+	Name     string           `json:"name,omitempty"`
+	Action   string           `json:"action"`
+	ErrnoRet *uint32          `json:"errnoRet,omitempty"`
+	Args     []seccompArg     `json:"args,omitempty"`
+	Includes seccompCondition `json:"includes,omitempty"`
+	Excludes seccompCondition `json:"excludes,omitempty"`
+}
+
+// seccompArg compares one syscall argument (SCMP_CMP_*)
+type seccompArg struct {
+	Index    uint   `json:"index"`
+	Value    uint64 `json:"value"`
+	ValueTwo uint64 `json:"valueTwo,omitempty"`
+	Op       string `json:"op"`
+}
+
+// seccompCondition limits a Docker profile rule to some capabilities or architectures
+type seccompCondition struct {
+	Caps   []string `json:"caps,omitempty"`
+	Arches []string `json:"arches,omitempty"`
+}
+
+// defaultSeccompProfile returns the built-in profile: everything is allowed except
+// defaultSeccompDenied, creating namespaces through clone(2), and clone3(2),
+// whose flags cannot be inspected (ENOSYS makes libc fall back to clone).
+func defaultSeccompProfile() *seccompProfile {
+	return &seccompProfile{
+		DefaultAction: "SCMP_ACT_ALLOW",
+		Syscalls: []seccompRule{
+			{Names: defaultSeccompDenied, Action: "SCMP_ACT_ERRNO"},
+			{Names: []string{"clone"}, Action: "SCMP_ACT_ALLOW", Args: []seccompArg{
+				{Index: 0, Value: namespaceCloneFlags, ValueTwo: 0, Op: "SCMP_CMP_MASKED_EQ"},
+			}},
+			{Names: []string{"clone"}, Action: "SCMP_ACT_ERRNO"},
+			{Names: []string{"clone3"}, Action: "SCMP_ACT_ERRNO", ErrnoRet: uint32Ptr(uint32(syscall.ENOSYS))},
+		},
+	}
+}
+
+// uint32Ptr returns a pointer to v
+func uint32Ptr(v uint32) *uint32 {
+	return &v
+}
+
+// loadSeccompProfile returns the profile selected by Spec.Seccomp: the built-in
+// default, nil for unconfined, or a Docker/OCI JSON profile read from a file
+func loadSeccompProfile(setting string) (*seccompProfile, error) {
+	switch setting {
+	case "", SeccompDefault:
+		return defaultSeccompProfile(), nil
+	case SeccompUnconfined:
+		return nil, nil
+	}
+
+	data, err := os.ReadFile(setting) //nolint:gosec // Profile path is chosen by the user
+	if err != nil {
+		return nil, fmt.Errorf("reading seccomp profile: %w", err)
+	}
+
+	var profile seccompProfile
+	if err := json.Unmarshal(data, &profile); err != nil {
+		return nil, fmt.Errorf("parsing seccomp profile %s: %w", setting, err)
+	}
+	if profile.DefaultAction == "" {
+		return nil, fmt.Errorf("seccomp profile %s has no defaultAction", setting)
+	}
+
+	return &profile, nil
+}
+
+// seccompAction converts an SCMP_ACT_* action name to a filter return value
+func seccompAction(action string, errnoRet *uint32) (uint32, error) {
+	errno := uint32(syscall.EPERM)
+	if errnoRet != nil {
+		errno = *errnoRet
+	}
+
+	switch action {
+	case "SCMP_ACT_ALLOW":
+		return seccompRetAllow, nil
+	case "SCMP_ACT_ERRNO":
+		return seccompRetErrno | (errno & 0xffff), nil //nolint:mnd // SECCOMP_RET_DATA
+	case "SCMP_ACT_KILL", "SCMP_ACT_KILL_THREAD":
+		return seccompRetKill, nil
+	case "SCMP_ACT_KILL_PROCESS":
+		return seccompRetKillProc, nil
+	case "SCMP_ACT_TRAP":
+		return seccompRetTrap, nil
+	case "SCMP_ACT_TRACE":
+		return seccompRetTrace | (errno & 0xffff), nil //nolint:mnd // SECCOMP_RET_DATA
+	case "SCMP_ACT_LOG":
+		return seccompRetLog, nil
+	default:
+		return 0, fmt.Errorf("unsupported seccomp action %q", action)
+	}
+}
+
+// appliesHere reports whether a Docker profile rule applies to a jailed process.
+// Jail grants no capabilities, so rules that require one are skipped.
+func (r seccompRule) appliesHere() bool {
+	if len(r.Includes.Caps) > 0 {
+		return false
+	}
+	if len(r.Includes.Arches) > 0 && !slices.Contains(r.Includes.Arches, runtime.GOARCH) {
+		return false
+	}
+	return !slices.Contains(r.Excludes.Arches, runtime.GOARCH)
+}
+
+// supportsArch reports whether the profile covers this architecture
+func (p *seccompProfile) supportsArch() bool {
+	if len(p.Architectures) == 0 && len(p.ArchMap) == 0 {
+		return true
+	}
+	if slices.Contains(p.Architectures, seccompArchName) {
+		return true
+	}
+	for _, m := range p.ArchMap {
+		if m.Architecture == seccompArchName {
+			return true
+		}
+	}
+	return false
+}
+
+// bpfProgram assembles a classic BPF program with forward jumps to labels
+type bpfProgram struct {
+	insns  []syscall.SockFilter
+	fixups map[int][2]string // instruction index -> jt/jf label
+	labels map[string]int
+	next   int // counter for unique labels
+}
+
+// label returns a new unique label name
+func (b *bpfProgram) label() string {
+	b.next++
+	return fmt.Sprintf("L%d", b.next)
+}
+
+// mark binds a label to the next instruction
+func (b *bpfProgram) mark(label string) {
+	b.labels[label] = len(b.insns)
+}
+
+// stmt appends a non-jump instruction
+func (b *bpfProgram) stmt(code uint16, k uint32) {
+	b.insns = append(b.insns, syscall.SockFilter{Code: code, K: k})
+}
+
+// jump appends a conditional jump; an empty label means the next instruction
+func (b *bpfProgram) jump(code uint16, k uint32, jt, jf string) {
+	b.fixups[len(b.insns)] = [2]string{jt, jf}
+	b.insns = append(b.insns, syscall.SockFilter{Code: syscall.BPF_JMP | code | syscall.BPF_K, K: k})
+}
+
+// resolve fills in jump offsets and returns the finished program
+func (b *bpfProgram) resolve() ([]syscall.SockFilter, error) {
+	for i, targets := range b.fixups {
+		offsets := [2]uint8{}
+		for j, label := range targets {
+			if label == "" {
+				continue
+			}
+			target, ok := b.labels[label]
+			if !ok {
+				return nil, fmt.Errorf("undefined label %s", label)
+			}
+			offset := target - i - 1
+			if offset < 0 || offset > seccompMaxJump {
+				return nil, fmt.Errorf("jump offset %d out of range", offset)
+			}
+			offsets[j] = uint8(offset)
+		}
+		b.insns[i].Jt, b.insns[i].Jf = offsets[0], offsets[1]
+	}
+	if len(b.insns) > seccompMaxProgram {
+		return nil, fmt.Errorf("seccomp filter too long (%d instructions)", len(b.insns))
+	}
+	return b.insns, nil
+}
+
+// compileArg emits a 64-bit comparison of one syscall argument, jumping to
+// fail when it does not hold and falling through when it does
+func (b *bpfProgram) compileArg(arg seccompArg, fail string) error {
+	if arg.Index > 5 { //nolint:mnd // syscalls have six arguments
+		return fmt.Errorf("invalid seccomp argument index %d", arg.Index)
+	}
+	lo := uint32(seccompDataArgs + 8*arg.Index) //nolint:mnd // args are 64-bit, little endian
+	hi := lo + 4                                //nolint:mnd // upper half of the argument
+	pass := b.label()
+	load := func(offset uint32) { b.stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, offset) }
+	vHi, vLo := uint32(arg.Value>>32), uint32(arg.Value) //nolint:gosec,mnd // splitting into 32-bit halves
+
+	switch arg.Op {
+	case "SCMP_CMP_EQ":
+		load(hi)
+		b.jump(syscall.BPF_JEQ, vHi, "", fail)
+		load(lo)
+		b.jump(syscall.BPF_JEQ, vLo, "", fail)
+	case "SCMP_CMP_NE":
+		load(hi)
+		b.jump(syscall.BPF_JEQ, vHi, "", pass)
+		load(lo)
+		b.jump(syscall.BPF_JEQ, vLo, fail, "")
+	case "SCMP_CMP_MASKED_EQ":
+		dHi, dLo := uint32(arg.ValueTwo>>32), uint32(arg.ValueTwo) //nolint:gosec,mnd // splitting into 32-bit halves
+		load(hi)
+		b.stmt(syscall.BPF_ALU|syscall.BPF_AND|syscall.BPF_K, vHi)
+		b.jump(syscall.BPF_JEQ, dHi, "", fail)
+		load(lo)
+		b.stmt(syscall.BPF_ALU|syscall.BPF_AND|syscall.BPF_K, vLo)
+		b.jump(syscall.BPF_JEQ, dLo, "", fail)
+	case "SCMP_CMP_GT", "SCMP_CMP_GE":
+		// hi > vHi, or hi == vHi and lo > (or >=) vLo
+		loJump := uint16(syscall.BPF_JGT)
+		if arg.Op == "SCMP_CMP_GE" {
+			loJump = syscall.BPF_JGE
+		}
+		load(hi)
+		b.jump(syscall.BPF_JGT, vHi, pass, "")
+		b.jump(syscall.BPF_JEQ, vHi, "", fail)
+		load(lo)
+		b.jump(loJump, vLo, "", fail)
+	case "SCMP_CMP_LT", "SCMP_CMP_LE":
+		// hi < vHi, or hi == vHi and lo < (or <=) vLo
+		loJump := uint16(syscall.BPF_JGE)
+		if arg.Op == "SCMP_CMP_LE" {
+			loJump = syscall.BPF_JGT
+		}
+		load(hi)
+		b.jump(syscall.BPF_JGE, vHi, "", pass)
+		b.jump(syscall.BPF_JEQ, vHi, "", fail)
+		load(lo)
+		b.jump(loJump, vLo, fail, "")
+	default:
+		return fmt.Errorf("unsupported seccomp comparison %q", arg.Op)
+	}
+
+	b.mark(pass)
+	return nil
+}
+
+// compileSeccompProfile translates a profile into a BPF program for this
+// architecture. Rules are checked in order and the first match decides.
+// Syscall names unknown on this architecture are ignored.
+func compileSeccompProfile(p *seccompProfile) ([]syscall.SockFilter, error) {
+	if seccompAuditArch == 0 {
+		return nil, fmt.Errorf("seccomp filtering is not supported on %s", runtime.GOARCH)
+	}
+	if !p.supportsArch() {
+		return nil, fmt.Errorf("seccomp profile does not support %s", seccompArchName)
+	}
+
+	defaultAction, err := seccompAction(p.DefaultAction, p.DefaultErrnoRet)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &bpfProgram{fixups: map[int][2]string{}, labels: map[string]int{}}
+	loadNr := func() { b.stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataNr) }
+
+	// Kill anything not using this architecture's syscall ABI
+	archOK := b.label()
+	b.stmt(syscall.BPF_LD|syscall.BPF_W|syscall.BPF_ABS, seccompDataArch)
+	b.jump(syscall.BPF_JEQ, seccompAuditArch, archOK, "")
+	b.stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetKillProc)
+	b.mark(archOK)
+
+	loadNr()
+	if seccompX32Bit != 0 {
+		nativeABI := b.label()
+		b.jump(syscall.BPF_JGE, seccompX32Bit, "", nativeABI)
+		b.stmt(syscall.BPF_RET|syscall.BPF_K, seccompRetErrno|uint32(syscall.EPERM))
+		b.mark(nativeABI)
+	}
+
+	for _, rule := range p.Syscalls {
+		if !rule.appliesHere() {
+			continue
+		}
+		action, err := seccompAction(rule.Action, rule.ErrnoRet)
+		if err != nil {
+			return nil, err
+		}
+
+		names := rule.Names
+		if rule.Name != "" {
+			names = append([]string{rule.Name}, names...)
+		}
+		for _, name := range names {
+			nr, ok := syscallNumbers[name]
+			if !ok {
+				continue
+			}
+
+			next := b.label()
+			b.jump(syscall.BPF_JEQ, uint32(nr), "", next) //nolint:gosec // syscall numbers are small
+			for _, arg := range rule.Args {
+				if err := b.compileArg(arg, next); err != nil {
+					return nil, fmt.Errorf("syscall %s: %w", name, err)
+				}
+			}
+			b.stmt(syscall.BPF_RET|syscall.BPF_K, action)
+			b.mark(next)
+			if len(rule.Args) > 0 {
+				// Argument checks overwrote the accumulator
+				loadNr()
+			}
+		}
+	}
+
+	b.stmt(syscall.BPF_RET|syscall.BPF_K, defaultAction)
+	return b.resolve()
+}
+
+// installSeccompFilter sets no_new_privs and installs the filter on every
+// thread of the calling process, so that none of the Go runtime's threads stays
+// unfiltered in an init process that remains PID 1 of the jail. The caller must
+// hold the thread (runtime.LockOSThread) until it execs.
+func installSeccompFilter(filter []syscall.SockFilter) error {
+	nr, ok := syscallNumbers["seccomp"]
+	if !ok {
+		return fmt.Errorf("installing seccomp filter: not supported on %s", runtime.GOARCH)
+	}
+	if _, _, errno := syscall.RawSyscall6(syscall.SYS_PRCTL, prSetNoNewPrivs, 1, 0, 0, 0, 0); errno != 0 {
+		return fmt.Errorf("setting no_new_privs: %w", errno)
+	}
+
+	prog := syscall.SockFprog{
+		Len:    uint16(len(filter)), //nolint:gosec // length is bounded by seccompMaxProgram
+		Filter: &filter[0],
+	}
+	// TSYNC also sets no_new_privs on the other threads; on failure it returns
+	// the id of a thread it could not synchronize
+	//nolint:gosec // seccomp requires passing a pointer to struct sock_fprog
+	tid, _, errno := syscall.RawSyscall(uintptr(nr), seccompSetModeFilter, seccompFilterFlagTsync, uintptr(unsafe.Pointer(&prog)))
+	if errno != 0 {
+		return fmt.Errorf("installing seccomp filter: %w", errno)
+	}
+	if tid != 0 {
+		return fmt.Errorf("installing seccomp filter: thread %d cannot be synchronized", tid)
+	}
+
+	return nil
+}
+
+// SeccompArch returns the OCI name of the architecture seccomp filters are
+// compiled for, or "" if syscalls cannot be filtered on this architecture
+func SeccompArch() string {
+	return seccompArchName
+}
+
+// loadSeccompFilter compiles the filter selected by a Spec.Seccomp setting.
+// It returns nil when seccomp is unconfined.
+func loadSeccompFilter(setting string) ([]syscall.SockFilter, error) {
+	profile, err := loadSeccompProfile(setting)
+	if err != nil || profile == nil {
+		return nil, err
+	}
+
+	filter, err := compileSeccompProfile(profile)
+	if err != nil {
+		return nil, fmt.Errorf("seccomp profile %s: %w", setting, err)
+	}
+	return filter, nil
+}
@@ -0,0 +1,13 @@
+//go:build !amd64 && !arm64
+
+package jail
+
+// Seccomp filtering is only implemented for amd64 and arm64
+const (
+	seccompAuditArch = 0
+	seccompArchName  = ""
+	seccompX32Bit    = 0
+)
+
+// syscallNumbers is empty on architectures without seccomp support
+var syscallNumbers = map[string]int{}
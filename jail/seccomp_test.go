@@ -0,0 +1,291 @@
+package jail
+
+import (
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runSeccompFilter evaluates a compiled filter against a syscall the way the
+// kernel would and returns the filter's verdict
+func runSeccompFilter(t *testing.T, filter []syscall.SockFilter, arch uint32, nr int, args ...uint64) uint32 {
+	t.Helper()
+
+	data := make([]byte, seccompDataArgs+6*8)
+	binary.LittleEndian.PutUint32(data[seccompDataNr:], uint32(nr))
+	binary.LittleEndian.PutUint32(data[seccompDataArch:], arch)
+	for i, arg := range args {
+		binary.LittleEndian.PutUint64(data[seccompDataArgs+8*i:], arg)
+	}
+
+	var acc uint32
+	for pc := 0; pc < len(filter); pc++ {
+		insn := filter[pc]
+		switch insn.Code {
+		case syscall.BPF_LD | syscall.BPF_W | syscall.BPF_ABS:
+			acc = binary.LittleEndian.Uint32(data[insn.K:])
+		case syscall.BPF_ALU | syscall.BPF_AND | syscall.BPF_K:
+			acc &= insn.K
+		case syscall.BPF_RET | syscall.BPF_K:
+			return insn.K
+		case syscall.BPF_JMP | syscall.BPF_JEQ | syscall.BPF_K,
+			syscall.BPF_JMP | syscall.BPF_JGT | syscall.BPF_K,
+			syscall.BPF_JMP | syscall.BPF_JGE | syscall.BPF_K:
+			var cond bool
+			switch insn.Code &^ (syscall.BPF_JMP | syscall.BPF_K) {
+			case syscall.BPF_JEQ:
+				cond = acc == insn.K
+			case syscall.BPF_JGT:
+				cond = acc > insn.K
+			case syscall.BPF_JGE:
+				cond = acc >= insn.K
+			}
+			if cond {
+				pc += int(insn.Jt)
+			} else {
+				pc += int(insn.Jf)
+			}
+		default:
+			t.Fatalf("unexpected instruction %#x at %d", insn.Code, pc)
+		}
+	}
+
+	t.Fatal("filter ran off the end")
+	return 0
+}
+
+// TestSeccompAction tests conversion of profile actions to filter return values
+func TestSeccompAction(t *testing.T) {
+	t.Run("errno defaults to EPERM", func(t *testing.T) {
+		action, err := seccompAction("SCMP_ACT_ERRNO", nil)
+
+		require.NoError(t, err)
+		assert.Equal(t, uint32(seccompRetErrno|uint32(syscall.EPERM)), action)
+	})
+
+	t.Run("errno with errnoRet", func(t *testing.T) {
+		action, err := seccompAction("SCMP_ACT_ERRNO", uint32Ptr(uint32(syscall.ENOSYS)))
+
+		require.NoError(t, err)
+		assert.Equal(t, uint32(seccompRetErrno|uint32(syscall.ENOSYS)), action)
+	})
+
+	t.Run("other actions", func(t *testing.T) {
+		for name, want := range map[string]uint32{
+			"SCMP_ACT_ALLOW":        seccompRetAllow,
+			"SCMP_ACT_KILL":         seccompRetKill,
+			"SCMP_ACT_KILL_THREAD":  seccompRetKill,
+			"SCMP_ACT_KILL_PROCESS": seccompRetKillProc,
+			"SCMP_ACT_TRAP":         seccompRetTrap,
+			"SCMP_ACT_LOG":          seccompRetLog,
+		} {
+			action, err := seccompAction(name, nil)
+
+			require.NoError(t, err, name)
+			assert.Equal(t, want, action, name)
+		}
+	})
+
+	t.Run("unsupported action", func(t *testing.T) {
+		_, err := seccompAction("SCMP_ACT_NOTIFY", nil)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "SCMP_ACT_NOTIFY")
+	})
+}
+
+// TestLoadSeccompProfile tests selecting and reading seccomp profiles
+func TestLoadSeccompProfile(t *testing.T) {
+	t.Run("default profile", func(t *testing.T) {
+		profile, err := loadSeccompProfile(SeccompDefault)
+
+		require.NoError(t, err)
+		assert.Equal(t, "SCMP_ACT_ALLOW", profile.DefaultAction)
+	})
+
+	t.Run("unconfined", func(t *testing.T) {
+		profile, err := loadSeccompProfile(SeccompUnconfined)
+
+		require.NoError(t, err)
+		assert.Nil(t, profile)
+	})
+
+	t.Run("docker profile", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "profile.json")
+		content := `{
+			"defaultAction": "SCMP_ACT_ERRNO",
+			"defaultErrnoRet": 1,
+			"archMap": [{"architecture": "SCMP_ARCH_X86_64", "subArchitectures": ["SCMP_ARCH_X86"]}],
+			"syscalls": [
+				{"names": ["read", "write"], "action": "SCMP_ACT_ALLOW", "comment": "ignored"},
+				{"names": ["personality"], "action": "SCMP_ACT_ALLOW",
+				 "args": [{"index": 0, "value": 8, "valueTwo": 0, "op": "SCMP_CMP_EQ"}]}
+			]
+		}`
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+
+		profile, err := loadSeccompProfile(path)
+
+		require.NoError(t, err)
+		assert.Equal(t, "SCMP_ACT_ERRNO", profile.DefaultAction)
+		require.Len(t, profile.Syscalls, 2)
+		assert.Equal(t, []string{"read", "write"}, profile.Syscalls[0].Names)
+		assert.Equal(t, seccompArg{Index: 0, Value: 8, Op: "SCMP_CMP_EQ"}, profile.Syscalls[1].Args[0])
+	})
+
+	t.Run("missing defaultAction", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "profile.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{"syscalls": []}`), 0644))
+
+		_, err := loadSeccompProfile(path)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "defaultAction")
+	})
+
+	t.Run("invalid JSON", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "profile.json")
+		require.NoError(t, os.WriteFile(path, []byte(`{`), 0644))
+
+		_, err := loadSeccompProfile(path)
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), path)
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		_, err := loadSeccompProfile("/nonexistent/profile.json")
+
+		require.Error(t, err)
+	})
+}
+
+// TestCompileSeccompProfile tests the generated filter by evaluating it
+func TestCompileSeccompProfile(t *testing.T) {
+	if seccompAuditArch == 0 {
+		t.Skip("seccomp filtering is not supported on this architecture")
+	}
+	eperm := uint32(seccompRetErrno | uint32(syscall.EPERM))
+
+	t.Run("default profile", func(t *testing.T) {
+		filter, err := compileSeccompProfile(defaultSeccompProfile())
+		require.NoError(t, err)
+
+		run := func(name string, args ...uint64) uint32 {
+			return runSeccompFilter(t, filter, seccompAuditArch, syscallNumbers[name], args...)
+		}
+		assert.Equal(t, eperm, run("mount"))
+		assert.Equal(t, eperm, run("unshare"))
+		assert.Equal(t, eperm, run("ptrace"))
+		assert.Equal(t, eperm, run("keyctl"))
+		assert.Equal(t, uint32(seccompRetAllow), run("read"))
+		assert.Equal(t, uint32(seccompRetAllow), run("mkdir"))
+		assert.Equal(t, uint32(seccompRetErrno|uint32(syscall.ENOSYS)), run("clone3"))
+
+		// Threads and plain forks are fine, new namespaces are not
+		assert.Equal(t, uint32(seccompRetAllow), run("clone", syscall.CLONE_VM|syscall.CLONE_THREAD))
+		assert.Equal(t, eperm, run("clone", syscall.CLONE_NEWUSER))
+		assert.Equal(t, eperm, run("clone", uint64(syscall.CLONE_NEWNS)|1<<40))
+	})
+
+	t.Run("foreign architecture is killed", func(t *testing.T) {
+		filter, err := compileSeccompProfile(defaultSeccompProfile())
+		require.NoError(t, err)
+
+		assert.Equal(t, uint32(seccompRetKillProc), runSeccompFilter(t, filter, 0x40000003, 0))
+	})
+
+	t.Run("first matching rule wins", func(t *testing.T) {
+		filter, err := compileSeccompProfile(&seccompProfile{
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls: []seccompRule{
+				{Name: "mkdir", Action: "SCMP_ACT_ERRNO", ErrnoRet: uint32Ptr(uint32(syscall.EACCES))},
+				{Names: []string{"mkdir", "rmdir"}, Action: "SCMP_ACT_KILL"},
+				{Names: []string{"no_such_syscall"}, Action: "SCMP_ACT_KILL"},
+			},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, uint32(seccompRetErrno|uint32(syscall.EACCES)), runSeccompFilter(t, filter, seccompAuditArch, syscallNumbers["mkdir"]))
+		assert.Equal(t, uint32(seccompRetKill), runSeccompFilter(t, filter, seccompAuditArch, syscallNumbers["rmdir"]))
+		assert.Equal(t, uint32(seccompRetAllow), runSeccompFilter(t, filter, seccompAuditArch, syscallNumbers["read"]))
+	})
+
+	t.Run("argument comparisons", func(t *testing.T) {
+		big := uint64(1) << 33
+		cases := []struct {
+			op    string
+			value uint64
+			match []uint64
+			miss  []uint64
+		}{
+			{"SCMP_CMP_EQ", big + 5, []uint64{big + 5}, []uint64{5, big, big + 6}},
+			{"SCMP_CMP_NE", big + 5, []uint64{5, big, big + 6}, []uint64{big + 5}},
+			{"SCMP_CMP_GT", big + 5, []uint64{big + 6, big << 1}, []uint64{big + 5, big + 4, 1 << 32}},
+			{"SCMP_CMP_GE", big + 5, []uint64{big + 5, big << 1}, []uint64{big + 4, 7}},
+			{"SCMP_CMP_LT", big + 5, []uint64{big + 4, 7}, []uint64{big + 5, big << 1}},
+			{"SCMP_CMP_LE", big + 5, []uint64{big + 5, 7}, []uint64{big + 6, big << 1}},
+		}
+
+		for _, c := range cases {
+			filter, err := compileSeccompProfile(&seccompProfile{
+				DefaultAction: "SCMP_ACT_ALLOW",
+				Syscalls: []seccompRule{{
+					Names:  []string{"personality"},
+					Action: "SCMP_ACT_KILL",
+					Args:   []seccompArg{{Index: 2, Value: c.value, Op: c.op}},
+				}},
+			})
+			require.NoError(t, err, c.op)
+
+			nr := syscallNumbers["personality"]
+			for _, v := range c.match {
+				assert.Equal(t, uint32(seccompRetKill), runSeccompFilter(t, filter, seccompAuditArch, nr, 0, 0, v), "%s %d", c.op, v)
+			}
+			for _, v := range c.miss {
+				assert.Equal(t, uint32(seccompRetAllow), runSeccompFilter(t, filter, seccompAuditArch, nr, 0, 0, v), "%s %d", c.op, v)
+			}
+		}
+	})
+
+	t.Run("rules requiring capabilities are skipped", func(t *testing.T) {
+		filter, err := compileSeccompProfile(&seccompProfile{
+			DefaultAction: "SCMP_ACT_ERRNO",
+			Syscalls: []seccompRule{
+				{Names: []string{"mount"}, Action: "SCMP_ACT_ALLOW", Includes: seccompCondition{Caps: []string{"CAP_SYS_ADMIN"}}},
+				{Names: []string{"read"}, Action: "SCMP_ACT_ALLOW"},
+			},
+		})
+		require.NoError(t, err)
+
+		assert.Equal(t, eperm, runSeccompFilter(t, filter, seccompAuditArch, syscallNumbers["mount"]))
+		assert.Equal(t, uint32(seccompRetAllow), runSeccompFilter(t, filter, seccompAuditArch, syscallNumbers["read"]))
+	})
+
+	t.Run("profile for another architecture", func(t *testing.T) {
+		_, err := compileSeccompProfile(&seccompProfile{
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Architectures: []string{"SCMP_ARCH_PPC64LE"},
+		})
+
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), seccompArchName)
+	})
+
+	t.Run("invalid argument index", func(t *testing.T) {
+		_, err := compileSeccompProfile(&seccompProfile{
+			DefaultAction: "SCMP_ACT_ALLOW",
+			Syscalls: []seccompRule{{
+				Names: []string{"read"}, Action: "SCMP_ACT_KILL",
+				Args: []seccompArg{{Index: 6, Op: "SCMP_CMP_EQ"}},
+			}},
+		})
+
+		require.Error(t, err)
+	})
+}